@@ -0,0 +1,130 @@
+/*
+ Copyright (c) 2024 Neeraj Jakhar
+
+ This program is free software: you can redistribute it and/or modify
+ it under the terms of the GNU General Public License as published by
+ the Free Software Foundation, either version 3 of the License, or
+ (at your option) any later version.
+
+ This program is distributed in the hope that it will be useful,
+ but WITHOUT ANY WARRANTY; without even the implied warranty of
+ MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License
+ along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/homebackend/go-webrtc/pkg/config"
+	"github.com/homebackend/go-webrtc/pkg/session"
+)
+
+const maxApiRequestSize = 64 * 1024
+
+// ApiRoute is an admin api endpoint, served in all signalling modes
+type ApiRoute struct {
+	Path    string
+	Handler http.HandlerFunc
+}
+
+// apiRoutes returns the admin api, the manager is nil in http signalling mode
+func apiRoutes(config *config.Configuration, settings *config.Settings, sessions session.SessionManager, manager *Manager) []ApiRoute {
+	return []ApiRoute{
+		{Path: "/api/overlay", Handler: serveOverlay(config, settings)},
+		{Path: "/api/privacy-masks", Handler: servePrivacyMasks(config, settings)},
+		{Path: "/api/sessions", Handler: serveSessions(config, sessions, manager)},
+	}
+}
+
+// authenticate checks basic auth credentials against the signalling
+// credentials, if any are configured
+func authenticate(config *config.Configuration, r *http.Request) (*config.SignallingUser, bool) {
+	user, password, ok := r.BasicAuth()
+	if !ok && len(config.SignallingCredentials) > 0 {
+		return nil, false
+	}
+
+	return config.Authenticate(user, password)
+}
+
+// adminApiEnabled checks that signalling credentials are configured and
+// responds with an error if not. Without credentials anybody could use the
+// admin api, so it is refused.
+func adminApiEnabled(config *config.Configuration, w http.ResponseWriter) bool {
+	if len(config.SignallingCredentials) == 0 {
+		http.Error(w, "The admin api requires signalling credentials", http.StatusForbidden)
+		return false
+	}
+
+	return true
+}
+
+func unauthorized(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", `Basic realm="gowebrtc"`)
+	http.Error(w, "Unauthorized", http.StatusUnauthorized)
+}
+
+// permitted checks that the user authenticated by the request has the
+// permission and responds with an error if not
+func permitted(config *config.Configuration, w http.ResponseWriter, r *http.Request, permission string) bool {
+	if !adminApiEnabled(config, w) {
+		return false
+	} else if user, ok := authenticate(config, r); !ok {
+		unauthorized(w)
+		return false
+	} else if !user.Can(permission) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return false
+	}
+
+	return true
+}
+
+// withCredentials requires basic auth with signalling credentials for the
+// handler
+func withCredentials(config *config.Configuration, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !adminApiEnabled(config, w) {
+			return
+		} else if _, ok := authenticate(config, r); !ok {
+			unauthorized(w)
+			return
+		}
+
+		handler(w, r)
+	}
+}
+
+// withPermission requires a signalling user with the permission for the
+// handler
+func withPermission(config *config.Configuration, permission string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if permitted(config, w, r, permission) {
+			handler(w, r)
+		}
+	}
+}
+
+// decodeRequest unmarshals and validates the JSON body of an api request
+func decodeRequest(r *http.Request, v interface{}) error {
+	if err := json.NewDecoder(http.MaxBytesReader(nil, r.Body, maxApiRequestSize)).Decode(v); err != nil {
+		return err
+	}
+
+	return validate.Struct(v)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Println(err)
+	}
+}
@@ -0,0 +1,76 @@
+/*
+ Copyright (c) 2024 Neeraj Jakhar
+
+ This program is free software: you can redistribute it and/or modify
+ it under the terms of the GNU General Public License as published by
+ the Free Software Foundation, either version 3 of the License, or
+ (at your option) any later version.
+
+ This program is distributed in the hope that it will be useful,
+ but WITHOUT ANY WARRANTY; without even the implied warranty of
+ MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License
+ along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/homebackend/go-webrtc/pkg/config"
+)
+
+func TestAdminApiRequiresCredentials(t *testing.T) {
+	requests := []struct{ method, path, body string }{
+		{http.MethodPut, "/api/overlay", `{"text": "test"}`},
+		{http.MethodGet, "/api/privacy-masks", ""},
+		{http.MethodGet, "/api/sessions", ""},
+		{http.MethodDelete, "/api/sessions?id=test", ""},
+	}
+
+	serve := func(configuration *config.Configuration, method, path, body string, user *config.UserCredentials) int {
+		router := http.NewServeMux()
+		for _, route := range apiRoutes(configuration, config.NewSettings(configuration), newTestSessions(), nil) {
+			router.HandleFunc(route.Path, route.Handler)
+		}
+
+		request := httptest.NewRequest(method, path, strings.NewReader(body))
+		if user != nil {
+			request.SetBasicAuth(user.User, user.Password)
+		}
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, request)
+		return recorder.Code
+	}
+
+	unconfigured := &config.Configuration{
+		Overlay: &config.OverlayConfiguration{},
+		Privacy: &config.PrivacyConfiguration{},
+	}
+	for _, test := range requests {
+		if status := serve(unconfigured, test.method, test.path, test.body, nil); status != http.StatusForbidden {
+			t.Errorf("%s %s without credentials configured: expected status %d, got %d", test.method, test.path, http.StatusForbidden, status)
+		}
+	}
+
+	admin := config.UserCredentials{User: "admin", Password: "secret"}
+	configured := &config.Configuration{
+		Overlay:               unconfigured.Overlay,
+		Privacy:               unconfigured.Privacy,
+		SignallingCredentials: []config.SignallingUser{{UserCredentials: admin}},
+	}
+	for _, test := range requests {
+		if status := serve(configured, test.method, test.path, test.body, nil); status != http.StatusUnauthorized {
+			t.Errorf("%s %s without credentials: expected status %d, got %d", test.method, test.path, http.StatusUnauthorized, status)
+		}
+		if status := serve(configured, test.method, test.path, test.body, &admin); status == http.StatusForbidden || status == http.StatusUnauthorized {
+			t.Errorf("%s %s with credentials: got status %d", test.method, test.path, status)
+		}
+	}
+}
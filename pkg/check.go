@@ -0,0 +1,373 @@
+/*
+ Copyright (c) 2024 Neeraj Jakhar
+
+ This program is free software: you can redistribute it and/or modify
+ it under the terms of the GNU General Public License as published by
+ the Free Software Foundation, either version 3 of the License, or
+ (at your option) any later version.
+
+ This program is distributed in the hope that it will be useful,
+ but WITHOUT ANY WARRANTY; without even the implied warranty of
+ MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License
+ along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-gst/go-gst/gst"
+	"github.com/go-playground/validator/v10"
+	"github.com/homebackend/go-webrtc/pkg/config"
+	"github.com/homebackend/go-webrtc/pkg/media"
+	"gopkg.in/yaml.v3"
+)
+
+// Time a pipeline is given to open its devices
+const pipelineCheckTimeout = 10 * time.Second
+
+// Time a pipeline with an appsrc is given to report errors, as it never
+// finishes pausing
+const fedPipelineCheckTimeout = 2 * time.Second
+
+// yamlValidator validates like the server does, but names fields as they
+// are written in the configuration file
+func yamlValidator() *validator.Validate {
+	v := validator.New()
+	v.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		return name
+	})
+
+	return v
+}
+
+// ConfigurationCheck collects the problems found in a configuration
+type ConfigurationCheck struct {
+	config   *config.Configuration
+	Problems []string
+	Warnings []string
+}
+
+func (c *ConfigurationCheck) problem(format string, args ...interface{}) {
+	c.Problems = append(c.Problems, fmt.Sprintf(format, args...))
+}
+
+func (c *ConfigurationCheck) warning(format string, args ...interface{}) {
+	c.Warnings = append(c.Warnings, fmt.Sprintf(format, args...))
+}
+
+// load reads the configuration like the server does, but reports every
+// problem instead of stopping at the first one. Unknown fields, which the
+// server ignores, are warned about.
+func (c *ConfigurationCheck) load(configFile string) bool {
+	data, err := os.ReadFile(configFile)
+	if err != nil {
+		c.problem("Unable to read configuration: %v", err)
+		return false
+	}
+
+	var conf config.Configuration
+	var typeErrors []string
+	var typeError *yaml.TypeError
+	if err := yaml.NewDecoder(bytes.NewReader(data)).Decode(&conf); errors.As(err, &typeError) {
+		typeErrors = typeError.Errors
+		for _, e := range typeErrors {
+			c.problem("%s", e)
+		}
+	} else if err != nil {
+		c.problem("Unable to parse configuration: %v", err)
+		return false
+	}
+	for _, e := range unknownFields(data, typeErrors) {
+		c.warning("%s", e)
+	}
+	c.config = &conf
+
+	var validationErrors validator.ValidationErrors
+	if err := yamlValidator().Struct(c.config); errors.As(err, &validationErrors) {
+		for _, e := range validationErrors {
+			_, field, _ := strings.Cut(e.Namespace(), ".")
+			c.problem("Invalid value of %s: failed on %s validation", field, e.Tag())
+		}
+	} else if err != nil {
+		c.problem("Unable to validate configuration: %v", err)
+	}
+
+	return true
+}
+
+// unknownFields returns the errors of the fields which the configuration
+// does not have, such as options of older versions. The type errors of the
+// configuration are left out.
+func unknownFields(data []byte, typeErrors []string) []string {
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+
+	var conf config.Configuration
+	var typeError *yaml.TypeError
+	if !errors.As(decoder.Decode(&conf), &typeError) {
+		return nil
+	}
+
+	var unknown []string
+	for _, e := range typeError.Errors {
+		if !slices.Contains(typeErrors, e) {
+			unknown = append(unknown, e)
+		}
+	}
+	return unknown
+}
+
+func (c *ConfigurationCheck) checkTls() {
+	config := c.config
+	if !config.SignallingUsesTls {
+		return
+	}
+
+	if config.Signalling == "http" {
+		c.warning("signalling_uses_tls is ignored by http signalling")
+	}
+
+	if config.SignallingTlsCert == "" || config.SignallingTlsKey == "" {
+		c.problem("signalling_tls_cert and signalling_tls_key are required with signalling_uses_tls")
+		return
+	}
+
+	pair, err := tls.LoadX509KeyPair(config.SignallingTlsCert, config.SignallingTlsKey)
+	if err != nil {
+		c.problem("Invalid TLS certificate or key: %v", err)
+		return
+	}
+
+	certificate, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		c.problem("Invalid TLS certificate: %v", err)
+		return
+	}
+
+	now := time.Now()
+	if now.After(certificate.NotAfter) {
+		c.problem("TLS certificate expired on %s", certificate.NotAfter.Format(time.RFC3339))
+	} else if now.Before(certificate.NotBefore) {
+		c.problem("TLS certificate is not valid before %s", certificate.NotBefore.Format(time.RFC3339))
+	} else if certificate.NotAfter.Sub(now) < 14*24*time.Hour {
+		c.warning("TLS certificate expires on %s", certificate.NotAfter.Format(time.RFC3339))
+	}
+}
+
+func (c *ConfigurationCheck) checkTurn() {
+	config := c.config
+	turn := config.TurnConfiguration
+
+	if !config.UseInternalTurn {
+		if turn != nil {
+			c.warning("turn_configuration is ignored without use_internal_turn")
+		}
+	} else if turn == nil {
+		c.problem("use_internal_turn requires turn_configuration")
+	} else {
+		if len(turn.Users) == 0 {
+			c.problem("Turn server requires at least one user")
+		}
+		if net.ParseIP(turn.PublicIp) == nil {
+			c.problem("Turn server public_ip %s is not an IP address", turn.PublicIp)
+		}
+		if config.OpenRelayConfig != nil {
+			c.warning("open_relay_config is ignored with use_internal_turn")
+		}
+	}
+
+	if config.OpenRelayConfig != nil && (config.OpenRelayConfig.AppName == "" || config.OpenRelayConfig.ApiKey == "") {
+		c.problem("open_relay_config requires app_name and api_key")
+	}
+}
+
+// checkDirectory checks that files can be written to a directory
+func (c *ConfigurationCheck) checkDirectory(name, directory string) {
+	file, err := os.CreateTemp(directory, ".gowebrtc-check")
+	if err != nil {
+		c.problem("Unable to write to %s: %v", name, err)
+		return
+	}
+
+	file.Close()
+	os.Remove(file.Name())
+}
+
+func (c *ConfigurationCheck) checkFiles() {
+	recordingDirectory := c.config.RecordingDirectory
+	if recordingDirectory == "" {
+		recordingDirectory = DefaultRecordingDirectory
+	}
+	c.checkDirectory("recording_directory", recordingDirectory)
+
+	if c.config.Privacy != nil && c.config.Privacy.File != "" {
+		c.checkDirectory("directory of privacy file", filepath.Dir(c.config.Privacy.File))
+	}
+}
+
+// pipelineError returns the error posted by a pipeline, if there is one
+func pipelineError(pipeline *gst.Pipeline, err error) error {
+	if msg := pipeline.GetBus().TimedPopFiltered(0, gst.MessageError); msg != nil {
+		return msg.ParseError()
+	}
+
+	return err
+}
+
+// fedByProcess returns whether the pipeline has an appsrc, branches behind
+// which only receive data from the running capture process
+func fedByProcess(pipeline *gst.Pipeline) bool {
+	sources, err := pipeline.GetSourceElements()
+	if err != nil {
+		return false
+	}
+
+	for _, source := range sources {
+		if factory := source.GetFactory(); factory != nil && factory.GetName() == "appsrc" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkPipeline constructs a pipeline and, if the devices are to be opened,
+// brings it to PAUSED. Pipelines with an appsrc cannot preroll, they pass if
+// the devices were opened without errors.
+func checkPipeline(description string, devices bool) error {
+	pipeline, err := gst.NewPipelineFromString(description)
+	if err != nil {
+		return err
+	}
+	defer pipeline.SetState(gst.StateNull)
+
+	if !devices {
+		return nil
+	}
+
+	if err := pipeline.SetState(gst.StatePaused); err != nil {
+		return pipelineError(pipeline, err)
+	}
+
+	fed := fedByProcess(pipeline)
+	timeout := pipelineCheckTimeout
+	if fed {
+		timeout = fedPipelineCheckTimeout
+	}
+
+	// Live sources do not preroll either, which is reported as success
+	switch ret, _ := pipeline.GetState(gst.StatePaused, gst.ClockTime(timeout)); ret {
+	case gst.StateChangeFailure:
+		return pipelineError(pipeline, errors.New("pipeline could not be paused"))
+	case gst.StateChangeAsync:
+		if fed {
+			return pipelineError(pipeline, nil)
+		}
+		return fmt.Errorf("pipeline did not pause within %s", pipelineCheckTimeout)
+	}
+
+	return nil
+}
+
+// checkPipelines constructs the pipelines of the capture processes
+func (c *ConfigurationCheck) checkPipelines(devices bool) {
+	// The pipelines are checked with the configured settings
+	settings := config.NewSettings(c.config)
+	config := c.config
+	gst.Init(nil)
+
+	source := media.NewSource(config, settings)
+	videoSrc, err := source.VideoPipeline()
+	if err != nil {
+		c.problem("Invalid video source: %v", err)
+		return
+	}
+	audioSrc, err := source.AudioPipeline()
+	if err != nil {
+		c.problem("Invalid audio source: %v", err)
+		return
+	}
+	text := settings.OverlayText()
+
+	video, motion := media.VideoCapture(config, videoSrc, text)
+	streamingVideo, _ := media.CodecPipeline("vp8", video)
+	audio, sound := media.WithSoundDetection(config, audioSrc)
+	streamingAudio, _ := media.CodecPipeline("opus", audio)
+
+	pipelines := []struct {
+		name        string
+		description string
+	}{
+		{"streaming video", streamingVideo + motion},
+		{"streaming audio", streamingAudio + sound},
+		{"recording", media.RecordingPipeline(config, videoSrc, audioSrc, os.DevNull, text)},
+		{"snapshot", media.SnapshotPipeline(config, videoSrc, os.DevNull, text)},
+	}
+
+	for _, pipeline := range pipelines {
+		if err := checkPipeline(pipeline.description, devices); err != nil {
+			c.problem("Invalid %s pipeline: %v\n  %s", pipeline.name, err, pipeline.description)
+		}
+	}
+}
+
+// CheckConfiguration checks the configuration file and the pipelines built
+// from it. The devices are only opened if devices is set, as they cannot be
+// opened while the server uses them.
+func CheckConfiguration(configFile string, devices bool) *ConfigurationCheck {
+	check := &ConfigurationCheck{}
+	if !check.load(configFile) {
+		return check
+	}
+
+	check.checkTls()
+	check.checkTurn()
+	check.checkFiles()
+	if err := media.CheckSources(check.config); err != nil {
+		check.problem("%v", err)
+	}
+
+	// Pipelines of an invalid configuration would only fail again
+	if len(check.Problems) == 0 {
+		check.checkPipelines(devices)
+	}
+
+	return check
+}
+
+// RunCheck prints the result of checking the configuration and exits with a
+// non-zero code if there are problems
+func RunCheck(configFile string, devices bool) {
+	check := CheckConfiguration(configFile, devices)
+
+	for _, warning := range check.Warnings {
+		fmt.Printf("Warning: %s\n", warning)
+	}
+	for _, problem := range check.Problems {
+		fmt.Printf("Problem: %s\n", problem)
+	}
+
+	if len(check.Problems) > 0 {
+		fmt.Printf("Configuration %s is not valid\n", configFile)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Configuration %s is valid\n", configFile)
+}
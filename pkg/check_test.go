@@ -0,0 +1,51 @@
+/*
+ Copyright (c) 2024 Neeraj Jakhar
+
+ This program is free software: you can redistribute it and/or modify
+ it under the terms of the GNU General Public License as published by
+ the Free Software Foundation, either version 3 of the License, or
+ (at your option) any later version.
+
+ This program is distributed in the hope that it will be useful,
+ but WITHOUT ANY WARRANTY; without even the implied warranty of
+ MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License
+ along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckUnknownFields(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	data := "port: 8080\nobsolete_option: true\nframerate: fast\n"
+	if err := os.WriteFile(file, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var check ConfigurationCheck
+	if !check.load(file) {
+		t.Fatalf("Configuration not loaded: %v", check.Problems)
+	}
+
+	// The server ignores unknown fields, but not values of the wrong type
+	if len(check.Warnings) != 1 || !strings.Contains(check.Warnings[0], "obsolete_option") {
+		t.Errorf("Unexpected warnings: %v", check.Warnings)
+	}
+	for _, problem := range check.Problems {
+		if strings.Contains(problem, "obsolete_option") {
+			t.Errorf("Unknown field reported as a problem: %s", problem)
+		}
+	}
+	if len(check.Problems) == 0 || !strings.Contains(check.Problems[0], "fast") {
+		t.Errorf("Type error not reported as a problem: %v", check.Problems)
+	}
+}
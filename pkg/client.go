@@ -18,44 +18,103 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"log"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/homebackend/go-webrtc/pkg/config"
+	"github.com/homebackend/go-webrtc/pkg/signalling"
 )
 
 const (
 	clientReadLimit = 512
 	pongWait        = 10 * time.Second
 	pingInterval    = (pongWait * 9) / 10
+	// Candidates received before the streaming process is ready to take them
+	pendingCandidates = 16
 )
 
 type ClientList map[*Client]bool
 
 type Client struct {
-	authorized   bool
+	id         string
+	user       string
+	account    *config.SignallingUser
+	remoteAddr string
+	authorized bool
+	// Whether the session of the client reached the connected state
+	streamed     atomic.Bool
+	version      int
+	connectId    string
+	connected    time.Time
 	authDeadline time.Time
 	sdp          string
+	candidates   chan string
 	connection   *websocket.Conn
 	manager      *Manager
-	egress       chan Event
+	egress       chan signalling.Event
+	done         chan struct{}
 }
 
-func NewClient(conn *websocket.Conn, manager *Manager) *Client {
+// NewClient creates a client for the given websocket connection. Clients
+// which do not use websockets are created with a nil connection.
+func NewClient(conn *websocket.Conn, remoteAddr string, manager *Manager) *Client {
 	return &Client{
+		id:           newClientId(),
+		remoteAddr:   remoteAddr,
 		authorized:   false,
+		candidates:   make(chan string, pendingCandidates),
 		connection:   conn,
 		manager:      manager,
-		egress:       make(chan Event),
+		egress:       make(chan signalling.Event),
+		done:         make(chan struct{}),
+		connected:    time.Now(),
 		authDeadline: time.Now().Add(time.Second * time.Duration(60)),
 	}
 }
 
+func newClientId() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		log.Fatalln(err)
+	}
+
+	return hex.EncodeToString(b)
+}
+
+func (c *Client) notificationData() map[string]interface{} {
+	return map[string]interface{}{
+		"client":         c.id,
+		"user":           c.user,
+		"remote_address": c.remoteAddr,
+	}
+}
+
 func (c *Client) hasAuthTimedOut() bool {
 	return time.Now().After(c.authDeadline)
 }
 
+// sendEvent queues the event for the write loop. Events sent after the
+// client has been removed are dropped instead of blocking the caller.
+func (c *Client) sendEvent(event signalling.Event) {
+	select {
+	case c.egress <- event:
+	case <-c.done:
+		log.Printf("Dropping %s event for removed client\n", event.Type)
+	}
+}
+
+// sendReply sends an event which results from the client's connect request,
+// such as the answer and candidates, tagged with the id of that request.
+func (c *Client) sendReply(event signalling.Event) {
+	event.Id = c.connectId
+	c.sendEvent(event)
+}
+
 func (c *Client) readMessages() {
 	defer func() {
 		log.Println("Exiting read message")
@@ -86,15 +145,14 @@ func (c *Client) readMessages() {
 
 		log.Println("Payload received")
 
-		var request Event
+		var request signalling.Event
 		if err := json.Unmarshal(payload, &request); err != nil {
 			log.Printf("Error processing event: %v", err)
-			break
+			c.sendEvent(GetErrorEvent(&signalling.ProtocolError{Code: signalling.ErrorCodeMalformedMessage, Message: err.Error()}))
+			continue
 		}
 
-		if err := c.manager.routeEvent(request, c); err != nil {
-			log.Println("Error processing event payload: ", err)
-		}
+		c.manager.handleRequest(request, c)
 	}
 }
 
@@ -110,6 +168,8 @@ func (c *Client) writeMessages() {
 
 	for {
 		select {
+		case <-c.done:
+			return
 		case message, ok := <-c.egress:
 			if !ok {
 				log.Println("connection closed")
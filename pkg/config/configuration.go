@@ -0,0 +1,336 @@
+/*
+ Copyright (c) 2024 Neeraj Jakhar
+
+ This program is free software: you can redistribute it and/or modify
+ it under the terms of the GNU General Public License as published by
+ the Free Software Foundation, either version 3 of the License, or
+ (at your option) any later version.
+
+ This program is distributed in the hope that it will be useful,
+ but WITHOUT ANY WARRANTY; without even the implied warranty of
+ MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License
+ along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+// Package config contains the configuration of gowebrtc as read from the
+// YAML configuration file, along with the capture settings which can be
+// changed at runtime.
+package config
+
+import (
+	"slices"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+const (
+	TurnInternal = "internal"
+	TurnPublicIp = "ip"
+)
+
+// Permissions which can be granted to signalling users
+const (
+	// Change the capture settings, such as the overlay text and privacy masks
+	PermissionSettings = "settings"
+	// List the sessions of all viewers and end them
+	PermissionKick = "kick"
+	// End the running session, if reconnecting disconnects it
+	PermissionPreempt = "preempt"
+)
+
+type OpenRelay struct {
+	AppName string `yaml:"app_name"`
+	ApiKey  string `yaml:"api_key"`
+}
+
+type ICEServer struct {
+	URLs           string                   `json:"urls"`
+	Username       string                   `json:"username,omitempty"`
+	Credential     interface{}              `json:"credential,omitempty"`
+	CredentialType webrtc.ICECredentialType `json:"credentialType,omitempty"`
+}
+
+type UserCredentials struct {
+	User     string `yaml:"user" validate:"required"`
+	Password string `yaml:"password" validate:"required"`
+}
+
+// Preemption policies deciding whether new viewers end running sessions
+// when the stream is full
+const (
+	PreemptionNever    = "never"
+	PreemptionAlways   = "always"
+	PreemptionPriority = "priority"
+)
+
+const (
+	DefaultMaxQueued = 10
+	// Seconds before a session is ended in which its viewer is warned
+	DefaultSessionWarning = 30
+)
+
+// SignallingUser is a user of the signalling server and the admin api
+type SignallingUser struct {
+	UserCredentials `yaml:",inline"`
+	// Url paths of the streams the user may view, all of them if not given
+	Streams []string `yaml:"streams,omitempty"`
+	// Permissions of the user, all of them if not given
+	Permissions []string `yaml:"permissions,omitempty" validate:"dive,oneof=settings kick preempt"`
+	// Users with a higher priority preempt the sessions of users with a
+	// lower one, if preemption is by priority
+	Priority int `yaml:"priority"`
+	// Seconds the sessions of the user may last, overriding the maximum
+	// duration of the viewers configuration if set
+	MaxDuration int `yaml:"max_duration" validate:"gte=0"`
+}
+
+// CanView returns whether the user may view the stream. A nil user, which
+// is used if no credentials are configured, may do everything.
+func (u *SignallingUser) CanView(stream string) bool {
+	if u == nil || u.Streams == nil {
+		return true
+	}
+
+	return slices.Contains(u.Streams, stream)
+}
+
+// Can returns whether the user has been granted the permission
+func (u *SignallingUser) Can(permission string) bool {
+	if u == nil || u.Permissions == nil {
+		return true
+	}
+
+	return slices.Contains(u.Permissions, permission)
+}
+
+type TurnConfiguration struct {
+	TurnType string            `yaml:"type" validate:"oneof=ip internal" default:"ip"`
+	PublicIp string            `yaml:"public_ip" validate:"required"`
+	UdpPort  int               `yaml:"port" validate:"required,number,gte=1,lte=65535" default:"8080"`
+	Users    []UserCredentials `yaml:"users" validate:"required"`
+	Realm    string            `yaml:"realm" default:"default"`
+	Threads  int               `yaml:"threads" validate:"required,gte=1,lte=20"`
+}
+
+type MqttConfiguration struct {
+	Broker      string `yaml:"broker" validate:"required"`
+	ClientId    string `yaml:"client_id" default:"gowebrtc"`
+	User        string `yaml:"user"`
+	Password    string `yaml:"password"`
+	TopicPrefix string `yaml:"topic_prefix" default:"gowebrtc"`
+	Signalling  bool   `yaml:"signalling" default:"false"`
+	// Home Assistant MQTT discovery
+	HomeAssistant    bool   `yaml:"home_assistant" default:"false"`
+	DiscoveryPrefix  string `yaml:"discovery_prefix" default:"homeassistant"`
+	SnapshotInterval uint   `yaml:"snapshot_interval" default:"0"`
+}
+
+// MotionRegion is a rectangle relative to the image size, e.g. a width of
+// 0.5 is half of the image width
+type MotionRegion struct {
+	X      float64 `yaml:"x" validate:"gte=0,lte=1"`
+	Y      float64 `yaml:"y" validate:"gte=0,lte=1"`
+	Width  float64 `yaml:"width" validate:"gt=0,lte=1"`
+	Height float64 `yaml:"height" validate:"gt=0,lte=1"`
+}
+
+// MotionConfiguration sensitivity is a pointer as 0 is a valid sensitivity
+type MotionConfiguration struct {
+	Sensitivity *uint          `yaml:"sensitivity" validate:"omitempty,lte=100" default:"50"`
+	Cooldown    uint           `yaml:"cooldown" default:"10"`
+	Regions     []MotionRegion `yaml:"regions" validate:"dive"`
+	Masks       []MotionRegion `yaml:"masks" validate:"dive"`
+	Record      bool           `yaml:"record" default:"false"`
+}
+
+// SoundConfiguration durations are in seconds. The threshold is a pointer
+// as 0 dBFS is a valid threshold.
+type SoundConfiguration struct {
+	Threshold *float64 `yaml:"threshold" validate:"omitempty,lte=0" default:"-30"`
+	Duration  float64  `yaml:"duration" validate:"gte=0" default:"0"`
+	Hold      uint     `yaml:"hold" default:"10"`
+}
+
+type OverlayConfiguration struct {
+	Timestamp         bool   `yaml:"timestamp" default:"false"`
+	TimestampFormat   string `yaml:"timestamp_format" default:"%Y-%m-%d %H:%M:%S"`
+	TimestampPosition string `yaml:"timestamp_position" validate:"omitempty,oneof=top-left top-right bottom-left bottom-right" default:"top-left"`
+	Name              bool   `yaml:"name" default:"false"`
+	NamePosition      string `yaml:"name_position" validate:"omitempty,oneof=top-left top-right bottom-left bottom-right" default:"bottom-left"`
+	Text              string `yaml:"text" validate:"max=256"`
+	TextPosition      string `yaml:"text_position" validate:"omitempty,oneof=top-left top-right bottom-left bottom-right" default:"bottom-right"`
+	Font              string `yaml:"font" default:"Sans, 12"`
+}
+
+type PrivacyPoint struct {
+	X float64 `yaml:"x" json:"x" validate:"gte=0,lte=1"`
+	Y float64 `yaml:"y" json:"y" validate:"gte=0,lte=1"`
+}
+
+// PrivacyMask is a rectangle or, if points are given, a polygon. All
+// coordinates are relative to the image size.
+type PrivacyMask struct {
+	Name   string         `yaml:"name" json:"name,omitempty"`
+	X      float64        `yaml:"x" json:"x,omitempty" validate:"gte=0,lte=1"`
+	Y      float64        `yaml:"y" json:"y,omitempty" validate:"gte=0,lte=1"`
+	Width  float64        `yaml:"width" json:"width,omitempty" validate:"required_without=Points,gte=0,lte=1"`
+	Height float64        `yaml:"height" json:"height,omitempty" validate:"required_without=Points,gte=0,lte=1"`
+	Points []PrivacyPoint `yaml:"points" json:"points,omitempty" validate:"omitempty,min=3,dive"`
+}
+
+type PrivacyConfiguration struct {
+	Masks []PrivacyMask `yaml:"masks" validate:"dive"`
+	File  string        `yaml:"file"`
+}
+
+// SourceConfiguration describes a capture source, which is turned into a
+// pipeline instead of a raw pipeline given in video_device or audio_device
+type SourceConfiguration struct {
+	Type     string `yaml:"type" validate:"required,oneof=v4l2 libcamera alsa pulse test file rtsp"`
+	Device   string `yaml:"device"`
+	Location string `yaml:"location"`
+	Format   string `yaml:"format" validate:"omitempty,oneof=raw mjpeg h264"`
+	Decoder  string `yaml:"decoder"`
+	Pattern  string `yaml:"pattern"`
+	Latency  uint   `yaml:"latency" default:"200"`
+}
+
+// ViewerConfiguration decides who may view the stream while it is busy
+type ViewerConfiguration struct {
+	// Maximum number of concurrent viewers. Sources which cannot be opened
+	// more than once, such as most cameras, only support a single viewer.
+	MaxViewers int `yaml:"max_viewers" validate:"gte=0"`
+	// Viewers wait for a free slot instead of being refused
+	Queue     bool `yaml:"queue"`
+	MaxQueued int  `yaml:"max_queued" validate:"gte=0"`
+	// Defaults to always if disconnect_on_reconnect is set, never otherwise
+	Preemption string `yaml:"preemption" validate:"omitempty,oneof=never always priority"`
+	// Seconds a session may last, unlimited if 0
+	MaxDuration int `yaml:"max_duration" validate:"gte=0"`
+	// Seconds after which a session is ended while its viewer does not
+	// show the stream, e.g. in a hidden browser tab. Never if 0.
+	IdleTimeout int `yaml:"idle_timeout" validate:"gte=0"`
+	// Seconds before a session is ended in which its viewer is warned
+	Warning int `yaml:"warning" validate:"gte=0" default:"30"`
+}
+
+type WebhookConfiguration struct {
+	Url    string   `yaml:"url" validate:"required,url"`
+	Secret string   `yaml:"secret"`
+	Events []string `yaml:"events" validate:"dive,oneof=viewer_connected viewer_disconnected auth_failure pipeline_failure device_lost device_recovered streaming_started streaming_stopped recording_started recording_finished snapshot_taken motion_started motion_stopped sound_started sound_stopped"`
+}
+
+type Configuration struct {
+	Name                  string                 `yaml:"name" default:"gowebrtc"`
+	Port                  int                    `yaml:"port" validate:"number,gte=1,lte=65535" default:"8080"`
+	Url                   string                 `yaml:"url" default:"/stream"`
+	ImageWidth            uint                   `yaml:"image_width" default:"640"`
+	ImageHeight           uint                   `yaml:"image_height" default:"480"`
+	FrameRate             uint                   `yaml:"framerate" default:"30"`
+	LogFile               string                 `yaml:"log_file" default:"none"`
+	AudioDevice           string                 `yaml:"audio_device" validate:"required_without=AudioSource,excluded_with=AudioSource"`
+	VideoDevice           string                 `yaml:"video_device" validate:"required_without=VideoSource,excluded_with=VideoSource"`
+	AudioSource           *SourceConfiguration   `yaml:"audio_source,omitempty"`
+	VideoSource           *SourceConfiguration   `yaml:"video_source,omitempty"`
+	Signalling            string                 `yaml:"signalling" validate:"oneof=http websocket sse" default:"websocket"`
+	SignallingUsesTls     bool                   `yaml:"signalling_uses_tls" default:"false"`
+	SignallingTlsCert     string                 `yaml:"signalling_tls_cert"`
+	SignallingTlsKey      string                 `yaml:"signalling_tls_key"`
+	SignallingCredentials []SignallingUser       `yaml:"signalling_credentials" validate:"dive"`
+	SignallingOrigin      string                 `yaml:"signalling_origin" default:""`
+	IceTrickling          bool                   `yaml:"ice_trickling" default:"false"`
+	DisconnectOnReconnect bool                   `yaml:"disconnect_on_reconnect" default:"false"`
+	Viewers               *ViewerConfiguration   `yaml:"viewers,omitempty"`
+	IceServers            []webrtc.ICEServer     `yaml:"ice_servers,omitempty"`
+	OpenRelayConfig       *OpenRelay             `yaml:"open_relay_config,omitempty"`
+	UseInternalTurn       bool                   `yaml:"use_internal_turn" default:"false"`
+	TurnConfiguration     *TurnConfiguration     `yaml:"turn_configuration"`
+	RecordingDirectory    string                 `yaml:"recording_directory" default:"/var/lib/gowebrtc"`
+	Mqtt                  *MqttConfiguration     `yaml:"mqtt,omitempty"`
+	Webhooks              []WebhookConfiguration `yaml:"webhooks,omitempty" validate:"dive"`
+	Motion                *MotionConfiguration   `yaml:"motion,omitempty"`
+	Sound                 *SoundConfiguration    `yaml:"sound,omitempty"`
+	Overlay               *OverlayConfiguration  `yaml:"overlay,omitempty"`
+	Privacy               *PrivacyConfiguration  `yaml:"privacy,omitempty"`
+}
+
+// Authenticate returns the signalling user with the credentials. Everybody
+// is authenticated as a nil user if no credentials are configured.
+func (c *Configuration) Authenticate(user, password string) (*SignallingUser, bool) {
+	if len(c.SignallingCredentials) == 0 {
+		return nil, true
+	}
+
+	for i := range c.SignallingCredentials {
+		credential := &c.SignallingCredentials[i]
+		if credential.User == user && credential.Password == password {
+			return credential, true
+		}
+	}
+
+	return nil, false
+}
+
+// MaxViewers returns how many viewers may stream at the same time
+func (c *Configuration) MaxViewers() int {
+	if c.Viewers == nil || c.Viewers.MaxViewers == 0 {
+		return 1
+	}
+
+	return c.Viewers.MaxViewers
+}
+
+// MaxQueued returns how many viewers may wait for a free slot
+func (c *Configuration) MaxQueued() int {
+	if c.Viewers == nil || !c.Viewers.Queue {
+		return 0
+	} else if c.Viewers.MaxQueued == 0 {
+		return DefaultMaxQueued
+	}
+
+	return c.Viewers.MaxQueued
+}
+
+// Preemption returns the preemption policy
+func (c *Configuration) Preemption() string {
+	if c.Viewers != nil && c.Viewers.Preemption != "" {
+		return c.Viewers.Preemption
+	} else if c.DisconnectOnReconnect {
+		return PreemptionAlways
+	}
+
+	return PreemptionNever
+}
+
+// MaxDuration returns how long sessions may last, 0 if there is no limit
+func (c *Configuration) MaxDuration() time.Duration {
+	if c.Viewers == nil {
+		return 0
+	}
+
+	return time.Duration(c.Viewers.MaxDuration) * time.Second
+}
+
+// IdleTimeout returns how long a session may last while its viewer does
+// not show the stream, 0 if there is no limit
+func (c *Configuration) IdleTimeout() time.Duration {
+	if c.Viewers == nil {
+		return 0
+	}
+
+	return time.Duration(c.Viewers.IdleTimeout) * time.Second
+}
+
+// SessionWarning returns how long before a session is ended its viewer is
+// warned
+func (c *Configuration) SessionWarning() time.Duration {
+	if c.Viewers == nil || c.Viewers.Warning == 0 {
+		return DefaultSessionWarning * time.Second
+	}
+
+	return time.Duration(c.Viewers.Warning) * time.Second
+}
@@ -0,0 +1,106 @@
+/*
+ Copyright (c) 2024 Neeraj Jakhar
+
+ This program is free software: you can redistribute it and/or modify
+ it under the terms of the GNU General Public License as published by
+ the Free Software Foundation, either version 3 of the License, or
+ (at your option) any later version.
+
+ This program is distributed in the hope that it will be useful,
+ but WITHOUT ANY WARRANTY; without even the implied warranty of
+ MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License
+ along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package config
+
+import (
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestAuthenticate(t *testing.T) {
+	c := &Configuration{}
+	if user, ok := c.Authenticate("anybody", ""); !ok || user != nil {
+		t.Error("not authenticated without configured credentials")
+	}
+
+	c.SignallingCredentials = []SignallingUser{{UserCredentials: UserCredentials{User: "user", Password: "secret"}}}
+	if _, ok := c.Authenticate("user", "wrong"); ok {
+		t.Error("authenticated with a wrong password")
+	}
+	if user, ok := c.Authenticate("user", "secret"); !ok || user.User != "user" {
+		t.Errorf("not authenticated as the user: %v", user)
+	}
+}
+
+func TestSignallingUserPermissions(t *testing.T) {
+	var users []SignallingUser
+	err := yaml.Unmarshal([]byte(`
+- user: admin
+  password: secret
+- user: viewer
+  password: secret
+  streams: [/stream]
+  permissions: []
+- user: operator
+  password: secret
+  permissions: [settings]
+`), &users)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	admin, viewer, operator := &users[0], &users[1], &users[2]
+	if !admin.CanView("/other") || !admin.Can(PermissionKick) {
+		t.Error("user without restrictions is restricted")
+	}
+	if !viewer.CanView("/stream") || viewer.CanView("/other") {
+		t.Error("streams of the viewer not restricted")
+	}
+	if viewer.Can(PermissionPreempt) {
+		t.Error("viewer without permissions has one")
+	}
+	if !operator.Can(PermissionSettings) || operator.Can(PermissionKick) {
+		t.Error("permissions of the operator not restricted")
+	}
+
+	var nobody *SignallingUser
+	if !nobody.CanView("/stream") || !nobody.Can(PermissionKick) {
+		t.Error("everything is not allowed without credentials")
+	}
+}
+
+func TestViewerPolicy(t *testing.T) {
+	c := &Configuration{}
+	if c.MaxViewers() != 1 || c.MaxQueued() != 0 || c.Preemption() != PreemptionNever {
+		t.Errorf("unexpected default policy: %d %d %s", c.MaxViewers(), c.MaxQueued(), c.Preemption())
+	}
+
+	c.DisconnectOnReconnect = true
+	if c.Preemption() != PreemptionAlways {
+		t.Errorf("reconnecting does not preempt: %s", c.Preemption())
+	}
+
+	c.Viewers = &ViewerConfiguration{MaxViewers: 2, Queue: true, Preemption: PreemptionPriority}
+	if c.MaxViewers() != 2 || c.MaxQueued() != DefaultMaxQueued || c.Preemption() != PreemptionPriority {
+		t.Errorf("configured policy not used: %d %d %s", c.MaxViewers(), c.MaxQueued(), c.Preemption())
+	}
+}
+
+func TestSessionLimits(t *testing.T) {
+	c := &Configuration{}
+	if c.MaxDuration() != 0 || c.IdleTimeout() != 0 || c.SessionWarning() != DefaultSessionWarning*time.Second {
+		t.Errorf("unexpected default limits: %v %v %v", c.MaxDuration(), c.IdleTimeout(), c.SessionWarning())
+	}
+
+	c.Viewers = &ViewerConfiguration{MaxDuration: 3600, IdleTimeout: 300, Warning: 60}
+	if c.MaxDuration() != time.Hour || c.IdleTimeout() != 5*time.Minute || c.SessionWarning() != time.Minute {
+		t.Errorf("configured limits not used: %v %v %v", c.MaxDuration(), c.IdleTimeout(), c.SessionWarning())
+	}
+}
@@ -0,0 +1,110 @@
+/*
+ Copyright (c) 2024 Neeraj Jakhar
+
+ This program is free software: you can redistribute it and/or modify
+ it under the terms of the GNU General Public License as published by
+ the Free Software Foundation, either version 3 of the License, or
+ (at your option) any later version.
+
+ This program is distributed in the hope that it will be useful,
+ but WITHOUT ANY WARRANTY; without even the implied warranty of
+ MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License
+ along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package config
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Settings are the capture settings which can be changed at runtime through
+// MQTT and the admin api. Until changed, the configured values are used.
+type Settings struct {
+	config         *Configuration
+	audioDisabled  atomic.Bool
+	lock           sync.Mutex
+	overlayText    *string
+	privacyMasks   *[]PrivacyMask
+	captureChanged chan struct{}
+}
+
+func NewSettings(config *Configuration) *Settings {
+	return &Settings{config: config}
+}
+
+func (s *Settings) AudioEnabled() bool {
+	return !s.audioDisabled.Load()
+}
+
+func (s *Settings) SetAudioEnabled(enabled bool) {
+	s.audioDisabled.Store(!enabled)
+}
+
+// OverlayText returns the current overlay text
+func (s *Settings) OverlayText() string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.overlayText != nil {
+		return *s.overlayText
+	} else if s.config.Overlay != nil {
+		return s.config.Overlay.Text
+	}
+
+	return ""
+}
+
+func (s *Settings) SetOverlayText(text string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.overlayText = &text
+	s.captureSettingsChanged()
+}
+
+// PrivacyMasks returns the current privacy masks
+func (s *Settings) PrivacyMasks() []PrivacyMask {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.privacyMasks != nil {
+		return *s.privacyMasks
+	} else if s.config.Privacy != nil {
+		return s.config.Privacy.Masks
+	}
+
+	return nil
+}
+
+func (s *Settings) SetPrivacyMasks(masks []PrivacyMask) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.privacyMasks = &masks
+	s.captureSettingsChanged()
+}
+
+// CaptureSettingsChanged returns a channel which is closed when the overlay
+// text or the privacy masks change
+func (s *Settings) CaptureSettingsChanged() <-chan struct{} {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.captureChanged == nil {
+		s.captureChanged = make(chan struct{})
+	}
+
+	return s.captureChanged
+}
+
+func (s *Settings) captureSettingsChanged() {
+	if s.captureChanged != nil {
+		close(s.captureChanged)
+	}
+	s.captureChanged = make(chan struct{})
+}
@@ -0,0 +1,82 @@
+/*
+ Copyright (c) 2024 Neeraj Jakhar
+
+ This program is free software: you can redistribute it and/or modify
+ it under the terms of the GNU General Public License as published by
+ the Free Software Foundation, either version 3 of the License, or
+ (at your option) any later version.
+
+ This program is distributed in the hope that it will be useful,
+ but WITHOUT ANY WARRANTY; without even the implied warranty of
+ MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License
+ along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package config
+
+import "testing"
+
+func changed(ch <-chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestOverlayText(t *testing.T) {
+	conf := &Configuration{}
+	c := NewSettings(conf)
+	if text := c.OverlayText(); text != "" {
+		t.Errorf("unexpected text without overlay: %s", text)
+	}
+
+	conf.Overlay = &OverlayConfiguration{Text: "configured"}
+	if text := c.OverlayText(); text != "configured" {
+		t.Errorf("configured text not used: %s", text)
+	}
+
+	settingsChanged := c.CaptureSettingsChanged()
+	c.SetOverlayText("")
+	if text := c.OverlayText(); text != "" {
+		t.Errorf("text set at runtime not used: %s", text)
+	}
+	if !changed(settingsChanged) {
+		t.Error("setting the text is not reported as a change")
+	}
+}
+
+func TestPrivacyMasks(t *testing.T) {
+	c := NewSettings(&Configuration{Privacy: &PrivacyConfiguration{Masks: []PrivacyMask{{Width: 1, Height: 1}}}})
+	if masks := c.PrivacyMasks(); len(masks) != 1 {
+		t.Errorf("configured masks not used: %v", masks)
+	}
+
+	settingsChanged := c.CaptureSettingsChanged()
+	c.SetPrivacyMasks(nil)
+	if masks := c.PrivacyMasks(); len(masks) != 0 {
+		t.Errorf("masks set at runtime not used: %v", masks)
+	}
+	if !changed(settingsChanged) {
+		t.Error("setting the masks is not reported as a change")
+	}
+	if changed(c.CaptureSettingsChanged()) {
+		t.Error("new change channel is already closed")
+	}
+}
+
+func TestAudioEnabled(t *testing.T) {
+	c := NewSettings(&Configuration{})
+	if !c.AudioEnabled() {
+		t.Error("audio disabled by default")
+	}
+
+	c.SetAudioEnabled(false)
+	if c.AudioEnabled() {
+		t.Error("audio not disabled")
+	}
+}
@@ -0,0 +1,334 @@
+/*
+ Copyright (c) 2024 Neeraj Jakhar
+
+ This program is free software: you can redistribute it and/or modify
+ it under the terms of the GNU General Public License as published by
+ the Free Software Foundation, either version 3 of the License, or
+ (at your option) any later version.
+
+ This program is distributed in the hope that it will be useful,
+ but WITHOUT ANY WARRANTY; without even the implied warranty of
+ MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License
+ along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-gst/go-gst/gst"
+	"github.com/homebackend/go-webrtc/pkg/media"
+)
+
+const (
+	DeviceClassVideo = "Video/Source"
+	DeviceClassAudio = "Audio/Source"
+)
+
+// Source types of the elements which can be described by a source
+// configuration
+var deviceSourceTypes = map[string]string{
+	"v4l2src":      media.SourceV4l2,
+	"libcamerasrc": media.SourceLibcamera,
+	"alsasrc":      media.SourceAlsa,
+	"pulsesrc":     media.SourcePulse,
+}
+
+// Properties which select the device of a source element
+var deviceProperties = []string{"device", "camera-name", "target-object", "path"}
+
+// Source formats of the caps a camera can produce
+var capsFormats = map[string]string{
+	"video/x-raw":  media.FormatRaw,
+	"image/jpeg":   media.FormatMjpeg,
+	"video/x-h264": media.FormatH264,
+	"audio/x-raw":  media.FormatRaw,
+}
+
+// DeviceMode is one set of caps supported by a capture device. Values which
+// are ranges are only available in the caps.
+type DeviceMode struct {
+	Format      string   `json:"format"`
+	PixelFormat string   `json:"pixel_format,omitempty"`
+	Width       int      `json:"width,omitempty"`
+	Height      int      `json:"height,omitempty"`
+	Framerates  []string `json:"framerates,omitempty"`
+	Caps        string   `json:"caps"`
+}
+
+type CaptureDevice struct {
+	Name     string       `json:"name"`
+	Class    string       `json:"class"`
+	Element  string       `json:"element"`
+	Property string       `json:"property,omitempty"`
+	Device   string       `json:"device,omitempty"`
+	Modes    []DeviceMode `json:"modes"`
+	Config   string       `json:"config"`
+}
+
+// capsString returns a field of a caps structure if it is a string
+func capsString(structure *gst.Structure, name string) string {
+	value, _ := structure.GetValue(name)
+	s, _ := value.(string)
+	return s
+}
+
+// capsInt returns a field of a caps structure if it is a single integer,
+// ranges are not resolved
+func capsInt(structure *gst.Structure, name string) int {
+	value, _ := structure.GetValue(name)
+	i, _ := value.(int)
+	return i
+}
+
+// capsFractions returns the values of a field which is a fraction or a list
+// of fractions, such as the framerate
+func capsFractions(structure *gst.Structure, name string) []string {
+	value, _ := structure.GetValue(name)
+	switch v := value.(type) {
+	case *gst.FractionValue:
+		return []string{fraction(v)}
+	case *gst.ValueListValue:
+		var fractions []string
+		for i := uint(0); i < v.Size(); i++ {
+			if f, ok := v.ValueAt(i).(*gst.FractionValue); ok {
+				fractions = append(fractions, fraction(f))
+			}
+		}
+		return fractions
+	}
+
+	return nil
+}
+
+func fraction(f *gst.FractionValue) string {
+	return fmt.Sprintf("%d/%d", f.Num(), f.Denom())
+}
+
+func deviceMode(structure *gst.Structure) DeviceMode {
+	name := structure.Name()
+	mode := DeviceMode{Format: capsFormats[name], Caps: structure.String()}
+	if mode.Format == "" {
+		mode.Format = name
+	}
+
+	mode.PixelFormat = capsString(structure, "format")
+	mode.Width = capsInt(structure, "width")
+	mode.Height = capsInt(structure, "height")
+	mode.Framerates = capsFractions(structure, "framerate")
+
+	return mode
+}
+
+// maxFramerate returns the highest whole framerate of a mode
+func maxFramerate(mode DeviceMode) int {
+	best := 0
+	for _, framerate := range mode.Framerates {
+		num, denom, _ := strings.Cut(framerate, "/")
+		n, err := strconv.Atoi(num)
+		d, err2 := strconv.Atoi(denom)
+		if err != nil || err2 != nil || d == 0 {
+			continue
+		}
+
+		if n/d > best {
+			best = n / d
+		}
+	}
+
+	return best
+}
+
+// betterMode returns whether a mode has a higher resolution or framerate
+// than another, preferring raw video if both are the same
+func betterMode(mode, other *DeviceMode) bool {
+	if mode.Width*mode.Height != other.Width*other.Height {
+		return mode.Width*mode.Height > other.Width*other.Height
+	}
+	if maxFramerate(*mode) != maxFramerate(*other) {
+		return maxFramerate(*mode) > maxFramerate(*other)
+	}
+
+	return mode.Format == media.FormatRaw && other.Format != media.FormatRaw
+}
+
+// bestMode returns the best mode which a source configuration supports
+func bestMode(modes []DeviceMode) *DeviceMode {
+	var best *DeviceMode
+	for i := range modes {
+		mode := &modes[i]
+		if _, ok := media.FormatCaps[mode.Format]; !ok || mode.Width == 0 || maxFramerate(*mode) == 0 {
+			continue
+		}
+
+		if best == nil || betterMode(mode, best) {
+			best = mode
+		}
+	}
+
+	return best
+}
+
+// deviceConfig returns a configuration snippet for the device, using a
+// source configuration if the element is supported and a raw pipeline
+// otherwise
+func deviceConfig(device CaptureDevice) string {
+	video := device.Class == DeviceClassVideo
+	sourceType, ok := deviceSourceTypes[device.Element]
+	if !ok {
+		pipeline := device.Element
+		if device.Device != "" {
+			pipeline += " " + device.Property + "=" + media.Quote(device.Device)
+		}
+
+		if video {
+			return fmt.Sprintf("video_device: '%s'\n", pipeline)
+		}
+		return fmt.Sprintf("audio_device: '%s'\n", pipeline)
+	}
+
+	var config strings.Builder
+	if video {
+		config.WriteString("video_source:\n")
+	} else {
+		config.WriteString("audio_source:\n")
+	}
+	fmt.Fprintf(&config, "  type: %s\n", sourceType)
+	if device.Device != "" {
+		fmt.Fprintf(&config, "  device: %s\n", strconv.Quote(device.Device))
+	}
+
+	if video {
+		if mode := bestMode(device.Modes); mode != nil {
+			if mode.Format != media.FormatRaw {
+				fmt.Fprintf(&config, "  format: %s\n", mode.Format)
+			}
+			fmt.Fprintf(&config, "image_width: %d\nimage_height: %d\nframerate: %d\n", mode.Width, mode.Height, maxFramerate(*mode))
+		}
+	}
+
+	return config.String()
+}
+
+// deviceProperty returns the property and value which select the device of
+// a source element created for a device
+func deviceProperty(element *gst.Element) (string, string) {
+	for _, property := range deviceProperties {
+		value, err := element.GetProperty(property)
+		if err != nil {
+			continue
+		}
+
+		if s, ok := value.(string); ok && s != "" {
+			return property, s
+		}
+	}
+
+	return "", ""
+}
+
+// ListDevices returns the video and audio sources found by the GStreamer
+// device monitor
+func ListDevices() []CaptureDevice {
+	monitor := gst.NewDeviceMonitor()
+	if monitor == nil {
+		log.Fatalln("Unable to create device monitor")
+	}
+
+	monitor.AddFilter(DeviceClassVideo, gst.NewAnyCaps())
+	monitor.AddFilter(DeviceClassAudio, gst.NewAnyCaps())
+	if !monitor.Start() {
+		log.Fatalln("Unable to start device monitor")
+	}
+	defer monitor.Stop()
+
+	devices := []CaptureDevice{}
+	for _, d := range monitor.GetDevices() {
+		device := CaptureDevice{Name: d.GetDisplayName(), Modes: []DeviceMode{}}
+		if d.HasClasses([]string{"Video", "Source"}) {
+			device.Class = DeviceClassVideo
+		} else if d.HasClasses([]string{"Audio", "Source"}) {
+			device.Class = DeviceClassAudio
+		} else {
+			continue
+		}
+
+		if element := d.CreateElement(""); element != nil {
+			if factory := element.GetFactory(); factory != nil {
+				device.Element = factory.GetName()
+			}
+			device.Property, device.Device = deviceProperty(element)
+		}
+
+		if caps := d.GetCaps(); caps != nil {
+			for i := 0; i < caps.GetSize(); i++ {
+				if structure := caps.GetStructureAt(i); structure != nil {
+					device.Modes = append(device.Modes, deviceMode(structure))
+				}
+			}
+		}
+
+		device.Config = deviceConfig(device)
+		devices = append(devices, device)
+	}
+
+	sort.SliceStable(devices, func(i, j int) bool {
+		return devices[i].Class > devices[j].Class
+	})
+
+	return devices
+}
+
+func printDevice(device CaptureDevice) {
+	fmt.Printf("%s (%s)\n", device.Name, device.Class)
+	fmt.Printf("  Element: %s\n", device.Element)
+	if device.Device != "" {
+		fmt.Printf("  Device: %s\n", device.Device)
+	}
+
+	fmt.Println("  Caps:")
+	for _, mode := range device.Modes {
+		fmt.Printf("    %s\n", mode.Caps)
+	}
+
+	fmt.Println("  Configuration:")
+	for _, line := range strings.Split(strings.TrimSuffix(device.Config, "\n"), "\n") {
+		fmt.Printf("    %s\n", line)
+	}
+	fmt.Println()
+}
+
+// PrintDevices prints the capture devices with their caps and configuration
+// snippets, or as JSON
+func PrintDevices(asJson bool) {
+	gst.Init(nil)
+
+	devices := ListDevices()
+	if asJson {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(devices); err != nil {
+			log.Fatalln(err)
+		}
+		return
+	}
+
+	if len(devices) == 0 {
+		fmt.Println("No capture devices found")
+		return
+	}
+
+	for _, device := range devices {
+		printDevice(device)
+	}
+}
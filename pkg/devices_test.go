@@ -0,0 +1,49 @@
+/*
+ Copyright (c) 2024 Neeraj Jakhar
+
+ This program is free software: you can redistribute it and/or modify
+ it under the terms of the GNU General Public License as published by
+ the Free Software Foundation, either version 3 of the License, or
+ (at your option) any later version.
+
+ This program is distributed in the hope that it will be useful,
+ but WITHOUT ANY WARRANTY; without even the implied warranty of
+ MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License
+ along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/go-gst/go-gst/gst"
+	"github.com/homebackend/go-webrtc/pkg/media"
+)
+
+func TestDeviceMode(t *testing.T) {
+	requireGStreamer(t)
+
+	mode := deviceMode(gst.NewStructureFromString("video/x-raw, format=(string)YUY2, width=(int)640, height=(int)480, framerate=(fraction){ 30/1, 15/1 }"))
+	if mode.Format != media.FormatRaw || mode.PixelFormat != "YUY2" || mode.Width != 640 || mode.Height != 480 {
+		t.Errorf("Unexpected mode: %+v", mode)
+	}
+	if !slices.Equal(mode.Framerates, []string{"30/1", "15/1"}) {
+		t.Errorf("Unexpected framerates: %v", mode.Framerates)
+	}
+
+	mode = deviceMode(gst.NewStructureFromString("image/jpeg, width=(int)1920, height=(int)1080, framerate=(fraction)30/1"))
+	if mode.Format != media.FormatMjpeg || mode.Width != 1920 || !slices.Equal(mode.Framerates, []string{"30/1"}) {
+		t.Errorf("Unexpected mode: %+v", mode)
+	}
+
+	// Ranges are only available in the caps
+	mode = deviceMode(gst.NewStructureFromString("video/x-raw, width=(int)[ 1, 4096 ], height=(int)[ 1, 2160 ], framerate=(fraction)[ 0/1, 60/1 ]"))
+	if mode.Width != 0 || mode.Height != 0 || mode.Framerates != nil {
+		t.Errorf("Ranges taken as values: %+v", mode)
+	}
+}
@@ -0,0 +1,873 @@
+/*
+ Copyright (c) 2024 Neeraj Jakhar
+
+ This program is free software: you can redistribute it and/or modify
+ it under the terms of the GNU General Public License as published by
+ the Free Software Foundation, either version 3 of the License, or
+ (at your option) any later version.
+
+ This program is distributed in the hope that it will be useful,
+ but WITHOUT ANY WARRANTY; without even the implied warranty of
+ MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License
+ along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/go-gst/go-gst/gst"
+	"github.com/gorilla/websocket"
+	"github.com/homebackend/go-webrtc/pkg/signalling"
+	"github.com/pion/interceptor"
+	"github.com/pion/rtp"
+	"github.com/pion/rtp/codecs"
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media/samplebuilder"
+)
+
+const (
+	testImageWidth  = 320
+	testImageHeight = 240
+	testUser        = "viewer"
+	testPassword    = "secret"
+	// User who may neither view the test stream nor do anything else
+	restrictedUser = "restricted"
+	// Time allowed for a stream to start, which includes the ICE gathering
+	// of both sides
+	streamTimeout = 30 * time.Second
+	eventTimeout  = 10 * time.Second
+)
+
+// Commands run by the server in child processes
+var childCommands = map[string]bool{
+	"server":   true,
+	"execute":  true,
+	"record":   true,
+	"snapshot": true,
+	"monitor":  true,
+}
+
+// Elements needed to stream the test sources
+var testElements = []string{"videotestsrc", "audiotestsrc", "timeoverlay", "videoconvert", "audioconvert", "audioresample", "vp8enc", "opusenc", "appsink"}
+
+// TestMain runs the server when the test binary is started as one of the
+// child processes, as the server starts them with its own executable
+func TestMain(m *testing.M) {
+	if len(os.Args) > 1 && childCommands[os.Args[1]] {
+		main()
+		os.Exit(0)
+	}
+
+	os.Exit(m.Run())
+}
+
+func requireGStreamer(t *testing.T) {
+	t.Helper()
+
+	if testing.Short() {
+		t.Skip("Streaming tests are skipped in short mode")
+	}
+
+	gst.Init(nil)
+	for _, element := range testElements {
+		if gst.Find(element) == nil {
+			t.Skipf("GStreamer element %s is not available", element)
+		}
+	}
+}
+
+func freePort(t *testing.T, network string) int {
+	t.Helper()
+
+	if network == "udp" {
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+
+		return conn.LocalAddr().(*net.UDPAddr).Port
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+type testServer struct {
+	port     int
+	turnPort int
+	url      string
+	logFile  string
+}
+
+// startTestServer runs the server with test sources and an internal turn
+// server, so that everything happens on the loopback interface
+func startTestServer(t *testing.T, signalling, url string, disconnectOnReconnect bool) *testServer {
+	t.Helper()
+
+	dir := t.TempDir()
+	server := &testServer{
+		port:     freePort(t, "tcp"),
+		turnPort: freePort(t, "udp"),
+		url:      url,
+		logFile:  filepath.Join(dir, "server.log"),
+	}
+
+	config := fmt.Sprintf(`port: %d
+url: %s
+signalling: %s
+image_width: %d
+image_height: %d
+framerate: 15
+log_file: %s
+recording_directory: %s
+disconnect_on_reconnect: %t
+video_source:
+  type: test
+audio_source:
+  type: test
+signalling_credentials:
+  - user: %s
+    password: %s
+  - user: %s
+    password: %s
+    streams: [/other]
+    permissions: []
+use_internal_turn: true
+turn_configuration:
+  type: internal
+  public_ip: 127.0.0.1
+  port: %d
+  realm: test
+  threads: 1
+  users:
+    - user: turn
+      password: turn
+`, server.port, url, signalling, testImageWidth, testImageHeight, server.logFile, dir, disconnectOnReconnect,
+		testUser, testPassword, restrictedUser, testPassword, server.turnPort)
+
+	configFile := filepath.Join(dir, "config.yaml")
+	if err := os.WriteFile(configFile, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(os.Args[0], "server", "-c", configFile)
+	// The streaming processes are killed along with the server
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		cmd.Wait()
+
+		if t.Failed() {
+			if log, err := os.ReadFile(server.logFile); err == nil {
+				t.Logf("Server log:\n%s", log)
+			}
+		}
+	})
+
+	deadline := time.Now().Add(eventTimeout)
+	for {
+		conn, err := net.Dial("tcp", server.address())
+		if err == nil {
+			conn.Close()
+			return server
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("Server did not start: %v", err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func (s *testServer) address() string {
+	return fmt.Sprintf("127.0.0.1:%d", s.port)
+}
+
+// testPeer is the receiving end of a stream
+type testPeer struct {
+	t            *testing.T
+	pc           *webrtc.PeerConnection
+	connected    chan struct{}
+	videoDecoded chan struct{}
+	audioDecoded chan struct{}
+}
+
+func newTestPeer(t *testing.T, server *testServer) *testPeer {
+	t.Helper()
+
+	m := &webrtc.MediaEngine{}
+	if err := m.RegisterDefaultCodecs(); err != nil {
+		t.Fatal(err)
+	}
+
+	i := &interceptor.Registry{}
+	if err := webrtc.RegisterDefaultInterceptors(m, i); err != nil {
+		t.Fatal(err)
+	}
+
+	s := webrtc.SettingEngine{}
+	s.SetIncludeLoopbackCandidate(true)
+
+	api := webrtc.NewAPI(webrtc.WithMediaEngine(m), webrtc.WithInterceptorRegistry(i), webrtc.WithSettingEngine(s))
+	pc, err := api.NewPeerConnection(webrtc.Configuration{
+		ICEServers: []webrtc.ICEServer{{URLs: []string{fmt.Sprintf("stun:127.0.0.1:%d", server.turnPort)}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	peer := &testPeer{
+		t:            t,
+		pc:           pc,
+		connected:    make(chan struct{}),
+		videoDecoded: make(chan struct{}),
+		audioDecoded: make(chan struct{}),
+	}
+	t.Cleanup(func() { pc.Close() })
+
+	var connectedOnce sync.Once
+	pc.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		if state == webrtc.PeerConnectionStateConnected {
+			connectedOnce.Do(func() { close(peer.connected) })
+		}
+	})
+
+	pc.OnTrack(peer.readTrack)
+
+	for _, kind := range []webrtc.RTPCodecType{webrtc.RTPCodecTypeAudio, webrtc.RTPCodecTypeVideo} {
+		if _, err := pc.AddTransceiverFromKind(kind, webrtc.RTPTransceiverInit{Direction: webrtc.RTPTransceiverDirectionRecvonly}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return peer
+}
+
+// isVP8Keyframe checks that a frame is a VP8 keyframe of the configured size
+func isVP8Keyframe(frame []byte) bool {
+	if len(frame) < 10 || frame[0]&0x01 != 0 || !bytes.Equal(frame[3:6], []byte{0x9d, 0x01, 0x2a}) {
+		return false
+	}
+
+	width := binary.LittleEndian.Uint16(frame[6:8]) & 0x3fff
+	height := binary.LittleEndian.Uint16(frame[8:10]) & 0x3fff
+	return width == testImageWidth && height == testImageHeight
+}
+
+// Largest opus frame and packet duration, RFC 6716 section 3
+const (
+	opusMaxFrameSize = 1275
+	opusMaxDuration  = 120 * time.Millisecond
+)
+
+// opusFrameDuration returns the duration of the frames of a configuration
+// of the table of contents
+func opusFrameDuration(config byte) time.Duration {
+	switch {
+	case config < 12:
+		// SILK
+		return []time.Duration{10, 20, 40, 60}[config%4] * time.Millisecond
+	case config < 16:
+		// Hybrid
+		return []time.Duration{10, 20}[config%2] * time.Millisecond
+	default:
+		// CELT
+		return []time.Duration{2500, 5000, 10000, 20000}[config%4] * time.Microsecond
+	}
+}
+
+// opusFrameLength reads a frame length coded in one or two bytes
+func opusFrameLength(data []byte) (int, int, bool) {
+	if len(data) == 0 {
+		return 0, 0, false
+	} else if data[0] < 252 {
+		return int(data[0]), 1, true
+	} else if len(data) < 2 {
+		return 0, 0, false
+	}
+
+	return int(data[0]) + 4*int(data[1]), 2, true
+}
+
+// isOpusPacket checks that a packet is well-formed according to the rules
+// of RFC 6716 section 3.4: the table of contents, the frame count and the
+// frame lengths have to match the size of the packet
+func isOpusPacket(packet []byte) bool {
+	if len(packet) < 1 {
+		return false
+	}
+
+	toc, data := packet[0], packet[1:]
+	switch toc & 0x03 {
+	case 0:
+		// One frame
+		return len(data) <= opusMaxFrameSize
+	case 1:
+		// Two frames of equal size
+		return len(data)%2 == 0 && len(data)/2 <= opusMaxFrameSize
+	case 2:
+		// Two frames, the length of the first is coded
+		length, n, ok := opusFrameLength(data)
+		if !ok || length > len(data)-n {
+			return false
+		}
+		return length <= opusMaxFrameSize && len(data)-n-length <= opusMaxFrameSize
+	}
+
+	// An arbitrary number of frames, counted in the second byte
+	if len(data) < 1 {
+		return false
+	}
+	count := int(data[0] & 0x3f)
+	vbr, padded := data[0]&0x80 != 0, data[0]&0x40 != 0
+	data = data[1:]
+	if count == 0 || time.Duration(count)*opusFrameDuration(toc>>3) > opusMaxDuration {
+		return false
+	}
+
+	if padded {
+		padding := 0
+		for {
+			if len(data) == 0 {
+				return false
+			}
+			b := data[0]
+			data = data[1:]
+			if b < 255 {
+				padding += int(b)
+				break
+			}
+			padding += 254
+		}
+		if padding > len(data) {
+			return false
+		}
+		data = data[:len(data)-padding]
+	}
+
+	if !vbr {
+		return len(data)%count == 0 && len(data)/count <= opusMaxFrameSize
+	}
+
+	// The lengths of all frames but the last are coded
+	for i := 0; i < count-1; i++ {
+		length, n, ok := opusFrameLength(data)
+		if !ok || length > opusMaxFrameSize || length > len(data)-n {
+			return false
+		}
+		data = data[n+length:]
+	}
+
+	return len(data) <= opusMaxFrameSize
+}
+
+// readTrack assembles the received RTP packets into frames and checks that
+// they are well-formed, video has to contain a keyframe of the configured
+// size
+func (p *testPeer) readTrack(track *webrtc.TrackRemote, _ *webrtc.RTPReceiver) {
+	var depacketizer rtp.Depacketizer
+	var decodes func([]byte) bool
+	var decoded chan struct{}
+
+	switch track.Kind() {
+	case webrtc.RTPCodecTypeVideo:
+		depacketizer, decodes, decoded = &codecs.VP8Packet{}, isVP8Keyframe, p.videoDecoded
+	case webrtc.RTPCodecTypeAudio:
+		depacketizer, decodes, decoded = &codecs.OpusPacket{}, isOpusPacket, p.audioDecoded
+	default:
+		return
+	}
+
+	builder := samplebuilder.New(64, depacketizer, track.Codec().ClockRate)
+	done := false
+	for {
+		packet, _, err := track.ReadRTP()
+		if err != nil {
+			return
+		}
+
+		builder.Push(packet)
+		for sample := builder.Pop(); sample != nil && !done; sample = builder.Pop() {
+			if decodes(sample.Data) {
+				close(decoded)
+				done = true
+			}
+		}
+	}
+}
+
+// offer returns the offer with all candidates, encoded like the web client does
+func (p *testPeer) offer() string {
+	p.t.Helper()
+
+	offer, err := p.pc.CreateOffer(nil)
+	if err != nil {
+		p.t.Fatal(err)
+	}
+
+	gathered := webrtc.GatheringCompletePromise(p.pc)
+	if err := p.pc.SetLocalDescription(offer); err != nil {
+		p.t.Fatal(err)
+	}
+	<-gathered
+
+	data, err := json.Marshal(p.pc.LocalDescription())
+	if err != nil {
+		p.t.Fatal(err)
+	}
+
+	return base64.StdEncoding.EncodeToString(data)
+}
+
+func (p *testPeer) accept(answer string) {
+	p.t.Helper()
+
+	data, err := base64.StdEncoding.DecodeString(answer)
+	if err != nil {
+		p.t.Fatal(err)
+	}
+
+	var description webrtc.SessionDescription
+	if err := json.Unmarshal(data, &description); err != nil {
+		p.t.Fatal(err)
+	}
+
+	if err := p.pc.SetRemoteDescription(description); err != nil {
+		p.t.Fatal(err)
+	}
+}
+
+// expectMedia waits for ICE to complete and for both tracks to decode
+func (p *testPeer) expectMedia() {
+	p.t.Helper()
+
+	timeout := time.After(streamTimeout)
+	for name, done := range map[string]chan struct{}{"connection": p.connected, "video": p.videoDecoded, "audio": p.audioDecoded} {
+		select {
+		case <-done:
+		case <-timeout:
+			p.t.Fatalf("Timed out waiting for %s", name)
+		}
+	}
+}
+
+// wsSignalling is a websocket signalling connection
+type wsSignalling struct {
+	t      *testing.T
+	conn   *websocket.Conn
+	events chan signalling.Event
+	closed chan struct{}
+}
+
+func dialWebsocket(t *testing.T, server *testServer) *wsSignalling {
+	t.Helper()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws://"+server.address()+server.url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	ws := &wsSignalling{t: t, conn: conn, events: make(chan signalling.Event, 64), closed: make(chan struct{})}
+	go func() {
+		defer close(ws.closed)
+		for {
+			var event signalling.Event
+			if err := conn.ReadJSON(&event); err != nil {
+				return
+			}
+			ws.events <- event
+		}
+	}()
+
+	return ws
+}
+
+func (ws *wsSignalling) send(eventType string, payload interface{}) {
+	ws.t.Helper()
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		ws.t.Fatal(err)
+	}
+
+	event := signalling.Event{Id: eventType, Type: eventType, Payload: data}
+	if err := ws.conn.WriteJSON(event); err != nil {
+		ws.t.Fatal(err)
+	}
+}
+
+// expect waits for an event of the type, skipping other events
+func (ws *wsSignalling) expect(eventType string, timeout time.Duration) signalling.Event {
+	ws.t.Helper()
+
+	deadline := time.After(timeout)
+	for {
+		select {
+		case event := <-ws.events:
+			if event.Type == eventType {
+				return event
+			}
+			ws.t.Logf("Skipping %s event: %s", event.Type, event.Payload)
+		case <-ws.closed:
+			ws.t.Fatalf("Connection closed while waiting for %s event", eventType)
+		case <-deadline:
+			ws.t.Fatalf("Timed out waiting for %s event", eventType)
+		}
+	}
+}
+
+func (ws *wsSignalling) expectError(code string) {
+	ws.t.Helper()
+
+	var errorEvent signalling.ErrorEvent
+	if err := json.Unmarshal(ws.expect(signalling.EventError, eventTimeout).Payload, &errorEvent); err != nil {
+		ws.t.Fatal(err)
+	}
+	if errorEvent.Code != code {
+		ws.t.Fatalf("Expected %s error, got %s: %s", code, errorEvent.Code, errorEvent.Message)
+	}
+}
+
+// expectState waits for a state event with the given state
+func (ws *wsSignalling) expectState(state string, timeout time.Duration) {
+	ws.t.Helper()
+
+	deadline := time.Now().Add(timeout)
+	for {
+		var stateEvent signalling.StateEvent
+		if err := json.Unmarshal(ws.expect(signalling.EventState, time.Until(deadline)).Payload, &stateEvent); err != nil {
+			ws.t.Fatal(err)
+		}
+		if stateEvent.State == state {
+			return
+		}
+	}
+}
+
+// connect streams to the peer over the websocket
+func (ws *wsSignalling) connect(peer *testPeer) {
+	ws.t.Helper()
+
+	ws.send(signalling.EventConnect, signalling.ConnectEvent{Version: signalling.ProtocolVersion, SDP: peer.offer(), User: testUser, Password: testPassword})
+	ws.expect(signalling.EventConnectAck, eventTimeout)
+
+	var answerEvent signalling.AnswerEvent
+	if err := json.Unmarshal(ws.expect(signalling.EventAnswer, streamTimeout).Payload, &answerEvent); err != nil {
+		ws.t.Fatal(err)
+	}
+	peer.accept(answerEvent.Answer)
+}
+
+func TestWebsocketSignalling(t *testing.T) {
+	requireGStreamer(t)
+	server := startTestServer(t, "websocket", "/ws", true)
+
+	t.Run("rejects events before connect", func(t *testing.T) {
+		ws := dialWebsocket(t, server)
+		ws.send(signalling.EventStats, nil)
+		ws.expectError(signalling.ErrorCodeUnauthorized)
+	})
+
+	t.Run("rejects invalid credentials", func(t *testing.T) {
+		ws := dialWebsocket(t, server)
+		peer := newTestPeer(t, server)
+		ws.send(signalling.EventConnect, signalling.ConnectEvent{Version: signalling.ProtocolVersion, SDP: peer.offer(), User: testUser, Password: "wrong"})
+		ws.expect(signalling.EventDisconnect, eventTimeout)
+		ws.expectError(signalling.ErrorCodeInvalidCredentials)
+	})
+
+	t.Run("refuses streams the user may not view", func(t *testing.T) {
+		ws := dialWebsocket(t, server)
+		peer := newTestPeer(t, server)
+		ws.send(signalling.EventConnect, signalling.ConnectEvent{Version: signalling.ProtocolVersion, SDP: peer.offer(), User: restrictedUser, Password: testPassword})
+		ws.expectError(signalling.ErrorCodeForbidden)
+	})
+
+	t.Run("streams audio and video", func(t *testing.T) {
+		ws := dialWebsocket(t, server)
+		peer := newTestPeer(t, server)
+		ws.connect(peer)
+		peer.expectMedia()
+		ws.expectState(signalling.StateConnected, eventTimeout)
+	})
+
+	t.Run("reconnect disconnects the previous viewer", func(t *testing.T) {
+		first := dialWebsocket(t, server)
+		firstPeer := newTestPeer(t, server)
+		first.connect(firstPeer)
+		firstPeer.expectMedia()
+
+		second := dialWebsocket(t, server)
+		secondPeer := newTestPeer(t, server)
+		second.connect(secondPeer)
+		first.expectState(signalling.StateSessionEnded, eventTimeout)
+		secondPeer.expectMedia()
+
+		// Disconnecting removes the client, which closes the connection
+		second.send(signalling.EventDisconnect, signalling.DisconnectEvent{Message: "test finished"})
+		select {
+		case <-second.closed:
+		case <-time.After(eventTimeout):
+			t.Fatal("Connection was not closed after disconnect")
+		}
+	})
+
+	t.Run("lists and kicks sessions", func(t *testing.T) {
+		dialWebsocket(t, server)
+		ws := dialWebsocket(t, server)
+		peer := newTestPeer(t, server)
+		ws.connect(peer)
+		peer.expectMedia()
+
+		var list SessionList
+		if status := apiRequest(t, server, http.MethodGet, "/api/sessions", &list); status != http.StatusOK {
+			t.Fatalf("Unexpected status listing sessions: %d", status)
+		}
+		if len(list.Sessions) == 0 || len(list.Unauthorized) == 0 {
+			t.Fatalf("Session or unauthorized client not listed: %+v", list)
+		}
+		latest := list.Sessions[len(list.Sessions)-1]
+		if latest.User != testUser || latest.Stream != server.url {
+			t.Fatalf("Unexpected session: %+v", latest)
+		}
+
+		if status := apiRequest(t, server, http.MethodDelete, "/api/sessions?id="+latest.Id, nil); status != http.StatusNoContent {
+			t.Fatalf("Unexpected status kicking session: %d", status)
+		}
+		ws.expectState(signalling.StateSessionEnded, eventTimeout)
+
+		if status := apiRequest(t, server, http.MethodDelete, "/api/sessions?id="+latest.Id, nil); status != http.StatusNotFound {
+			t.Fatalf("Kicking an ended session returned status %d", status)
+		}
+	})
+}
+
+// apiRequest calls the admin api with the test credentials and decodes the
+// response into v, if given
+func apiRequest(t *testing.T, server *testServer, method, path string, v interface{}) int {
+	t.Helper()
+
+	request, err := http.NewRequest(method, "http://"+server.address()+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.SetBasicAuth(testUser, testPassword)
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+
+	if v != nil {
+		if err := json.NewDecoder(response.Body).Decode(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return response.StatusCode
+}
+
+// postOffer requests a stream in http signalling mode
+func postOffer(t *testing.T, server *testServer, peer *testPeer) *http.Response {
+	t.Helper()
+
+	body, err := json.Marshal(signalling.Request{SDP: peer.offer()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request, err := http.NewRequest(http.MethodPost, "http://"+server.address()+server.url, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.SetBasicAuth(testUser, testPassword)
+
+	client := &http.Client{Timeout: streamTimeout}
+	response, err := client.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { response.Body.Close() })
+
+	return response
+}
+
+func deleteStreamRequest(t *testing.T, server *testServer) {
+	t.Helper()
+
+	request, err := http.NewRequest(http.MethodDelete, "http://"+server.address()+server.url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.SetBasicAuth(testUser, testPassword)
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+
+	if response.StatusCode != http.StatusNoContent {
+		t.Fatalf("Unexpected status deleting stream: %d", response.StatusCode)
+	}
+}
+
+func TestHttpSignalling(t *testing.T) {
+	requireGStreamer(t)
+	server := startTestServer(t, "http", "/stream", false)
+
+	t.Run("api requires credentials", func(t *testing.T) {
+		request, err := http.NewRequest(http.MethodPut, "http://"+server.address()+"/api/overlay", strings.NewReader(`{"text": "test"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		response.Body.Close()
+
+		if response.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("Expected status %d, got %d", http.StatusUnauthorized, response.StatusCode)
+		}
+	})
+
+	t.Run("api enforces permissions", func(t *testing.T) {
+		for _, test := range []struct{ method, path, body string }{
+			{http.MethodPut, "/api/overlay", `{"text": "test"}`},
+			{http.MethodPost, server.url, `{"sdp": ""}`},
+			{http.MethodGet, "/api/sessions", ""},
+		} {
+			request, err := http.NewRequest(test.method, "http://"+server.address()+test.path, strings.NewReader(test.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			request.SetBasicAuth(restrictedUser, testPassword)
+
+			response, err := http.DefaultClient.Do(request)
+			if err != nil {
+				t.Fatal(err)
+			}
+			response.Body.Close()
+
+			if response.StatusCode != http.StatusForbidden {
+				t.Errorf("%s %s: expected status %d, got %d", test.method, test.path, http.StatusForbidden, response.StatusCode)
+			}
+		}
+	})
+
+	t.Run("streams until deleted", func(t *testing.T) {
+		peer := newTestPeer(t, server)
+		response := postOffer(t, server, peer)
+		if response.StatusCode != http.StatusOK {
+			t.Fatalf("Unexpected status: %d", response.StatusCode)
+		}
+
+		var answer signalling.Response
+		if err := json.NewDecoder(response.Body).Decode(&answer); err != nil {
+			t.Fatal(err)
+		}
+		peer.accept(answer.SDP)
+		peer.expectMedia()
+
+		// Only one stream is allowed without disconnect_on_reconnect
+		busy := postOffer(t, server, newTestPeer(t, server))
+		if busy.StatusCode != http.StatusInternalServerError {
+			t.Fatalf("Expected a second stream to be refused, got status %d", busy.StatusCode)
+		}
+
+		deleteStreamRequest(t, server)
+
+		next := newTestPeer(t, server)
+		response = postOffer(t, server, next)
+		if response.StatusCode != http.StatusOK {
+			t.Fatalf("Unexpected status after delete: %d", response.StatusCode)
+		}
+		if err := json.NewDecoder(response.Body).Decode(&answer); err != nil {
+			t.Fatal(err)
+		}
+		next.accept(answer.SDP)
+		next.expectMedia()
+		deleteStreamRequest(t, server)
+	})
+}
+
+func TestIsOpusPacket(t *testing.T) {
+	valid := [][]byte{
+		// One 20 ms CELT frame
+		{0xf8, 1, 2, 3},
+		// Two frames of equal size
+		{0xf9, 1, 2, 3, 4},
+		// Two frames, the first one byte long
+		{0xfa, 1, 9, 8, 7},
+		// Three CBR frames with one byte of padding
+		{0xfb, 0x43, 1, 1, 2, 3, 0},
+		// Two VBR frames
+		{0xfb, 0x82, 2, 1, 2, 3},
+	}
+	for _, packet := range valid {
+		if !isOpusPacket(packet) {
+			t.Errorf("Valid packet rejected: %v", packet)
+		}
+	}
+
+	invalid := [][]byte{
+		{},
+		// Frames of equal size with an odd length
+		{0xf9, 1, 2, 3},
+		// First frame longer than the packet
+		{0xfa, 5, 1},
+		// Frame count missing
+		{0xfb},
+		// No frames
+		{0xfb, 0x00},
+		// 7 frames of 20 ms exceed 120 ms
+		{0xfb, 0x07, 1, 2, 3, 4, 5, 6, 7},
+		// CBR frames not dividing the packet
+		{0xfb, 0x02, 1, 2, 3},
+		// More padding than data
+		{0xfb, 0x41, 5, 1},
+		// VBR frame length longer than the packet
+		{0xfb, 0x82, 9, 1},
+		// Single frame longer than allowed
+		append([]byte{0xf8}, make([]byte, opusMaxFrameSize+1)...),
+	}
+	for _, packet := range invalid {
+		if isOpusPacket(packet) {
+			t.Errorf("Invalid packet accepted: %v", packet[:min(len(packet), 10)])
+		}
+	}
+}
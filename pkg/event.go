@@ -19,144 +19,204 @@ package main
 
 import (
 	"encoding/json"
-	"errors"
-	"fmt"
 	"log"
+	"time"
 
+	"github.com/go-playground/validator/v10"
+	"github.com/homebackend/go-webrtc/pkg/signalling"
 	"github.com/pion/webrtc/v3"
 )
 
-var (
-	ErrorInvalidCredentials = errors.New("invalid credentials")
-	ErrorUnauthorized       = errors.New("unauthorized")
-)
-
-type Event struct {
-	Type    string          `json:"type" validate:"oneof:connect candidate disconnect"`
-	Payload json.RawMessage `json:"payload"`
-}
-
-type EventHandler func(event Event, c *Client) error
-
-const (
-	EventConnect      = "connect"
-	EventAnswer       = "answer"
-	EventNewCandidate = "new_candidate"
-	EventDisconnect   = "disconnect"
-)
-
-type ConnectEvent struct {
-	SDP      string `json:"sdp" validate:"required"`
-	User     string `json:"user"`
-	Password string `json:"password"`
-}
+var validate = validator.New()
 
-type AnswerEvent struct {
-	Answer string `json:"answer" validate:"required"`
-}
-
-type DisconnectEvent struct {
-	Message string `json:"message" validate:"required"`
-}
-
-type NewCandidateEvent struct {
-	Candidate webrtc.ICECandidate `json:"candidate" validate:"required"`
-}
+// EventHandler processes an event received from the client. The returned
+// event, if any, is sent back to the client as the reply to the request.
+type EventHandler func(event signalling.Event, c *Client) (*signalling.Event, error)
 
-func ConnectHandler(event Event, c *Client) error {
+func ConnectHandler(event signalling.Event, c *Client) (*signalling.Event, error) {
 	if c.authorized {
 		log.Println("Already authorized")
-		return nil
+		return nil, nil
+	}
+
+	var connectEvent signalling.ConnectEvent
+	if err := signalling.DecodePayload(event, &connectEvent); err != nil {
+		return nil, err
 	}
 
-	var connectEvent ConnectEvent
-	if err := json.Unmarshal(event.Payload, &connectEvent); err != nil {
-		return fmt.Errorf("invalid connect request: %v", err)
+	// Clients predating the version handshake do not send a version
+	if connectEvent.Version == 0 {
+		connectEvent.Version = 1
 	}
 
-	if len(c.manager.config.SignallingCredentials) == 0 {
+	// Newer clients fall back to the latest version supported by the server,
+	// which is acknowledged
+	connectEvent.Version = min(connectEvent.Version, signalling.ProtocolVersion)
+
+	account, authorized := c.manager.config.Authenticate(connectEvent.User, connectEvent.Password)
+	if authorized && account == nil {
 		log.Println("No signalling credentials: authorized")
-		c.authorized = true
+	} else if authorized {
+		log.Printf("Credential match success for: %s\n", connectEvent.User)
 	}
 
-	for _, credential := range c.manager.config.SignallingCredentials {
-		if credential.User == connectEvent.User && credential.Password == connectEvent.Password {
-			c.authorized = true
-			log.Printf("Credential match success for: %s\n", connectEvent.User)
-			break
-		}
+	if authorized && !account.CanView(c.manager.config.Url) {
+		log.Printf("%s may not view %s\n", connectEvent.User, c.manager.config.Url)
+		return nil, signalling.ErrorForbidden
 	}
 
-	if !c.authorized {
+	c.manager.identify(c, connectEvent.User, account, authorized)
+	if !authorized {
 		log.Printf("Authorization failure for: %s\n", connectEvent.User)
-		c.egress <- GetDisconnectEvent(ErrorInvalidCredentials.Error())
-		return ErrorInvalidCredentials
+		c.manager.notifier.Notify(NotificationAuthFailure, c.notificationData())
+		c.sendEvent(GetDisconnectEvent(signalling.ErrorInvalidCredentials.Error()))
+		return nil, signalling.ErrorInvalidCredentials
 	} else {
+		c.version = connectEvent.Version
 		c.sdp = connectEvent.SDP
-		c.manager.clientConnect <- c
-		return nil
+		c.connectId = event.Id
+		ack := GetConnectAckEvent(c.version)
+		return &ack, nil
 	}
 }
 
-func DisconnectHandler(event Event, c *Client) error {
+func DisconnectHandler(event signalling.Event, c *Client) (*signalling.Event, error) {
 	if !c.authorized {
-		return ErrorUnauthorized
+		return nil, signalling.ErrorUnauthorized
+	}
+
+	// Older clients disconnect without a payload
+	var disconnectEvent signalling.DisconnectEvent
+	if len(event.Payload) > 0 && string(event.Payload) != "null" {
+		if err := signalling.DecodePayload(event, &disconnectEvent); err != nil {
+			return nil, err
+		}
 	}
 
+	log.Printf("Client disconnected: %s\n", disconnectEvent.Message)
 	c.manager.removeClient(c)
-	return nil
+	return nil, nil
 }
 
-func GetDisconnectEvent(message string) Event {
-	var disconnectEvent DisconnectEvent
-	disconnectEvent.Message = message
+func RemoteCandidateHandler(event signalling.Event, c *Client) (*signalling.Event, error) {
+	var candidateEvent signalling.CandidateEvent
+	if err := signalling.DecodePayload(event, &candidateEvent); err != nil {
+		return nil, err
+	}
 
-	var event Event
-	event.Type = EventDisconnect
-	if payload, err := json.Marshal(disconnectEvent); err != nil {
-		log.Fatalln(err)
-	} else {
-		event.Payload = payload
+	if candidateEvent.Candidate.Candidate == "" {
+		log.Println("Remote end of candidates")
+		return nil, nil
 	}
 
-	log.Println(event)
-	return event
-}
+	candidate, err := json.Marshal(candidateEvent.Candidate)
+	if err != nil {
+		return nil, err
+	}
 
-func GetAnswerEvent(answer string) Event {
-	var answerEvent AnswerEvent
-	answerEvent.Answer = answer
+	select {
+	case c.candidates <- string(candidate):
+		return nil, nil
+	default:
+		return nil, &signalling.ProtocolError{Code: signalling.ErrorCodeTooManyCandidates, Message: "too many candidates pending"}
+	}
+}
 
-	var event Event
-	event.Type = EventAnswer
-	if payload, err := json.Marshal(answerEvent); err != nil {
+func newEvent(eventType string, payload interface{}) signalling.Event {
+	var event signalling.Event
+	event.Type = eventType
+	if p, err := json.Marshal(payload); err != nil {
 		log.Fatalln(err)
 	} else {
-		event.Payload = payload
+		event.Payload = p
 	}
 
 	log.Println(event)
 	return event
 }
 
-func GetNewCandidateEvent(c string) Event {
-	var candidate webrtc.ICECandidate
+func GetConnectAckEvent(version int) signalling.Event {
+	return newEvent(signalling.EventConnectAck, signalling.ConnectAckEvent{Version: version})
+}
+
+func GetDisconnectEvent(message string) signalling.Event {
+	return newEvent(signalling.EventDisconnect, signalling.DisconnectEvent{Message: message})
+}
+
+func GetSessionEvent(session string) signalling.Event {
+	return newEvent(signalling.EventSession, signalling.SessionEvent{Session: session})
+}
+
+func GetAnswerEvent(answer string) signalling.Event {
+	return newEvent(signalling.EventAnswer, signalling.AnswerEvent{Answer: answer})
+}
+
+func GetNewCandidateEvent(c string) signalling.Event {
+	var candidate webrtc.ICECandidateInit
 
 	if err := json.Unmarshal([]byte(c), &candidate); err != nil {
 		log.Fatalln(err)
 	}
 
-	var candidateEvent NewCandidateEvent
-	candidateEvent.Candidate = candidate
+	return newEvent(signalling.EventNewCandidate, signalling.NewCandidateEvent{Candidate: candidate})
+}
 
-	var event Event
-	event.Type = EventNewCandidate
-	if payload, err := json.Marshal(candidateEvent); err != nil {
-		log.Fatalln(err)
-	} else {
-		event.Payload = payload
+func GetStateEvent(state, reason string) signalling.Event {
+	return newEvent(signalling.EventState, signalling.StateEvent{State: state, Reason: reason})
+}
+
+func GetMotionEvent(motion bool) signalling.Event {
+	return newEvent(signalling.EventMotion, signalling.MotionEvent{Motion: motion})
+}
+
+func GetSoundEvent(sound bool) signalling.Event {
+	return newEvent(signalling.EventSound, signalling.SoundEvent{Sound: sound})
+}
+
+func GetQueueEvent(position int) signalling.Event {
+	return newEvent(signalling.EventQueue, signalling.QueueEvent{Position: position})
+}
+
+func GetKickEvent(reason string) signalling.Event {
+	return newEvent(signalling.EventKick, signalling.KickEvent{Reason: reason})
+}
+
+// GetWarningEvent rounds the remaining time up to whole seconds
+func GetWarningEvent(reason string, remaining time.Duration) signalling.Event {
+	seconds := int((remaining + time.Second - 1) / time.Second)
+	return newEvent(signalling.EventWarning, signalling.WarningEvent{Reason: reason, Seconds: seconds})
+}
+
+func GetStatsEvent(stats MonitorStats) signalling.Event {
+	return newEvent(signalling.EventStats, signalling.StatsEvent(stats))
+}
+
+// VisibilityHandler records whether the viewer shows the stream
+func VisibilityHandler(event signalling.Event, c *Client) (*signalling.Event, error) {
+	var visibilityEvent signalling.VisibilityEvent
+	if err := signalling.DecodePayload(event, &visibilityEvent); err != nil {
+		return nil, err
 	}
 
-	log.Println(event)
-	return event
+	if !c.manager.sessions.SetVisible(c.id, visibilityEvent.Visible) {
+		log.Printf("No session to set the visibility of for client %s\n", c.id)
+	}
+	return nil, nil
+}
+
+// StatsHandler replies with the current motion and sound state
+func StatsHandler(event signalling.Event, c *Client) (*signalling.Event, error) {
+	stats := GetStatsEvent(c.manager.monitor.Stats())
+	return &stats, nil
+}
+
+// GetErrorEvent converts err into an error event. Errors which are not
+// protocol errors are reported as internal errors.
+func GetErrorEvent(err error) signalling.Event {
+	errorEvent := signalling.ErrorEvent{Code: signalling.ErrorCodeInternal, Message: err.Error()}
+	if protocolError, ok := err.(*signalling.ProtocolError); ok {
+		errorEvent.Code = protocolError.Code
+	}
+
+	return newEvent(signalling.EventError, errorEvent)
 }
@@ -0,0 +1,140 @@
+/*
+ Copyright (c) 2024 Neeraj Jakhar
+
+ This program is free software: you can redistribute it and/or modify
+ it under the terms of the GNU General Public License as published by
+ the Free Software Foundation, either version 3 of the License, or
+ (at your option) any later version.
+
+ This program is distributed in the hope that it will be useful,
+ but WITHOUT ANY WARRANTY; without even the implied warranty of
+ MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License
+ along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"log"
+	"regexp"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+const DefaultDiscoveryPrefix = "homeassistant"
+
+var invalidNodeIdCharacters = regexp.MustCompile("[^a-zA-Z0-9_-]")
+
+type HomeAssistantDevice struct {
+	Identifiers  []string `json:"identifiers"`
+	Name         string   `json:"name"`
+	Manufacturer string   `json:"manufacturer"`
+	Model        string   `json:"model"`
+}
+
+// HomeAssistantEntity is the discovery payload of an entity. Only the
+// fields relevant for the component are set.
+type HomeAssistantEntity struct {
+	Name              string              `json:"name"`
+	UniqueId          string              `json:"unique_id"`
+	AvailabilityTopic string              `json:"availability_topic"`
+	Device            HomeAssistantDevice `json:"device"`
+	Topic             string              `json:"topic,omitempty"`
+	StateTopic        string              `json:"state_topic,omitempty"`
+	CommandTopic      string              `json:"command_topic,omitempty"`
+	DeviceClass       string              `json:"device_class,omitempty"`
+	PayloadOn         string              `json:"payload_on,omitempty"`
+	PayloadOff        string              `json:"payload_off,omitempty"`
+	Icon              string              `json:"icon,omitempty"`
+}
+
+func (b *MqttBridge) discoveryPrefix() string {
+	if b.config.Mqtt.DiscoveryPrefix == "" {
+		return DefaultDiscoveryPrefix
+	}
+
+	return b.config.Mqtt.DiscoveryPrefix
+}
+
+func (b *MqttBridge) nodeId() string {
+	return invalidNodeIdCharacters.ReplaceAllString(b.clientId(), "_")
+}
+
+func (b *MqttBridge) newEntity(name, objectId string) HomeAssistantEntity {
+	deviceName := b.config.Name
+	if deviceName == "" {
+		deviceName = b.clientId()
+	}
+
+	return HomeAssistantEntity{
+		Name:              name,
+		UniqueId:          b.nodeId() + "_" + objectId,
+		AvailabilityTopic: b.topic("status"),
+		Device: HomeAssistantDevice{
+			Identifiers:  []string{b.nodeId()},
+			Name:         deviceName,
+			Manufacturer: "homebackend",
+			Model:        "gowebrtc",
+		},
+	}
+}
+
+// publishDiscovery announces the camera and its sensors and switches to
+// Home Assistant
+func (b *MqttBridge) publishDiscovery() {
+	log.Println("Publishing Home Assistant discovery")
+
+	camera := b.newEntity("Camera", "camera")
+	camera.Topic = b.topic("snapshot")
+	b.publishEntity("camera", "camera", camera)
+
+	streaming := b.newEntity("Streaming", "streaming")
+	streaming.StateTopic = b.topic("streaming")
+	streaming.DeviceClass = "running"
+	streaming.PayloadOn = MqttStateOn
+	streaming.PayloadOff = MqttStateOff
+	b.publishEntity("binary_sensor", "streaming", streaming)
+
+	motion := b.newEntity("Motion", "motion")
+	motion.StateTopic = b.topic("motion")
+	motion.DeviceClass = "motion"
+	motion.PayloadOn = MqttStateOn
+	motion.PayloadOff = MqttStateOff
+	b.publishEntity("binary_sensor", "motion", motion)
+
+	sound := b.newEntity("Sound", "sound")
+	sound.StateTopic = b.topic("sound")
+	sound.DeviceClass = "sound"
+	sound.PayloadOn = MqttStateOn
+	sound.PayloadOff = MqttStateOff
+	b.publishEntity("binary_sensor", "sound", sound)
+
+	recording := b.newEntity("Recording", "recording")
+	recording.StateTopic = b.topic("recording")
+	recording.CommandTopic = b.topic("recording", "set")
+	recording.PayloadOn = MqttStateOn
+	recording.PayloadOff = MqttStateOff
+	recording.Icon = "mdi:record-rec"
+	b.publishEntity("switch", "recording", recording)
+
+	audio := b.newEntity("Audio", "audio")
+	audio.StateTopic = b.topic("audio")
+	audio.CommandTopic = b.topic("audio", "set")
+	audio.PayloadOn = MqttStateOn
+	audio.PayloadOff = MqttStateOff
+	audio.Icon = "mdi:microphone"
+	b.publishEntity("switch", "audio", audio)
+}
+
+func (b *MqttBridge) publishEntity(component, objectId string, entity HomeAssistantEntity) {
+	b.publishJSON(b.discoveryPrefix()+"/"+component+"/"+b.nodeId()+"/"+objectId+"/config", true, entity)
+}
+
+func (b *MqttBridge) onHomeAssistantStatus(client mqtt.Client, msg mqtt.Message) {
+	if string(msg.Payload()) == MqttStatusOnline {
+		b.publishDiscovery()
+	}
+}
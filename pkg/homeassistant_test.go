@@ -0,0 +1,77 @@
+/*
+ Copyright (c) 2024 Neeraj Jakhar
+
+ This program is free software: you can redistribute it and/or modify
+ it under the terms of the GNU General Public License as published by
+ the Free Software Foundation, either version 3 of the License, or
+ (at your option) any later version.
+
+ This program is distributed in the hope that it will be useful,
+ but WITHOUT ANY WARRANTY; without even the implied warranty of
+ MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License
+ along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+
+	"github.com/homebackend/go-webrtc/pkg/config"
+)
+
+func awaitEntity(t *testing.T, client *mqttTestClient, topic string) HomeAssistantEntity {
+	t.Helper()
+
+	var entity HomeAssistantEntity
+	if err := json.Unmarshal(client.await(t, topic), &entity); err != nil {
+		t.Fatal(err)
+	}
+
+	return entity
+}
+
+func TestHomeAssistantDiscovery(t *testing.T) {
+	configuration := &config.Configuration{
+		Name: "Front door",
+		Mqtt: &config.MqttConfiguration{ClientId: "front.door", HomeAssistant: true, DiscoveryPrefix: "ha"},
+	}
+	client, _, _ := startTestBridge(t, configuration, newTestSessions())
+
+	camera := awaitEntity(t, client, "ha/camera/front_door/camera/config")
+	if camera.UniqueId != "front_door_camera" || camera.Topic != "gowebrtc/snapshot" || camera.AvailabilityTopic != "gowebrtc/status" {
+		t.Errorf("Unexpected camera: %+v", camera)
+	}
+	if camera.Device.Name != "Front door" || !slices.Equal(camera.Device.Identifiers, []string{"front_door"}) {
+		t.Errorf("Unexpected device: %+v", camera.Device)
+	}
+
+	streaming := awaitEntity(t, client, "ha/binary_sensor/front_door/streaming/config")
+	if streaming.StateTopic != "gowebrtc/streaming" || streaming.DeviceClass != "running" || streaming.CommandTopic != "" {
+		t.Errorf("Unexpected streaming sensor: %+v", streaming)
+	}
+
+	motion := awaitEntity(t, client, "ha/binary_sensor/front_door/motion/config")
+	if motion.StateTopic != "gowebrtc/motion" || motion.DeviceClass != "motion" || motion.PayloadOn != MqttStateOn || motion.PayloadOff != MqttStateOff {
+		t.Errorf("Unexpected motion sensor: %+v", motion)
+	}
+
+	recording := awaitEntity(t, client, "ha/switch/front_door/recording/config")
+	if recording.StateTopic != "gowebrtc/recording" || recording.CommandTopic != "gowebrtc/recording/set" {
+		t.Errorf("Unexpected recording switch: %+v", recording)
+	}
+
+	audio := awaitEntity(t, client, "ha/switch/front_door/audio/config")
+	if audio.StateTopic != "gowebrtc/audio" || audio.CommandTopic != "gowebrtc/audio/set" {
+		t.Errorf("Unexpected audio switch: %+v", audio)
+	}
+
+	// Discovery is repeated when Home Assistant comes online
+	client.publish(t, "ha/status", MqttStatusOnline)
+	awaitEntity(t, client, "ha/camera/front_door/camera/config")
+}
@@ -18,76 +18,37 @@
 package main
 
 import (
-	"bufio"
 	"context"
-	"encoding/base64"
-	"encoding/json"
 	"fmt"
 	"log"
-	"net"
 	"net/http"
 	"os"
-	"os/exec"
-	"strconv"
-	"strings"
-	"syscall"
+	"sync/atomic"
 	"time"
 
 	"github.com/akamensky/argparse"
 	"github.com/gin-gonic/gin"
 	homecommon "github.com/homebackend/go-homebackend-common/pkg"
-	"github.com/pion/turn/v2"
-	"golang.org/x/sys/unix"
+	"github.com/homebackend/go-webrtc/pkg/config"
+	"github.com/homebackend/go-webrtc/pkg/media"
+	"github.com/homebackend/go-webrtc/pkg/session"
+	"github.com/homebackend/go-webrtc/pkg/signalling"
+	"github.com/homebackend/go-webrtc/pkg/turn"
 )
 
-const (
-	CONF_FILE = "/etc/gowebrtc/config.yaml"
-	ANSWER    = "Answer: "
-	CANDIDATE = "Candidate: "
-	EOF       = "::EOF::"
-)
-
-type StreamAnswerHandler func(string)
-
-type StreamCandidateHandler func(string)
-
-type StreamErrorHandler func(string)
-
-type Request struct {
-	SDP string `json:"sdp"`
-}
-
-type Response struct {
-	SDP string `json:"sdp"`
-}
-
-// Encode encodes the input in base64
-func encode(obj interface{}) string {
-	b, err := json.Marshal(obj)
-	if err != nil {
-		log.Fatalln(err)
-	}
-
-	return base64.StdEncoding.EncodeToString(b)
-}
-
-func decode(in string, obj interface{}) {
-	b, err := base64.StdEncoding.DecodeString(in)
-	if err != nil {
-		log.Fatalln(err)
-	}
-
-	err = json.Unmarshal(b, obj)
-	if err != nil {
-		log.Fatalln(err)
-	}
-}
+const CONF_FILE = "/etc/gowebrtc/config.yaml"
 
 func main() {
 	parser := argparse.NewParser(os.Args[0], "Setup Webrtc for video streaming from local camera")
 
 	serverCommand := parser.NewCommand("server", "Start webrtc service")
 	executeCommand := parser.NewCommand("execute", "Execute webrtc streaming")
+	recordCommand := parser.NewCommand("record", "Record audio and video to a file")
+	snapshotCommand := parser.NewCommand("snapshot", "Capture a single image to a file")
+	monitorCommand := parser.NewCommand("monitor", "Watch the capture devices for motion and sound")
+	devicesCommand := parser.NewCommand("devices", "List capture devices with configuration snippets")
+	checkCommand := parser.NewCommand("check", "Check the configuration and the pipelines built from it")
+	viewCommand := parser.NewCommand("view", "Receive a stream from a server to record, play or check it")
 
 	c := parser.String("c", "configuration-file", &argparse.Options{
 		Required: false,
@@ -115,53 +76,260 @@ func main() {
 		Help:     "File containing SDP data",
 	})
 
+	t := executeCommand.String("t", "overlay-text", &argparse.Options{
+		Required: false,
+		Help:     "Initial text of the text overlay",
+	})
+
+	m := executeCommand.String("m", "privacy-masks", &argparse.Options{
+		Required: false,
+		Help:     "Initial privacy masks as JSON",
+	})
+
+	ra := recordCommand.String("a", "audio-pipeline", &argparse.Options{
+		Required: true,
+		Help:     "GStreamer audio pipeline to use",
+	})
+
+	rv := recordCommand.String("v", "video-pipeline", &argparse.Options{
+		Required: true,
+		Help:     "GStreamer video pipeline to use",
+	})
+
+	ro := recordCommand.String("o", "output-file", &argparse.Options{
+		Required: true,
+		Help:     "File to record to",
+	})
+
+	rt := recordCommand.String("t", "overlay-text", &argparse.Options{
+		Required: false,
+		Help:     "Initial text of the text overlay",
+	})
+
+	rm := recordCommand.String("m", "privacy-masks", &argparse.Options{
+		Required: false,
+		Help:     "Initial privacy masks as JSON",
+	})
+
+	sv := snapshotCommand.String("v", "video-pipeline", &argparse.Options{
+		Required: true,
+		Help:     "GStreamer video pipeline to use",
+	})
+
+	so := snapshotCommand.String("o", "output-file", &argparse.Options{
+		Required: true,
+		Help:     "File to write the image to",
+	})
+
+	st := snapshotCommand.String("t", "overlay-text", &argparse.Options{
+		Required: false,
+		Help:     "Text of the text overlay",
+	})
+
+	sm := snapshotCommand.String("m", "privacy-masks", &argparse.Options{
+		Required: false,
+		Help:     "Privacy masks as JSON",
+	})
+
+	mv := monitorCommand.String("v", "video-pipeline", &argparse.Options{
+		Required: false,
+		Help:     "GStreamer video pipeline to watch for motion",
+	})
+
+	ma := monitorCommand.String("a", "audio-pipeline", &argparse.Options{
+		Required: false,
+		Help:     "GStreamer audio pipeline to watch for sound",
+	})
+
+	dj := devicesCommand.Flag("j", "json", &argparse.Options{
+		Default: false,
+		Help:    "Print the devices as JSON",
+	})
+
+	ts := serverCommand.Flag("", "test-source", &argparse.Options{
+		Default: false,
+		Help:    "Stream test video and audio instead of the configured sources",
+	})
+
+	cn := checkCommand.Flag("n", "no-devices", &argparse.Options{
+		Default: false,
+		Help:    "Do not open the capture devices, e.g. while the server is using them",
+	})
+
+	vu := viewCommand.String("u", "url", &argparse.Options{
+		Required: true,
+		Help:     "Signalling url of the server, ws:// or wss:// for websocket and http:// or https:// for http signalling",
+	})
+
+	vn := viewCommand.String("", "user", &argparse.Options{
+		Required: false,
+		Help:     "User to connect as",
+	})
+
+	vp := viewCommand.String("", "password", &argparse.Options{
+		Required: false,
+		Help:     "Password of the user",
+	})
+
+	vr := viewCommand.String("", "origin", &argparse.Options{
+		Required: false,
+		Help:     "Origin sent to websocket servers which check it",
+	})
+
+	vi := viewCommand.StringList("i", "ice-server", &argparse.Options{
+		Required: false,
+		Help:     "ICE server url, may be repeated. Defaults to " + DefaultViewIceServer,
+	})
+
+	vd := viewCommand.Int("d", "duration", &argparse.Options{
+		Default: 0,
+		Help:    "Seconds to receive the stream for, until interrupted if 0",
+	})
+
+	vt := viewCommand.Int("T", "timeout", &argparse.Options{
+		Default: DefaultViewTimeout,
+		Help:    "Seconds to wait for the stream to start",
+	})
+
+	vo := viewCommand.StringList("o", "output", &argparse.Options{
+		Required: false,
+		Help:     "File to write the stream to: video to .ivf, audio to .ogg or both to .webm. May be repeated",
+	})
+
+	vvs := viewCommand.String("", "video-sink", &argparse.Options{
+		Required: false,
+		Help:     "GStreamer sink to play the video with, e.g. autovideosink",
+	})
+
+	vas := viewCommand.String("", "audio-sink", &argparse.Options{
+		Required: false,
+		Help:     "GStreamer sink to play the audio with, e.g. autoaudiosink",
+	})
+
 	err := parser.Parse(os.Args)
 	if err != nil {
 		fmt.Print(parser.Usage(err))
 		os.Exit(1)
 	}
 
-	config := homecommon.GetConf[Configuration](*c)
+	// Devices are listed without a configuration, in order to write one
+	if devicesCommand.Happened() {
+		PrintDevices(*dj)
+		return
+	}
+
+	// The configuration is checked without stopping at the first problem
+	if checkCommand.Happened() {
+		RunCheck(*c, !*cn)
+		return
+	}
+
+	// Viewers connect to a server, which may run elsewhere
+	if viewCommand.Happened() {
+		RunView(&ViewOptions{
+			Url:        *vu,
+			User:       *vn,
+			Password:   *vp,
+			Origin:     *vr,
+			IceServers: *vi,
+			Duration:   time.Duration(*vd) * time.Second,
+			Timeout:    time.Duration(*vt) * time.Second,
+			Outputs:    *vo,
+			VideoSink:  *vvs,
+			AudioSink:  *vas,
+		})
+		return
+	}
+
+	config := homecommon.GetConf[config.Configuration](*c)
+	if err := media.CheckSources(config); err != nil {
+		log.Fatalln(err)
+	}
 
 	if serverCommand.Happened() {
+		if *ts {
+			log.Println("Using test sources")
+			media.UseTestSources(config)
+		}
+		settings := LoadSettings(config)
+		notifier := NewNotifier()
+		source := media.NewSource(config, settings)
+		monitor := NewMonitor(*c, config, source, notifier)
+		streamer := session.NewStreamer(*c, config, settings, source, monitor)
+		recorder := NewRecorder(*c, config, settings, source, notifier, monitor, streamer.Devices)
+		SetupWebhooks(config, notifier)
+		SetupMotionRecording(config, notifier, recorder)
+		monitor.Start()
 		if config.Signalling == "http" {
-			setupRouter(c, config)
-		} else if config.Signalling == "websocket" {
-			setupWebsocketServer(c, config)
+			setupRouter(config, settings, notifier, streamer, recorder)
+		} else if config.Signalling == "websocket" || config.Signalling == "sse" {
+			setupEventServer(config, settings, notifier, monitor, streamer, recorder)
 		}
 	} else if executeCommand.Happened() {
-		StartStreaming(config, *v, *a, *s, *w)
+		media.StartStreaming(config, *v, *a, *s, *t, media.ParsePrivacyMasks(config, *m), *w)
+	} else if recordCommand.Happened() {
+		media.RecordToFile(config, *rv, *ra, *ro, *rt, media.ParsePrivacyMasks(config, *rm))
+	} else if snapshotCommand.Happened() {
+		media.CaptureSnapshot(config, *sv, *so, *st, media.ParsePrivacyMasks(config, *sm))
+	} else if monitorCommand.Happened() {
+		media.MonitorDevices(config, *mv, *ma)
 	}
 }
 
-func setupCommon(config *Configuration) *os.File {
-	f := setupLogging(config.LogFile)
-
-	if config.UseInternalTurn {
-		if config.TurnConfiguration == nil {
-			log.Fatalln("Turn server is enabled but configuration not provided")
-		}
+func setupCommon(conf *config.Configuration) *os.File {
+	f := setupLogging(conf.LogFile)
 
-		if len(config.TurnConfiguration.Users) == 0 {
-			log.Fatalln("At least one user needs to be provided for server")
+	if conf.UseInternalTurn {
+		if err := turn.Check(conf.TurnConfiguration); err != nil {
+			log.Fatalln(err)
 		}
 
-		if config.TurnConfiguration.TurnType == TurnInternal {
-			go setupTurnServer(config)
+		if conf.TurnConfiguration.TurnType == config.TurnInternal {
+			// The server runs as long as the service
+			if _, err := turn.Start(conf.TurnConfiguration); err != nil {
+				log.Fatalln(err)
+			}
 		}
 	}
 
 	return f
 }
 
-func setupRouter(c *string, config *Configuration) {
+func setupMqtt(config *config.Configuration, settings *config.Settings, manager *Manager, notifier *Notifier, recorder *Recorder) {
+	if config.Mqtt == nil {
+		return
+	}
+
+	if config.Mqtt.Signalling && manager == nil {
+		log.Println("Signalling over MQTT requires websocket or sse signalling, it is disabled")
+	}
+
+	NewMqttBridge(config, settings, manager, notifier, recorder).Connect()
+}
+
+// notifyStreamingState turns session state changes into notifications
+func notifyStreamingState(notifier *Notifier, state, reason string) {
+	switch state {
+	case signalling.StateConnected:
+		notifier.Notify(NotificationStreamingStarted, nil)
+	case signalling.StateSessionEnded:
+		notifier.Notify(NotificationStreamingStopped, map[string]interface{}{"reason": reason})
+	case signalling.StatePipelineError:
+		notifier.Notify(NotificationPipelineFailure, map[string]interface{}{"reason": reason})
+	case signalling.StateDeviceLost:
+		notifier.Notify(NotificationDeviceLost, map[string]interface{}{"reason": reason})
+	case signalling.StateDeviceRecovered:
+		notifier.Notify(NotificationDeviceRecovered, nil)
+	}
+}
+
+func setupRouter(config *config.Configuration, settings *config.Settings, notifier *Notifier, sessions session.SessionManager, recorder *Recorder) {
 	f := setupCommon(config)
 	if f != nil {
 		defer f.Close()
 	}
 
-	streaming := false
-	pid := 0
+	setupMqtt(config, settings, nil, notifier, recorder)
 
 	var htmldir string
 	if _, err := os.Stat("./html"); err == nil {
@@ -174,76 +342,19 @@ func setupRouter(c *string, config *Configuration) {
 	gin.DefaultWriter = log.Writer()
 
 	router := gin.Default()
-	router.Static("/", htmldir)
-	router.POST(config.Url, createStream(*c, config, &streaming, &pid))
-	router.DELETE(config.Url, deleteStream(&streaming, &pid))
-	router.Run(fmt.Sprintf("0.0.0.0:%d", config.Port))
-}
-
-func setupTurnServer(config *Configuration) {
-	addr, err := net.ResolveUDPAddr("udp", "0.0.0.0:"+strconv.Itoa(config.TurnConfiguration.UdpPort))
-	if err != nil {
-		log.Fatalf("Failed to parse server address: %s", err)
-	}
-
-	usersMap := map[string][]byte{}
-	for _, userPassword := range config.TurnConfiguration.Users {
-		usersMap[userPassword.User] = turn.GenerateAuthKey(userPassword.User, config.TurnConfiguration.Realm, userPassword.Password)
-	}
-
-	listenerConfig := &net.ListenConfig{
-		Control: func(network, address string, conn syscall.RawConn) error {
-			var operr error
-			if err = conn.Control(func(fd uintptr) {
-				operr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, unix.SO_REUSEPORT, 1)
-			}); err != nil {
-				return err
-			}
-
-			return operr
-		},
-	}
-
-	relayAddressGenerator := &turn.RelayAddressGeneratorStatic{
-		RelayAddress: net.ParseIP(config.TurnConfiguration.PublicIp),
-		Address:      "0.0.0.0",
-	}
-
-	packetConnConfigs := make([]turn.PacketConnConfig, config.TurnConfiguration.Threads)
-	for i := 0; i < config.TurnConfiguration.Threads; i++ {
-		log.Printf("Network: %s, address: %s\n", addr.Network(), addr.String())
-		conn, listErr := listenerConfig.ListenPacket(context.Background(), addr.Network(), addr.String())
-		if listErr != nil {
-			log.Fatalf("Failed to allocate UDP listener at %s:%s", addr.Network(), addr.String())
-		}
-
-		packetConnConfigs[i] = turn.PacketConnConfig{
-			PacketConn:            conn,
-			RelayAddressGenerator: relayAddressGenerator,
-		}
-
-		log.Printf("Server %d listening on %s\n", i, conn.LocalAddr().String())
-	}
-
-	s, err := turn.NewServer(turn.ServerConfig{
-		Realm: config.TurnConfiguration.Realm,
-		AuthHandler: func(username string, realm string, srcAddr net.Addr) ([]byte, bool) { // nolint: revive
-			if key, ok := usersMap[username]; ok {
-				return key, true
-			}
-			return nil, false
-		},
-		PacketConnConfigs: packetConnConfigs,
-	})
-	if err != nil {
-		log.Panicf("Failed to create TURN server: %s", err)
+	// Static files are served for unknown routes so that they do not
+	// conflict with the api routes
+	router.NoRoute(gin.WrapH(http.FileServer(gin.Dir(htmldir, false))))
+	router.POST(config.Url, createStream(config, notifier, sessions))
+	router.DELETE(config.Url, deleteStream(config, sessions))
+	for _, route := range apiRoutes(config, settings, sessions, nil) {
+		router.Any(route.Path, gin.WrapF(route.Handler))
 	}
-
-	defer s.Close()
-	select {}
+	router.Run(fmt.Sprintf("0.0.0.0:%d", config.Port))
 }
 
-func setupWebsocketServer(c *string, config *Configuration) {
+// setupEventServer runs the signalling server for websocket and sse modes
+func setupEventServer(config *config.Configuration, settings *config.Settings, notifier *Notifier, monitor *Monitor, sessions session.SessionManager, recorder *Recorder) {
 	f := setupCommon(config)
 	if f != nil {
 		defer f.Close()
@@ -254,17 +365,26 @@ func setupWebsocketServer(c *string, config *Configuration) {
 
 	defer cancel()
 
-	manager := NewManager(ctx, *c, config)
+	manager := NewManager(ctx, config, notifier, monitor, sessions)
 	go manager.processConnection()
 
+	setupMqtt(config, settings, manager, notifier, recorder)
+
 	// Serve the ./frontend directory at Route /
 	http.HandleFunc("/", serveHome)
-	http.HandleFunc(config.Url, manager.serveWS)
+	if config.Signalling == "sse" {
+		http.HandleFunc(config.Url, manager.serveSSE)
+	} else {
+		http.HandleFunc(config.Url, manager.serveWS)
+	}
+	for _, route := range apiRoutes(config, settings, sessions, manager) {
+		http.HandleFunc(route.Path, route.Handler)
+	}
 
 	// Serve on port :8080, fudge yeah hardcoded port
 	var err error
 	addr := fmt.Sprintf("0.0.0.0:%d", config.Port)
-	log.Printf("WS Address: %s\n", addr)
+	log.Printf("%s Address: %s\n", config.Signalling, addr)
 	if config.SignallingUsesTls {
 		err = http.ListenAndServeTLS(addr, config.SignallingTlsCert, config.SignallingTlsKey, nil)
 	} else {
@@ -288,170 +408,99 @@ func serveHome(w http.ResponseWriter, r *http.Request) {
 	http.ServeFile(w, r, "home.html")
 }
 
-func killStream(pid *int) {
-	syscall.Kill(*pid, syscall.SIGINT)
-}
-
-func deleteStream(streaming *bool, pid *int) gin.HandlerFunc {
+// deleteStream ends the running session. Users who may not kick others only
+// end their own sessions.
+func deleteStream(conf *config.Configuration, sessions session.SessionManager) gin.HandlerFunc {
 	fn := func(c *gin.Context) {
-		if *streaming {
-			killStream(pid)
-			*streaming = false
+		account, ok := authenticate(conf, c.Request)
+		if !ok {
+			unauthorized(c.Writer)
+			return
 		}
 
-		c.Writer.WriteHeader(http.StatusNoContent)
-	}
-
-	return fn
-}
-
-func executeSelfAsExecutor(wait bool, configFile, videoSrc, audioSrc, sdpFileName string, pid chan int, answer chan string, candidate chan string, end chan bool) int {
-	var cmd *exec.Cmd
-	if wait {
-		cmd = exec.Command(os.Args[0], "execute", "-c", configFile, "-v", videoSrc, "-a", audioSrc, "-s", sdpFileName, "-w")
-	} else {
-		cmd = exec.Command(os.Args[0], "execute", "-c", configFile, "-v", videoSrc, "-a", audioSrc, "-s", sdpFileName)
-	}
-
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		log.Fatalln(err)
-	}
-	stderr, err := cmd.StderrPipe()
-	if err != nil {
-		log.Fatalln(err)
-	}
-
-	cmd.Start()
-	pid <- cmd.Process.Pid
-
-	go func() {
-		scanner := bufio.NewScanner(stdout)
-		for scanner.Scan() {
-			m := scanner.Text()
-			if strings.HasPrefix(m, ANSWER) {
-				answerText := m[len(ANSWER):]
-				log.Printf("Answer found to be %s", answerText)
-				answer <- answerText
-			} else if strings.HasPrefix(m, CANDIDATE) {
-				candidateText := m[len(CANDIDATE):]
-				log.Printf("Candidate found to be %s", candidateText)
-				candidate <- candidateText
-			} else if strings.HasPrefix(m, EOF) {
-				end <- true
-			} else {
-				log.Println(m)
+		// Viewers which were connected are reported as disconnected once
+		// their session ended
+		if account.Can(config.PermissionKick) {
+			sessions.Stop()
+		} else {
+			for _, s := range sessions.Sessions() {
+				if s.User == account.User {
+					sessions.StopSession(s.Id, "")
+				}
 			}
 		}
-	}()
-
-	go func() {
-		scanerr := bufio.NewScanner(stderr)
-		for scanerr.Scan() {
-			m := scanerr.Text()
-			log.Println(m)
-		}
-	}()
 
-	if err := cmd.Wait(); err != nil {
-		if exiterr, ok := err.(*exec.ExitError); ok {
-			return exiterr.ExitCode()
-		} else {
-			log.Fatalf("cmd.Wait: %v", err)
-		}
+		c.Writer.WriteHeader(http.StatusNoContent)
 	}
 
-	return 0
+	return fn
 }
 
-func HandleStreamingRequest(configFile string, config *Configuration, streaming *bool, child *int, sdp string,
-	answerHandler StreamAnswerHandler, candidateHandler StreamCandidateHandler, errorHandler StreamErrorHandler) {
-	if *streaming {
-		if !config.DisconnectOnReconnect {
-			errorHandler("Service unavailable as streaming is in progress")
+func createStream(conf *config.Configuration, notifier *Notifier, sessions session.SessionManager) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		account, ok := authenticate(conf, c.Request)
+		if !ok {
+			unauthorized(c.Writer)
 			return
 		}
-
-		killStream(child)
-		// Allow some time to let things settle down
-		time.Sleep(1 * time.Second)
-	}
-
-	*streaming = true
-
-	videoSrc := fmt.Sprintf("%s ! video/x-raw, width=%d, height=%d, framerate=%d/1 ! videoconvert ! queue", config.VideoDevice, config.ImageWidth, config.ImageHeight, config.FrameRate)
-	audioSrc := fmt.Sprintf("%s ! audioconvert ! queue", config.AudioDevice)
-
-	log.Println(videoSrc)
-	log.Println(audioSrc)
-
-	file, err := os.CreateTemp("/tmp", "gowebrtc")
-	if err != nil {
-		log.Println(err)
-		errorHandler("Unable to create sdp file")
-		return
-	}
-	sdpFileName := file.Name()
-	defer os.Remove(sdpFileName)
-
-	file.WriteString(sdp)
-	file.Close()
-
-	answer := make(chan string)
-	candidate := make(chan string)
-	end := make(chan bool)
-	pid := make(chan int)
-	failure := make(chan int)
-
-	go func() {
-		log.Println("About to execute streaming process")
-		code := executeSelfAsExecutor(config.IceTrickling, configFile, videoSrc, audioSrc, sdpFileName, pid, answer, candidate, end)
-		log.Printf("Child process with PID: %d exited with code: %d", *child, code)
-		*streaming = false
-		*child = 0
-		failure <- code
-	}()
-
-	for {
-		select {
-		case s := <-answer:
-			log.Println("Got result")
-			answerHandler(s)
-			log.Println("Sent response")
-		case c := <-candidate:
-			candidateHandler(c)
-		case <-end:
-			return
-		case code := <-failure:
-			log.Println("Got error while starting streaming")
-			errorHandler(fmt.Sprintf("Error while creating stream: %d", code))
+		if !account.CanView(c.Request.URL.Path) {
+			c.IndentedJSON(http.StatusForbidden, map[string]string{"message": signalling.ErrorForbidden.Error()})
 			return
-		case p := <-pid:
-			log.Printf("Started process with pid: %d", p)
-			*child = p
-			break
 		}
-	}
-}
 
-func createStream(configFile string, config *Configuration, streaming *bool, child *int) gin.HandlerFunc {
-	fn := func(c *gin.Context) {
-		var request Request
+		var request signalling.Request
 		if err := c.BindJSON(&request); err != nil {
 			log.Println(err)
 			return
 		}
 
-		HandleStreamingRequest(configFile, config, streaming, child, request.SDP, func(s string) {
-			var response Response
-			log.Println("Got result")
-			response.SDP = s
-			c.IndentedJSON(http.StatusOK, response)
-			log.Println("Sent response")
-		}, func(string) {
+		viewer := session.Viewer{
+			Id:            newClientId(),
+			RemoteAddress: c.ClientIP(),
+			Stream:        c.Request.URL.Path,
+			Preempt:       account.Can(config.PermissionPreempt),
+		}
+		if account != nil {
+			viewer.User = account.User
+			viewer.Priority = account.Priority
+			viewer.MaxDuration = time.Duration(account.MaxDuration) * time.Second
+		}
 
-		}, func(e string) {
-			c.IndentedJSON(http.StatusInternalServerError, map[string]string{"message": e})
+		// A queued viewer waits for the answer, its session is dropped if
+		// it gives up in the meantime
+		answered := make(chan struct{})
+		defer close(answered)
+		go func() {
+			select {
+			case <-c.Request.Context().Done():
+				sessions.StopSession(viewer.Id, "")
+			case <-answered:
+			}
+		}()
+
+		var streamed atomic.Bool
+		sessions.Stream(viewer, request.SDP, nil, session.Handlers{
+			Answer: func(s string) {
+				var response signalling.Response
+				log.Println("Got result")
+				response.SDP = s
+				c.IndentedJSON(http.StatusOK, response)
+				log.Println("Sent response")
+			},
+			State: func(state, reason string) {
+				// Only viewers which were connected are reported as
+				// disconnected
+				data := map[string]interface{}{"user": viewer.User, "remote_address": viewer.RemoteAddress}
+				if state == signalling.StateConnected && !streamed.Swap(true) {
+					notifier.Notify(NotificationViewerConnected, data)
+				} else if state == signalling.StateSessionEnded && streamed.Load() {
+					notifier.Notify(NotificationViewerDisconnected, data)
+				}
+				notifyStreamingState(notifier, state, reason)
+			},
+			Error: func(e string) {
+				c.IndentedJSON(http.StatusInternalServerError, map[string]string{"message": e})
+			},
 		})
 	}
 
@@ -19,23 +19,29 @@ package main
 
 import (
 	"context"
-	"errors"
+	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
 	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/homebackend/go-webrtc/pkg/config"
+	"github.com/homebackend/go-webrtc/pkg/session"
+	"github.com/homebackend/go-webrtc/pkg/signalling"
 )
 
 const (
 	readBufferSize  = 1024
 	writeBufferSize = 1024
+	// Maximum size of an event posted in sse signalling mode
+	postedEventLimit = 64 * 1024
 )
 
 var (
-	ErrAuthorizationNotDone = errors.New("not authorized")
-	ErrEventNotSupported    = errors.New("event type is not supported")
+	ErrAuthorizationNotDone = &signalling.ProtocolError{Code: signalling.ErrorCodeUnauthorized, Message: "not authorized"}
+	ErrEventNotSupported    = &signalling.ProtocolError{Code: signalling.ErrorCodeUnsupportedEvent, Message: "event type is not supported"}
 )
 
 type Manager struct {
@@ -43,65 +49,94 @@ type Manager struct {
 	sync.RWMutex
 	handlers          map[string]EventHandler
 	websocketUpgrader websocket.Upgrader
-	configFile        string
-	config            *Configuration
-	streaming         bool
-	pid               int
+	config            *config.Configuration
+	notifier          *Notifier
+	monitor           *Monitor
+	sessions          session.SessionManager
 	clientConnect     chan *Client
 }
 
-func NewManager(ctx context.Context, configFile string, config *Configuration) *Manager {
+func NewManager(ctx context.Context, config *config.Configuration, notifier *Notifier, monitor *Monitor, sessions session.SessionManager) *Manager {
 	m := &Manager{
 		clients:  make(ClientList),
 		handlers: make(map[string]EventHandler),
 		websocketUpgrader: websocket.Upgrader{
-			CheckOrigin: func(r *http.Request) bool {
-				if config.SignallingOrigin == "" {
-					return true
-				}
-
-				origin := r.Header.Get("Origin")
-
-				return origin == config.SignallingOrigin
-			},
+			CheckOrigin:     func(r *http.Request) bool { return checkOrigin(config, r) },
 			ReadBufferSize:  readBufferSize,
 			WriteBufferSize: writeBufferSize,
 		},
-		configFile:    configFile,
 		config:        config,
-		streaming:     false,
-		pid:           0,
+		notifier:      notifier,
+		monitor:       monitor,
+		sessions:      sessions,
 		clientConnect: make(chan *Client),
 	}
 	m.setupEventHandlers()
+	notifier.Subscribe(m.broadcastNotification)
 	return m
 }
 
+func checkOrigin(config *config.Configuration, r *http.Request) bool {
+	if config.SignallingOrigin == "" {
+		return true
+	}
+
+	origin := r.Header.Get("Origin")
+
+	return origin == config.SignallingOrigin
+}
+
 func (m *Manager) setupEventHandlers() {
-	m.handlers[EventDisconnect] = DisconnectHandler
+	m.handlers[signalling.EventDisconnect] = DisconnectHandler
+	m.handlers[signalling.EventCandidate] = RemoteCandidateHandler
+	m.handlers[signalling.EventStats] = StatsHandler
+	m.handlers[signalling.EventVisibility] = VisibilityHandler
+}
+
+// handleRequest routes the event to its handler and sends the handler's
+// reply, or an error event, back to the client with the request's id.
+func (m *Manager) handleRequest(event signalling.Event, c *Client) {
+	var reply *signalling.Event
+	var err error
+	if err = validate.Struct(event); err != nil {
+		// The id may be the invalid field, so it is not echoed back
+		event.Id = ""
+		err = &signalling.ProtocolError{Code: signalling.ErrorCodeMalformedMessage, Message: err.Error()}
+	} else {
+		reply, err = m.routeEvent(event, c)
+	}
+
+	if err != nil {
+		log.Println("Error processing event payload: ", err)
+		errorEvent := GetErrorEvent(err)
+		reply = &errorEvent
+	}
+
+	if reply != nil {
+		c.sendEvent(reply.ReplyTo(event))
+	}
+
+	// Streaming starts once the connect_ack is queued, so that it reaches
+	// the client before the answer
+	if reply != nil && reply.Type == signalling.EventConnectAck {
+		m.clientConnect <- c
+	}
 }
 
-func (m *Manager) routeEvent(event Event, c *Client) error {
+func (m *Manager) routeEvent(event signalling.Event, c *Client) (*signalling.Event, error) {
 	log.Printf("Event type to be routed: %s\n", event.Type)
 	if !c.authorized {
-		if event.Type == EventConnect {
-			if err := ConnectHandler(event, c); err != nil {
-				return err
-			} else {
-				return nil
-			}
+		if event.Type == signalling.EventConnect {
+			return ConnectHandler(event, c)
 		} else {
-			return ErrAuthorizationNotDone
+			return nil, ErrAuthorizationNotDone
 		}
 	}
 
 	if handler, ok := m.handlers[event.Type]; ok {
-		if err := handler(event, c); err != nil {
-			return err
-		}
-		return nil
+		return handler(event, c)
 	} else {
-		return ErrEventNotSupported
+		return nil, ErrEventNotSupported
 	}
 }
 
@@ -114,13 +149,125 @@ func (m *Manager) serveWS(w http.ResponseWriter, r *http.Request) {
 	}
 	log.Println("Connection upgrade done")
 
-	client := NewClient(conn, m)
+	client := NewClient(conn, r.RemoteAddr, m)
 	m.addClient(client)
 
 	go client.readMessages()
 	go client.writeMessages()
 }
 
+// serveSSE handles sse signalling. A GET request opens the event stream for a
+// new session, the first event sent on it carries the session id. Client
+// events are POSTed with the session id in the session query parameter.
+func (m *Manager) serveSSE(w http.ResponseWriter, r *http.Request) {
+	if !checkOrigin(m.config, r) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	if m.config.SignallingOrigin != "" {
+		w.Header().Set("Access-Control-Allow-Origin", m.config.SignallingOrigin)
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		m.serveEventStream(w, r)
+	case http.MethodPost:
+		m.servePostedEvent(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (m *Manager) serveEventStream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	log.Println("New event stream")
+	client := NewClient(nil, r.RemoteAddr, m)
+	m.addClient(client)
+
+	ticker := time.NewTicker(pingInterval)
+	defer func() {
+		log.Println("Exiting event stream")
+		ticker.Stop()
+		m.removeClient(client)
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	if err := writeServerSentEvent(w, GetSessionEvent(client.id)); err != nil {
+		log.Println(err)
+		return
+	}
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-client.done:
+			return
+		case event := <-client.egress:
+			if err := writeServerSentEvent(w, event); err != nil {
+				log.Println(err)
+				return
+			}
+			flusher.Flush()
+		case <-ticker.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				log.Println(err)
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+func writeServerSentEvent(w http.ResponseWriter, event signalling.Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "data: %s\n\n", data)
+	return err
+}
+
+func (m *Manager) servePostedEvent(w http.ResponseWriter, r *http.Request) {
+	client := m.getClient(r.URL.Query().Get("session"))
+	if client == nil {
+		writeErrorResponse(w, http.StatusNotFound, &signalling.ProtocolError{Code: signalling.ErrorCodeUnknownSession, Message: "unknown session"})
+		return
+	}
+
+	var request signalling.Event
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, postedEventLimit)).Decode(&request); err != nil {
+		log.Printf("Error processing event: %v", err)
+		writeErrorResponse(w, http.StatusBadRequest, &signalling.ProtocolError{Code: signalling.ErrorCodeMalformedMessage, Message: err.Error()})
+		return
+	}
+
+	// Handled before responding so that events posted one after another are
+	// processed in order. Replies are delivered on the event stream.
+	m.handleRequest(request, client)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func writeErrorResponse(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(GetErrorEvent(err)); err != nil {
+		log.Println(err)
+	}
+}
+
 func (m *Manager) processConnection() {
 	ticker := time.NewTicker(pingInterval)
 	defer func() {
@@ -132,19 +279,58 @@ func (m *Manager) processConnection() {
 		select {
 		case c := <-m.clientConnect:
 			log.Println("Handling streaming request")
-			HandleStreamingRequest(m.configFile, m.config, &m.streaming, &m.pid, c.sdp,
-				func(answer string) {
+			// Starting the streaming process takes a while, other clients
+			// are handled in the meantime
+			viewer := session.Viewer{
+				Id:            c.id,
+				User:          c.user,
+				RemoteAddress: c.remoteAddr,
+				Stream:        m.config.Url,
+				Preempt:       c.account.Can(config.PermissionPreempt),
+			}
+			if c.account != nil {
+				viewer.Priority = c.account.Priority
+				viewer.MaxDuration = time.Duration(c.account.MaxDuration) * time.Second
+			}
+			go m.sessions.Stream(viewer, c.sdp, c.candidates, session.Handlers{
+				Answer: func(answer string) {
 					log.Printf("Answer: %s\n", answer)
-					c.egress <- GetAnswerEvent(answer)
-				}, func(candidate string) {
+					c.sendReply(GetAnswerEvent(answer))
+				},
+				Candidate: func(candidate string) {
 					log.Printf("Candidate: %s\n", candidate)
-					c.egress <- GetNewCandidateEvent(candidate)
-				}, func(error string) {
+					c.sendReply(GetNewCandidateEvent(candidate))
+				},
+				State: func(state, reason string) {
+					log.Printf("State: %s (%s)\n", state, reason)
+					// Viewers only count as connected once their stream
+					// runs, not when they are queued or refused
+					if state == signalling.StateConnected && !c.streamed.Swap(true) {
+						m.notifier.Notify(NotificationViewerConnected, c.notificationData())
+					}
+					notifyStreamingState(m.notifier, state, reason)
+					c.sendEvent(GetStateEvent(state, reason))
+				},
+				Error: func(error string) {
 					log.Printf("Error: %s\n", error)
+					c.sendEvent(GetStateEvent(signalling.StateFailed, error))
 					m.removeClient(c)
-				})
+				},
+				Queue: func(position int) {
+					log.Printf("Queued at position %d\n", position)
+					c.sendEvent(GetQueueEvent(position))
+				},
+				Kick: func(reason string) {
+					log.Printf("Kicked: %s\n", reason)
+					c.sendEvent(GetKickEvent(reason))
+				},
+				Warning: func(reason string, remaining time.Duration) {
+					log.Printf("Warning: %s in %v\n", reason, remaining)
+					c.sendEvent(GetWarningEvent(reason, remaining))
+				},
+			})
 		case <-ticker.C:
-			for c := range m.clients {
+			for _, c := range m.unauthorizedClients() {
 				if c.hasAuthTimedOut() {
 					m.removeClient(c)
 				}
@@ -153,6 +339,60 @@ func (m *Manager) processConnection() {
 	}
 }
 
+// broadcastNotification tells all authorized clients about motion and
+// sound changes
+func (m *Manager) broadcastNotification(notification Notification) {
+	var event signalling.Event
+	switch notification.Type {
+	case NotificationMotionStarted:
+		event = GetMotionEvent(true)
+	case NotificationMotionStopped:
+		event = GetMotionEvent(false)
+	case NotificationSoundStarted:
+		event = GetSoundEvent(true)
+	case NotificationSoundStopped:
+		event = GetSoundEvent(false)
+	default:
+		return
+	}
+
+	m.RLock()
+	defer m.RUnlock()
+
+	for c := range m.clients {
+		if c.authorized {
+			// Sending blocks until the client writer picks the event up
+			go c.sendEvent(event)
+		}
+	}
+}
+
+// unauthorizedClients returns the clients which have not connected yet
+func (m *Manager) unauthorizedClients() []*Client {
+	m.RLock()
+	defer m.RUnlock()
+
+	var clients []*Client
+	for c := range m.clients {
+		if !c.authorized {
+			clients = append(clients, c)
+		}
+	}
+
+	return clients
+}
+
+// identify records who the client connected as. Clients are read while
+// holding the lock, such as when notifications are broadcast.
+func (m *Manager) identify(client *Client, user string, account *config.SignallingUser, authorized bool) {
+	m.Lock()
+	defer m.Unlock()
+
+	client.user = user
+	client.account = account
+	client.authorized = authorized
+}
+
 func (m *Manager) addClient(client *Client) {
 	m.Lock()
 	defer m.Unlock()
@@ -162,10 +402,40 @@ func (m *Manager) addClient(client *Client) {
 
 func (m *Manager) removeClient(client *Client) {
 	m.Lock()
-	defer m.Unlock()
-
-	if _, ok := m.clients[client]; ok {
-		client.connection.Close()
+	_, ok := m.clients[client]
+	if ok {
+		if client.connection != nil {
+			client.connection.Close()
+		}
+		close(client.done)
 		delete(m.clients, client)
 	}
+	authorized := ok && client.authorized
+	streamed := ok && client.streamed.Load()
+	data := client.notificationData()
+	m.Unlock()
+
+	// The session and the notifier call back into the manager, which must
+	// not be locked meanwhile
+	if authorized {
+		// The viewer is gone, its session does not need the devices
+		m.sessions.StopSession(client.id, "")
+	}
+	// Only viewers which were connected are reported as disconnected
+	if streamed {
+		m.notifier.Notify(NotificationViewerDisconnected, data)
+	}
+}
+
+func (m *Manager) getClient(id string) *Client {
+	m.RLock()
+	defer m.RUnlock()
+
+	for client := range m.clients {
+		if client.id == id {
+			return client
+		}
+	}
+
+	return nil
 }
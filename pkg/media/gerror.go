@@ -0,0 +1,53 @@
+/*
+ Copyright (c) 2024 Neeraj Jakhar
+
+ This program is free software: you can redistribute it and/or modify
+ it under the terms of the GNU General Public License as published by
+ the Free Software Foundation, either version 3 of the License, or
+ (at your option) any later version.
+
+ This program is distributed in the hope that it will be useful,
+ but WITHOUT ANY WARRANTY; without even the implied warranty of
+ MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License
+ along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package media
+
+// #cgo pkg-config: gstreamer-1.0
+// #include <gst/gst.h>
+import "C"
+
+import (
+	"unsafe"
+
+	"github.com/go-gst/go-gst/gst"
+)
+
+// errorCode returns the domain and code of the error posted with an error
+// message. The errors parsed by go-gst do not carry them.
+func errorCode(msg *gst.Message) (gst.Domain, gst.ErrorCode) {
+	var gerr *C.GError
+	C.gst_message_parse_error((*C.GstMessage)(unsafe.Pointer(msg.Instance())), &gerr, nil)
+	if gerr == nil {
+		return "", 0
+	}
+	defer C.g_error_free(gerr)
+
+	var domain gst.Domain
+	switch gerr.domain {
+	case C.gst_core_error_quark():
+		domain = gst.DomainCore
+	case C.gst_library_error_quark():
+		domain = gst.DomainLibrary
+	case C.gst_resource_error_quark():
+		domain = gst.DomainResource
+	case C.gst_stream_error_quark():
+		domain = gst.DomainStream
+	}
+
+	return domain, gst.ErrorCode(gerr.code)
+}
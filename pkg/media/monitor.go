@@ -0,0 +1,69 @@
+/*
+ Copyright (c) 2024 Neeraj Jakhar
+
+ This program is free software: you can redistribute it and/or modify
+ it under the terms of the GNU General Public License as published by
+ the Free Software Foundation, either version 3 of the License, or
+ (at your option) any later version.
+
+ This program is distributed in the hope that it will be useful,
+ but WITHOUT ANY WARRANTY; without even the implied warranty of
+ MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License
+ along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package media
+
+import (
+	"log"
+	"strings"
+
+	"github.com/go-gst/go-gst/gst"
+	"github.com/homebackend/go-webrtc/pkg/config"
+)
+
+// MonitorDevices runs in the monitor child process, which watches the
+// capture devices while they are not used for streaming or recording. Either
+// of the sources may be empty.
+func MonitorDevices(conf *config.Configuration, videoSrc, audioSrc string) {
+	var branches []string
+	if videoSrc != "" && conf.Motion != nil {
+		branches = append(branches, videoSrc+" ! "+motionBranch())
+	}
+	if audioSrc != "" && conf.Sound != nil {
+		branches = append(branches, audioSrc+" ! "+soundBranch())
+	}
+	if len(branches) == 0 {
+		log.Fatalln("Neither motion nor sound detection is configured")
+	}
+
+	gst.Init(nil)
+
+	pipelineStr := strings.Join(branches, " ")
+	log.Println(pipelineStr)
+
+	pipeline, err := gst.NewPipelineFromString(pipelineStr)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	if videoSrc != "" && conf.Motion != nil {
+		detectMotion(conf, pipeline)
+	}
+	if audioSrc != "" && conf.Sound != nil {
+		measureLevel(pipeline)
+	}
+
+	if err = pipeline.SetState(gst.StatePlaying); err != nil {
+		log.Fatalln(err)
+	}
+
+	err = waitForEOS(pipeline)
+	pipeline.SetState(gst.StateNull)
+	if err != nil {
+		log.Fatalln(err)
+	}
+}
@@ -0,0 +1,182 @@
+/*
+ Copyright (c) 2024 Neeraj Jakhar
+
+ This program is free software: you can redistribute it and/or modify
+ it under the terms of the GNU General Public License as published by
+ the Free Software Foundation, either version 3 of the License, or
+ (at your option) any later version.
+
+ This program is distributed in the hope that it will be useful,
+ but WITHOUT ANY WARRANTY; without even the implied warranty of
+ MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License
+ along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package media
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/go-gst/go-gst/gst"
+	"github.com/go-gst/go-gst/gst/app"
+	"github.com/homebackend/go-webrtc/pkg/config"
+	"github.com/homebackend/go-webrtc/pkg/session"
+)
+
+const DefaultMotionSensitivity = 50
+
+const (
+	motionFrameWidth     = 160
+	motionFrameHeight    = 120
+	motionFrameRate      = 5
+	motionReportInterval = time.Second
+)
+
+// MotionDetector compares consecutive low resolution grayscale frames. A
+// frame has motion if enough of the watched pixels changed noticeably.
+type MotionDetector struct {
+	pixelThreshold int
+	areaThreshold  float64
+	mask           []bool
+	watched        int
+	previous       []byte
+}
+
+func NewMotionDetector(conf *config.MotionConfiguration, width, height int) *MotionDetector {
+	sensitivity := uint(DefaultMotionSensitivity)
+	if conf.Sensitivity != nil {
+		sensitivity = *conf.Sensitivity
+	}
+
+	// Higher sensitivity means smaller changes over a smaller area count
+	insensitivity := float64(100 - sensitivity)
+	d := &MotionDetector{
+		pixelThreshold: 10 + int(insensitivity*0.4),
+		areaThreshold:  0.002 + insensitivity*0.001,
+		mask:           make([]bool, width*height),
+	}
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			fx := (float64(x) + 0.5) / float64(width)
+			fy := (float64(y) + 0.5) / float64(height)
+			watched := len(conf.Regions) == 0 || containsPoint(conf.Regions, fx, fy)
+			if watched && !containsPoint(conf.Masks, fx, fy) {
+				d.mask[y*width+x] = true
+				d.watched++
+			}
+		}
+	}
+
+	return d
+}
+
+func containsPoint(regions []config.MotionRegion, x, y float64) bool {
+	for _, r := range regions {
+		if x >= r.X && x < r.X+r.Width && y >= r.Y && y < r.Y+r.Height {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Detect returns the fraction of watched pixels which changed since the
+// previous frame and whether that is considered motion
+func (d *MotionDetector) Detect(frame []byte) (float64, bool) {
+	if len(frame) < len(d.mask) || d.watched == 0 {
+		return 0, false
+	}
+
+	previous := d.previous
+	d.previous = append(d.previous[:0:0], frame[:len(d.mask)]...)
+	if previous == nil {
+		return 0, false
+	}
+
+	changed := 0
+	for i, watched := range d.mask {
+		if !watched {
+			continue
+		}
+
+		diff := int(frame[i]) - int(previous[i])
+		if diff < 0 {
+			diff = -diff
+		}
+		if diff > d.pixelThreshold {
+			changed++
+		}
+	}
+
+	score := float64(changed) / float64(d.watched)
+	return score, score >= d.areaThreshold
+}
+
+// motionBranch returns the part of a pipeline which scales the video down
+// for the motion detector
+func motionBranch() string {
+	return fmt.Sprintf("videoscale ! videorate ! videoconvert ! video/x-raw, format=GRAY8, width=%d, height=%d, framerate=%d/1 ! appsink name=motion max-buffers=1 drop=true sync=false",
+		motionFrameWidth, motionFrameHeight, motionFrameRate)
+}
+
+// withMotionDetection tees the video source into the motion detector if
+// motion detection is enabled. The returned branch has to be appended to the
+// pipeline.
+func withMotionDetection(conf *config.Configuration, videoSrc string) (string, string) {
+	if conf.Motion == nil {
+		return videoSrc, ""
+	}
+
+	return videoSrc + " ! tee name=motiontee ! queue", " motiontee. ! queue leaky=downstream max-size-buffers=2 ! " + motionBranch()
+}
+
+func printMotion(score float64) {
+	if s, err := json.Marshal(session.MotionReport{Score: score}); err == nil {
+		fmt.Println(session.MOTION + string(s))
+	} else {
+		log.Println(err)
+	}
+}
+
+// detectMotion runs the motion detector on the frames of the motion appsink
+// and reports motion to the parent process
+func detectMotion(conf *config.Configuration, pipeline *gst.Pipeline) {
+	sink, err := pipeline.GetElementByName("motion")
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	detector := NewMotionDetector(conf.Motion, motionFrameWidth, motionFrameHeight)
+	var lastReport time.Time
+
+	app.SinkFromElement(sink).SetCallbacks(&app.SinkCallbacks{
+		NewSampleFunc: func(sink *app.Sink) gst.FlowReturn {
+			sample := sink.PullSample()
+			if sample == nil {
+				return gst.FlowEOS
+			}
+
+			buffer := sample.GetBuffer()
+			if buffer == nil {
+				return gst.FlowError
+			}
+
+			frame := buffer.Map(gst.MapRead).Bytes()
+			defer buffer.Unmap()
+
+			if score, motion := detector.Detect(frame); motion && time.Since(lastReport) >= motionReportInterval {
+				lastReport = time.Now()
+				printMotion(score)
+			}
+
+			return gst.FlowOK
+		},
+	})
+}
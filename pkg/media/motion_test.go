@@ -0,0 +1,96 @@
+/*
+ Copyright (c) 2024 Neeraj Jakhar
+
+ This program is free software: you can redistribute it and/or modify
+ it under the terms of the GNU General Public License as published by
+ the Free Software Foundation, either version 3 of the License, or
+ (at your option) any later version.
+
+ This program is distributed in the hope that it will be useful,
+ but WITHOUT ANY WARRANTY; without even the implied warranty of
+ MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License
+ along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package media
+
+import (
+	"testing"
+
+	"github.com/homebackend/go-webrtc/pkg/config"
+)
+
+// frame returns a frame with the given brightness, the block of pixels
+// starting at x and y is brightened further
+func frame(width, height, x, y, size int, brightness byte) []byte {
+	f := make([]byte, width*height)
+	for i := range f {
+		f[i] = brightness
+	}
+
+	for row := y; row < y+size && row < height; row++ {
+		for col := x; col < x+size && col < width; col++ {
+			f[row*width+col] = brightness + 100
+		}
+	}
+
+	return f
+}
+
+func TestMotionDetector(t *testing.T) {
+	detector := NewMotionDetector(&config.MotionConfiguration{}, 16, 16)
+
+	if _, motion := detector.Detect(frame(16, 16, 0, 0, 0, 50)); motion {
+		t.Error("motion in the first frame")
+	}
+	if _, motion := detector.Detect(frame(16, 16, 0, 0, 0, 50)); motion {
+		t.Error("motion in an unchanged frame")
+	}
+	if score, motion := detector.Detect(frame(16, 16, 4, 4, 4, 50)); !motion || score != 16.0/256 {
+		t.Errorf("no motion in a changed frame: %f", score)
+	}
+}
+
+func TestMotionDetectorSensitivity(t *testing.T) {
+	for _, test := range []struct {
+		sensitivity *uint
+		motion      bool
+	}{
+		{nil, true},
+		{new(uint), false},
+	} {
+		detector := NewMotionDetector(&config.MotionConfiguration{Sensitivity: test.sensitivity}, 16, 16)
+		detector.Detect(frame(16, 16, 0, 0, 0, 50))
+		if _, motion := detector.Detect(frame(16, 16, 4, 4, 4, 50)); motion != test.motion {
+			t.Errorf("sensitivity %v: expected motion %t", test.sensitivity, test.motion)
+		}
+	}
+}
+
+func TestMotionDetectorMasks(t *testing.T) {
+	detector := NewMotionDetector(&config.MotionConfiguration{
+		Masks: []config.MotionRegion{{X: 0, Y: 0, Width: 0.5, Height: 0.5}},
+	}, 16, 16)
+
+	detector.Detect(frame(16, 16, 0, 0, 0, 50))
+	if _, motion := detector.Detect(frame(16, 16, 0, 0, 8, 50)); motion {
+		t.Error("motion in a masked region")
+	}
+	if _, motion := detector.Detect(frame(16, 16, 8, 8, 8, 50)); !motion {
+		t.Error("no motion outside of the masked region")
+	}
+}
+
+func TestRmsLevel(t *testing.T) {
+	if level := rmsLevel(0, 100); level != minimumLevel {
+		t.Errorf("silence is not the minimum level: %f", level)
+	}
+
+	// A full scale square wave
+	if level := rmsLevel(32768*32768*100, 100); level != 0 {
+		t.Errorf("full scale is not 0 dBFS: %f", level)
+	}
+}
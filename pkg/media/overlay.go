@@ -0,0 +1,139 @@
+/*
+ Copyright (c) 2024 Neeraj Jakhar
+
+ This program is free software: you can redistribute it and/or modify
+ it under the terms of the GNU General Public License as published by
+ the Free Software Foundation, either version 3 of the License, or
+ (at your option) any later version.
+
+ This program is distributed in the hope that it will be useful,
+ but WITHOUT ANY WARRANTY; without even the implied warranty of
+ MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License
+ along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package media
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/go-gst/go-gst/gst"
+	"github.com/homebackend/go-webrtc/pkg/config"
+	"github.com/homebackend/go-webrtc/pkg/session"
+)
+
+const (
+	DefaultTimestampFormat   = "%Y-%m-%d %H:%M:%S"
+	DefaultTimestampPosition = "top-left"
+	DefaultNamePosition      = "bottom-left"
+	DefaultTextPosition      = "bottom-right"
+	DefaultOverlayFont       = "Sans, 12"
+)
+
+// Quote quotes a property value for a pipeline description
+func Quote(value string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value) + `"`
+}
+
+func orDefault(value, defaultValue string) string {
+	if value == "" {
+		return defaultValue
+	}
+
+	return value
+}
+
+// overlayAlignment returns the alignment properties of an overlay element
+// for a position such as top-left
+func overlayAlignment(position string) string {
+	vertical, horizontal, _ := strings.Cut(position, "-")
+	return fmt.Sprintf("valignment=%s halignment=%s", vertical, horizontal)
+}
+
+// WithOverlay appends the configured overlays to the video source. The text
+// overlay is always added so that the text can be set at runtime.
+func WithOverlay(conf *config.Configuration, videoSrc, text string) string {
+	if conf.Overlay == nil {
+		return videoSrc
+	}
+
+	font := "font-desc=" + Quote(orDefault(conf.Overlay.Font, DefaultOverlayFont)) + " shaded-background=true"
+	if conf.Overlay.Timestamp {
+		videoSrc += fmt.Sprintf(" ! clockoverlay time-format=%s %s %s",
+			Quote(orDefault(conf.Overlay.TimestampFormat, DefaultTimestampFormat)),
+			overlayAlignment(orDefault(conf.Overlay.TimestampPosition, DefaultTimestampPosition)), font)
+	}
+
+	if conf.Overlay.Name {
+		videoSrc += fmt.Sprintf(" ! textoverlay name=nameoverlay text=%s %s %s",
+			Quote(orDefault(conf.Name, "gowebrtc")),
+			overlayAlignment(orDefault(conf.Overlay.NamePosition, DefaultNamePosition)), font)
+	}
+
+	return videoSrc + fmt.Sprintf(" ! textoverlay name=textoverlay text=%s %s %s",
+		Quote(text), overlayAlignment(orDefault(conf.Overlay.TextPosition, DefaultTextPosition)), font)
+}
+
+// TextOverlay updates the text overlay of a capture process. Text set before
+// the pipeline is attached is applied once it is.
+type TextOverlay struct {
+	sync.Mutex
+	element *gst.Element
+	text    *string
+}
+
+func (o *TextOverlay) Attach(pipeline *gst.Pipeline) {
+	element, err := pipeline.GetElementByName("textoverlay")
+	if err != nil {
+		// Overlays are not configured
+		return
+	}
+
+	o.Lock()
+	defer o.Unlock()
+
+	o.element = element
+	if o.text != nil {
+		o.apply(*o.text)
+	}
+}
+
+func (o *TextOverlay) SetText(text string) {
+	o.Lock()
+	defer o.Unlock()
+
+	o.text = &text
+	if o.element != nil {
+		o.apply(text)
+	}
+}
+
+func (o *TextOverlay) apply(text string) {
+	if err := o.element.SetProperty("text", text); err != nil {
+		log.Println(err)
+	}
+}
+
+// HandleLine handles a line written to stdin by the parent process if it
+// is an overlay update and returns whether it was one
+func (o *TextOverlay) HandleLine(line string) bool {
+	if !strings.HasPrefix(line, session.OVERLAY) {
+		return false
+	}
+
+	var text string
+	if err := json.Unmarshal([]byte(line[len(session.OVERLAY):]), &text); err != nil {
+		log.Printf("Invalid overlay text from parent: %v", err)
+	} else {
+		o.SetText(text)
+	}
+
+	return true
+}
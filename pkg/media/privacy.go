@@ -0,0 +1,301 @@
+/*
+ Copyright (c) 2024 Neeraj Jakhar
+
+ This program is free software: you can redistribute it and/or modify
+ it under the terms of the GNU General Public License as published by
+ the Free Software Foundation, either version 3 of the License, or
+ (at your option) any later version.
+
+ This program is distributed in the hope that it will be useful,
+ but WITHOUT ANY WARRANTY; without even the implied warranty of
+ MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License
+ along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package media
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/go-gst/go-gst/gst"
+	"github.com/go-gst/go-gst/gst/app"
+	"github.com/homebackend/go-webrtc/pkg/config"
+	"github.com/homebackend/go-webrtc/pkg/session"
+)
+
+// Black in YUV
+const (
+	maskLuma   = 16
+	maskChroma = 128
+)
+
+// maskPoints returns the corners of the mask in relative coordinates
+func maskPoints(m config.PrivacyMask) []config.PrivacyPoint {
+	if len(m.Points) > 0 {
+		return m.Points
+	}
+
+	return []config.PrivacyPoint{
+		{X: m.X, Y: m.Y},
+		{X: m.X + m.Width, Y: m.Y},
+		{X: m.X + m.Width, Y: m.Y + m.Height},
+		{X: m.X, Y: m.Y + m.Height},
+	}
+}
+
+// span is a range of pixels of a row, end is exclusive
+type span struct {
+	start, end int
+}
+
+// maskSpans rasterizes the masks for an image of the given size. Pixels
+// touched by a mask are included, so that nothing of the masked area leaks.
+func maskSpans(masks []config.PrivacyMask, width, height int) [][]span {
+	rows := make([][]span, height)
+	for y := 0; y < height; y++ {
+		cy := (float64(y) + 0.5) / float64(height)
+		for _, mask := range masks {
+			points := maskPoints(mask)
+
+			var xs []float64
+			for i := range points {
+				a, b := points[i], points[(i+1)%len(points)]
+				if (a.Y <= cy && cy < b.Y) || (b.Y <= cy && cy < a.Y) {
+					xs = append(xs, a.X+(cy-a.Y)*(b.X-a.X)/(b.Y-a.Y))
+				}
+			}
+			sort.Float64s(xs)
+
+			for i := 0; i+1 < len(xs); i += 2 {
+				start := int(math.Max(math.Floor(xs[i]*float64(width)), 0))
+				end := int(math.Min(math.Ceil(xs[i+1]*float64(width)), float64(width)))
+				if start < end {
+					rows[y] = append(rows[y], span{start: start, end: end})
+				}
+			}
+		}
+	}
+
+	return rows
+}
+
+func roundUp2(n int) int {
+	return (n + 1) &^ 1
+}
+
+func roundUp4(n int) int {
+	return (n + 3) &^ 3
+}
+
+// PrivacyPainter paints the privacy masks black onto I420 frames. The masks
+// can be changed while frames are painted.
+type PrivacyPainter struct {
+	sync.Mutex
+	masks  []config.PrivacyMask
+	width  int
+	height int
+	luma   [][]span
+	chroma [][]span
+}
+
+func NewPrivacyPainter(masks []config.PrivacyMask) *PrivacyPainter {
+	return &PrivacyPainter{masks: masks}
+}
+
+func (p *PrivacyPainter) SetMasks(masks []config.PrivacyMask) {
+	p.Lock()
+	defer p.Unlock()
+
+	p.masks = masks
+	// Spans are computed again with the next frame
+	p.width = 0
+}
+
+// Paint paints the masks onto a frame using the default I420 layout
+func (p *PrivacyPainter) Paint(frame []byte, width, height int) error {
+	p.Lock()
+	defer p.Unlock()
+
+	chromaWidth, chromaHeight := (width+1)/2, (height+1)/2
+	lumaStride, chromaStride := roundUp4(width), roundUp4(chromaWidth)
+	// Like the planes, the luma plane has an even number of rows
+	uOffset := lumaStride * roundUp2(height)
+	vOffset := uOffset + chromaStride*chromaHeight
+	if len(frame) < vOffset+chromaStride*chromaHeight {
+		return fmt.Errorf("frame of %d bytes is too small for %dx%d", len(frame), width, height)
+	}
+
+	if p.width != width || p.height != height {
+		p.width, p.height = width, height
+		p.luma = maskSpans(p.masks, width, height)
+		p.chroma = maskSpans(p.masks, chromaWidth, chromaHeight)
+	}
+
+	for y, spans := range p.luma {
+		for _, s := range spans {
+			fill(frame[y*lumaStride+s.start:y*lumaStride+s.end], maskLuma)
+		}
+	}
+
+	for y, spans := range p.chroma {
+		for _, s := range spans {
+			fill(frame[uOffset+y*chromaStride+s.start:uOffset+y*chromaStride+s.end], maskChroma)
+			fill(frame[vOffset+y*chromaStride+s.start:vOffset+y*chromaStride+s.end], maskChroma)
+		}
+	}
+
+	return nil
+}
+
+func fill(b []byte, value byte) {
+	for i := range b {
+		b[i] = value
+	}
+}
+
+// HandleLine handles a line written to stdin by the parent process if it
+// is a privacy mask update and returns whether it was one
+func (p *PrivacyPainter) HandleLine(line string) bool {
+	if !strings.HasPrefix(line, session.MASKS) {
+		return false
+	}
+
+	var masks []config.PrivacyMask
+	if err := json.Unmarshal([]byte(line[len(session.MASKS):]), &masks); err != nil {
+		log.Printf("Invalid privacy masks from parent: %v", err)
+	} else {
+		p.SetMasks(masks)
+	}
+
+	return true
+}
+
+// WithPrivacyMasks routes the video through the privacy mask painter, ahead
+// of every consumer of the video
+func WithPrivacyMasks(conf *config.Configuration, videoSrc string) string {
+	if conf.Privacy == nil {
+		return videoSrc
+	}
+
+	return videoSrc + " ! videoconvert ! video/x-raw, format=I420 ! appsink name=privacysink max-buffers=2 sync=false appsrc name=privacysrc is-live=true format=time"
+}
+
+// ParsePrivacyMasks parses the masks passed by the parent process, the
+// configured masks are used if none were passed
+func ParsePrivacyMasks(conf *config.Configuration, masks string) []config.PrivacyMask {
+	if masks == "" {
+		if conf.Privacy == nil {
+			return nil
+		}
+		return conf.Privacy.Masks
+	}
+
+	var privacyMasks []config.PrivacyMask
+	if err := json.Unmarshal([]byte(masks), &privacyMasks); err != nil {
+		log.Fatalln(err)
+	}
+
+	return privacyMasks
+}
+
+func videoSize(caps *gst.Caps) (int, int, error) {
+	structure := caps.GetStructureAt(0)
+	if structure == nil {
+		return 0, 0, errors.New("video caps have no structure")
+	}
+
+	width, err := structure.GetValue("width")
+	if err != nil {
+		return 0, 0, err
+	}
+	height, err := structure.GetValue("height")
+	if err != nil {
+		return 0, 0, err
+	}
+
+	w, ok := width.(int)
+	h, ok2 := height.(int)
+	if !ok || !ok2 {
+		return 0, 0, fmt.Errorf("unexpected video size: %v x %v", width, height)
+	}
+
+	return w, h, nil
+}
+
+// paintPrivacyMasks paints the masks onto every frame passing through the
+// privacy mask painter of the pipeline. If frames is not zero, the stream
+// ends after that many frames. Frames which cannot be painted stop the
+// pipeline rather than being passed on unmasked.
+func paintPrivacyMasks(pipeline *gst.Pipeline, painter *PrivacyPainter, frames int) {
+	sinkElement, err := pipeline.GetElementByName("privacysink")
+	if err != nil {
+		// Privacy masks are not configured
+		return
+	}
+
+	srcElement, err := pipeline.GetElementByName("privacysrc")
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	src := app.SrcFromElement(srcElement)
+	width, height := 0, 0
+	painted := 0
+
+	app.SinkFromElement(sinkElement).SetCallbacks(&app.SinkCallbacks{
+		NewSampleFunc: func(sink *app.Sink) gst.FlowReturn {
+			sample := sink.PullSample()
+			if sample == nil {
+				return gst.FlowEOS
+			}
+
+			if width == 0 {
+				caps := sample.GetCaps()
+				if width, height, err = videoSize(caps); err != nil {
+					pipelineFailure(err)
+				}
+				src.SetCaps(caps)
+			}
+
+			buffer := sample.GetBuffer()
+			if buffer == nil {
+				return gst.FlowError
+			}
+
+			frame := append([]byte(nil), buffer.Map(gst.MapRead).Bytes()...)
+			buffer.Unmap()
+
+			if err := painter.Paint(frame, width, height); err != nil {
+				pipelineFailure(err)
+			}
+
+			masked := gst.NewBufferFromBytes(frame)
+			masked.SetPresentationTimestamp(buffer.PresentationTimestamp())
+			masked.SetDuration(buffer.Duration())
+			if ret := src.PushBuffer(masked); ret != gst.FlowOK {
+				return ret
+			}
+
+			painted++
+			if frames > 0 && painted >= frames {
+				src.EndStream()
+				return gst.FlowEOS
+			}
+
+			return gst.FlowOK
+		},
+		EOSFunc: func(sink *app.Sink) {
+			src.EndStream()
+		},
+	})
+}
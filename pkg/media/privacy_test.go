@@ -0,0 +1,132 @@
+/*
+ Copyright (c) 2024 Neeraj Jakhar
+
+ This program is free software: you can redistribute it and/or modify
+ it under the terms of the GNU General Public License as published by
+ the Free Software Foundation, either version 3 of the License, or
+ (at your option) any later version.
+
+ This program is distributed in the hope that it will be useful,
+ but WITHOUT ANY WARRANTY; without even the implied warranty of
+ MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License
+ along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package media
+
+import (
+	"testing"
+
+	"github.com/homebackend/go-webrtc/pkg/config"
+	"github.com/homebackend/go-webrtc/pkg/session"
+)
+
+func TestMaskSpans(t *testing.T) {
+	rectangle := config.PrivacyMask{X: 0.25, Y: 0.5, Width: 0.5, Height: 0.5}
+	rows := maskSpans([]config.PrivacyMask{rectangle}, 8, 4)
+
+	for y, spans := range rows {
+		if y < 2 && len(spans) != 0 {
+			t.Errorf("row %d above the mask is masked: %v", y, spans)
+		}
+		if y >= 2 && (len(spans) != 1 || spans[0] != (span{start: 2, end: 6})) {
+			t.Errorf("unexpected spans of row %d: %v", y, spans)
+		}
+	}
+
+	// A triangle pointing down covers less of every row
+	triangle := config.PrivacyMask{Points: []config.PrivacyPoint{{X: 0, Y: 0}, {X: 1, Y: 0}, {X: 0.5, Y: 1}}}
+	rows = maskSpans([]config.PrivacyMask{triangle}, 8, 4)
+	for y := 1; y < len(rows); y++ {
+		previous, current := rows[y-1][0], rows[y][0]
+		if current.end-current.start >= previous.end-previous.start {
+			t.Errorf("row %d of the triangle is not narrower: %v %v", y, previous, current)
+		}
+	}
+}
+
+func TestPrivacyPainter(t *testing.T) {
+	width, height := 4, 2
+	// Strides are rounded up to multiples of 4
+	frame := make([]byte, 4*2+4*1+4*1)
+	for i := range frame {
+		frame[i] = 255
+	}
+
+	painter := NewPrivacyPainter([]config.PrivacyMask{{Width: 0.5, Height: 1}})
+	if err := painter.Paint(frame, width, height); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []byte{
+		maskLuma, maskLuma, 255, 255,
+		maskLuma, maskLuma, 255, 255,
+		maskChroma, 255, 255, 255,
+		maskChroma, 255, 255, 255,
+	}
+	for i := range expected {
+		if frame[i] != expected[i] {
+			t.Fatalf("unexpected frame %v", frame)
+		}
+	}
+
+	if err := painter.Paint(frame[:8], width, height); err == nil {
+		t.Error("frame which is too small painted")
+	}
+}
+
+func TestPrivacyPainterOddHeight(t *testing.T) {
+	width, height := 4, 3
+	// The luma plane is padded to an even number of rows
+	frame := make([]byte, 4*4+4*2+4*2)
+	for i := range frame {
+		frame[i] = 255
+	}
+
+	painter := NewPrivacyPainter([]config.PrivacyMask{{Width: 0.5, Height: 1}})
+	if err := painter.Paint(frame, width, height); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []byte{
+		maskLuma, maskLuma, 255, 255,
+		maskLuma, maskLuma, 255, 255,
+		maskLuma, maskLuma, 255, 255,
+		255, 255, 255, 255,
+		maskChroma, 255, 255, 255,
+		maskChroma, 255, 255, 255,
+		maskChroma, 255, 255, 255,
+		maskChroma, 255, 255, 255,
+	}
+	for i := range expected {
+		if frame[i] != expected[i] {
+			t.Fatalf("unexpected frame %v", frame)
+		}
+	}
+
+	if err := painter.Paint(frame[:28], width, height); err == nil {
+		t.Error("frame without padding row painted")
+	}
+}
+
+func TestPrivacyPainterHandleLine(t *testing.T) {
+	painter := NewPrivacyPainter(nil)
+
+	if painter.HandleLine(session.OVERLAY + `"text"`) {
+		t.Error("overlay update handled as privacy masks")
+	}
+	if !painter.HandleLine(session.MASKS + `[{"width":1,"height":1}]`) {
+		t.Fatal("privacy masks not handled")
+	}
+
+	frame := make([]byte, 4*2+4*1+4*1)
+	painter.Paint(frame, 4, 2)
+	for i := 0; i < 8; i++ {
+		if frame[i] != maskLuma {
+			t.Fatalf("updated masks not painted: %v", frame)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+/*
+ Copyright (c) 2024 Neeraj Jakhar
+
+ This program is free software: you can redistribute it and/or modify
+ it under the terms of the GNU General Public License as published by
+ the Free Software Foundation, either version 3 of the License, or
+ (at your option) any later version.
+
+ This program is distributed in the hope that it will be useful,
+ but WITHOUT ANY WARRANTY; without even the implied warranty of
+ MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License
+ along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package media
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/go-gst/go-gst/gst"
+	"github.com/homebackend/go-webrtc/pkg/config"
+)
+
+// RecordingPipeline returns the pipeline recording video and audio to a
+// WebM file
+func RecordingPipeline(conf *config.Configuration, videoSrc, audioSrc, file, text string) string {
+	videoSrc, motion := VideoCapture(conf, videoSrc, text)
+	audioSrc, sound := WithSoundDetection(conf, audioSrc)
+	return fmt.Sprintf("%s ! vp8enc deadline=1 cpu-used=5 ! queue ! webmmux name=mux ! filesink location=\"%s\" %s ! opusenc ! queue ! mux.%s%s", videoSrc, file, audioSrc, motion, sound)
+}
+
+// SnapshotPipeline returns the pipeline writing a JPEG image to the file
+func SnapshotPipeline(conf *config.Configuration, videoSrc, file, text string) string {
+	videoSrc = WithOverlay(conf, WithPrivacyMasks(conf, videoSrc), text)
+	return fmt.Sprintf("%s ! videoconvert ! jpegenc snapshot=true ! filesink location=\"%s\"", videoSrc, file)
+}
+
+// RecordToFile runs in the recording child process. It records until the
+// process is interrupted, after which the file is finalized.
+func RecordToFile(conf *config.Configuration, videoSrc, audioSrc, file, text string, masks []config.PrivacyMask) {
+	gst.Init(nil)
+
+	pipelineStr := RecordingPipeline(conf, videoSrc, audioSrc, file, text)
+	log.Println(pipelineStr)
+
+	pipeline, err := gst.NewPipelineFromString(pipelineStr)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	if conf.Motion != nil {
+		detectMotion(conf, pipeline)
+	}
+	if conf.Sound != nil {
+		measureLevel(pipeline)
+	}
+
+	painter := NewPrivacyPainter(masks)
+	paintPrivacyMasks(pipeline, painter, 0)
+
+	overlay := &TextOverlay{}
+	overlay.Attach(pipeline)
+	go func() {
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			if line := scanner.Text(); !overlay.HandleLine(line) {
+				painter.HandleLine(line)
+			}
+		}
+	}()
+
+	if err = pipeline.SetState(gst.StatePlaying); err != nil {
+		log.Fatalln(err)
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-signals
+		log.Println("Finishing recording")
+		pipeline.SendEvent(gst.NewEOSEvent())
+	}()
+
+	err = waitForEOS(pipeline)
+	pipeline.SetState(gst.StateNull)
+	if err != nil {
+		log.Fatalln(err)
+	}
+}
+
+// CaptureSnapshot runs in the snapshot child process and writes a single
+// JPEG image to the file
+func CaptureSnapshot(conf *config.Configuration, videoSrc, file, text string, masks []config.PrivacyMask) {
+	gst.Init(nil)
+
+	pipelineStr := SnapshotPipeline(conf, videoSrc, file, text)
+	log.Println(pipelineStr)
+
+	pipeline, err := gst.NewPipelineFromString(pipelineStr)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	// The painter ends the stream after the first frame, as the appsink of
+	// the painter does not finish with the snapshot
+	paintPrivacyMasks(pipeline, NewPrivacyPainter(masks), 1)
+
+	if err = pipeline.SetState(gst.StatePlaying); err != nil {
+		log.Fatalln(err)
+	}
+
+	err = waitForEOS(pipeline)
+	pipeline.SetState(gst.StateNull)
+	if err != nil {
+		log.Fatalln(err)
+	}
+}
+
+func waitForEOS(pipeline *gst.Pipeline) error {
+	msg := pipeline.GetBus().TimedPopFiltered(gst.ClockTimeNone, gst.MessageEOS|gst.MessageError)
+	if msg != nil && msg.Type() == gst.MessageError {
+		return msg.ParseError()
+	}
+
+	return nil
+}
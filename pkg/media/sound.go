@@ -0,0 +1,119 @@
+/*
+ Copyright (c) 2024 Neeraj Jakhar
+
+ This program is free software: you can redistribute it and/or modify
+ it under the terms of the GNU General Public License as published by
+ the Free Software Foundation, either version 3 of the License, or
+ (at your option) any later version.
+
+ This program is distributed in the hope that it will be useful,
+ but WITHOUT ANY WARRANTY; without even the implied warranty of
+ MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License
+ along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package media
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	"github.com/go-gst/go-gst/gst"
+	"github.com/go-gst/go-gst/gst/app"
+	"github.com/homebackend/go-webrtc/pkg/config"
+	"github.com/homebackend/go-webrtc/pkg/session"
+)
+
+const (
+	soundSampleRate     = 16000
+	levelReportInterval = 250 * time.Millisecond
+	// Level reported for digital silence
+	minimumLevel = -100
+)
+
+// soundBranch returns the part of a pipeline which converts the audio for
+// the level measurement
+func soundBranch() string {
+	return fmt.Sprintf("audioconvert ! audioresample ! audio/x-raw, format=S16LE, channels=1, rate=%d ! appsink name=sound max-buffers=8 drop=true sync=false", soundSampleRate)
+}
+
+// WithSoundDetection tees the audio source into the level measurement if
+// sound detection is enabled. The returned branch has to be appended to the
+// pipeline.
+func WithSoundDetection(conf *config.Configuration, audioSrc string) (string, string) {
+	if conf.Sound == nil {
+		return audioSrc, ""
+	}
+
+	return audioSrc + " ! tee name=soundtee ! queue", " soundtee. ! queue leaky=downstream ! " + soundBranch()
+}
+
+func printLevel(level float64) {
+	if s, err := json.Marshal(session.LevelReport{Level: level}); err == nil {
+		fmt.Println(session.LEVEL + string(s))
+	} else {
+		log.Println(err)
+	}
+}
+
+// rmsLevel converts the mean square of 16 bit samples to dBFS
+func rmsLevel(sumSquares float64, samples int) float64 {
+	if samples == 0 || sumSquares == 0 {
+		return minimumLevel
+	}
+
+	rms := math.Sqrt(sumSquares/float64(samples)) / 32768
+	return math.Max(20*math.Log10(rms), minimumLevel)
+}
+
+// measureLevel computes the RMS level of the samples of the sound appsink
+// and reports it to the parent process
+func measureLevel(pipeline *gst.Pipeline) {
+	sink, err := pipeline.GetElementByName("sound")
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	var sumSquares float64
+	samples := 0
+	lastReport := time.Now()
+
+	app.SinkFromElement(sink).SetCallbacks(&app.SinkCallbacks{
+		NewSampleFunc: func(sink *app.Sink) gst.FlowReturn {
+			sample := sink.PullSample()
+			if sample == nil {
+				return gst.FlowEOS
+			}
+
+			buffer := sample.GetBuffer()
+			if buffer == nil {
+				return gst.FlowError
+			}
+
+			data := buffer.Map(gst.MapRead).Bytes()
+			defer buffer.Unmap()
+
+			for i := 0; i+1 < len(data); i += 2 {
+				s := float64(int16(binary.LittleEndian.Uint16(data[i:])))
+				sumSquares += s * s
+				samples++
+			}
+
+			if time.Since(lastReport) >= levelReportInterval {
+				printLevel(rmsLevel(sumSquares, samples))
+				sumSquares = 0
+				samples = 0
+				lastReport = time.Now()
+			}
+
+			return gst.FlowOK
+		},
+	})
+}
@@ -0,0 +1,259 @@
+/*
+ Copyright (c) 2024 Neeraj Jakhar
+
+ This program is free software: you can redistribute it and/or modify
+ it under the terms of the GNU General Public License as published by
+ the Free Software Foundation, either version 3 of the License, or
+ (at your option) any later version.
+
+ This program is distributed in the hope that it will be useful,
+ but WITHOUT ANY WARRANTY; without even the implied warranty of
+ MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License
+ along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+// Package media builds the GStreamer pipelines of gowebrtc from the
+// configured sources and runs them in the capture processes, which stream,
+// record, take snapshots and watch for motion and sound.
+package media
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/homebackend/go-webrtc/pkg/config"
+)
+
+const (
+	SourceV4l2      = "v4l2"
+	SourceLibcamera = "libcamera"
+	SourceAlsa      = "alsa"
+	SourcePulse     = "pulse"
+	SourceTest      = "test"
+	SourceFile      = "file"
+	SourceRtsp      = "rtsp"
+)
+
+const (
+	FormatRaw   = "raw"
+	FormatMjpeg = "mjpeg"
+	FormatH264  = "h264"
+)
+
+const (
+	DefaultRtspLatency = 200
+	// Decoder used for sources whose format is not known in advance
+	autoDecoder = "decodebin"
+)
+
+// Decoders used for formats which are not raw, unless one is configured
+var defaultDecoders = map[string]string{
+	FormatMjpeg: "jpegdec",
+	FormatH264:  "h264parse ! avdec_h264",
+}
+
+// FormatCaps are the caps of the formats a camera can produce
+var FormatCaps = map[string]string{
+	FormatRaw:   "video/x-raw",
+	FormatMjpeg: "image/jpeg",
+	FormatH264:  "video/x-h264",
+}
+
+// sourceProperty returns a property of a source element if the value is set
+func sourceProperty(name, value string) string {
+	if value == "" {
+		return ""
+	}
+
+	return fmt.Sprintf(" %s=%s", name, Quote(value))
+}
+
+// decoder returns the decoder for the format produced by the source
+func decoder(s *config.SourceConfiguration, format string) string {
+	if s.Decoder != "" {
+		return s.Decoder
+	}
+
+	return defaultDecoders[format]
+}
+
+// demuxed returns the part of a pipeline which decodes the stream of the
+// given media type from a file or rtsp source
+func demuxed(s *config.SourceConfiguration, media string) string {
+	decoder := orDefault(s.Decoder, autoDecoder)
+	if s.Type == SourceFile {
+		return fmt.Sprintf("filesrc%s ! %s", sourceProperty("location", s.Location), decoder)
+	}
+
+	latency := s.Latency
+	if latency == 0 {
+		latency = DefaultRtspLatency
+	}
+
+	return fmt.Sprintf("rtspsrc%s latency=%d ! application/x-rtp, media=%s ! %s", sourceProperty("location", s.Location), latency, media, decoder)
+}
+
+func checkSource(s *config.SourceConfiguration) error {
+	switch s.Type {
+	case SourceFile, SourceRtsp:
+		if s.Location == "" {
+			return fmt.Errorf("%s source requires a location", s.Type)
+		}
+		if s.Format != "" {
+			return fmt.Errorf("%s source detects the format, it cannot be configured", s.Type)
+		}
+	default:
+		if s.Location != "" {
+			return fmt.Errorf("%s source does not support a location", s.Type)
+		}
+	}
+
+	return nil
+}
+
+// sourceVideo returns the pipeline of a structured video source. The video is
+// always converted to raw video of the configured size.
+func sourceVideo(s *config.SourceConfiguration, conf *config.Configuration) (string, error) {
+	if err := checkSource(s); err != nil {
+		return "", err
+	}
+
+	caps := fmt.Sprintf("width=%d, height=%d, framerate=%d/1", conf.ImageWidth, conf.ImageHeight, conf.FrameRate)
+	format := orDefault(s.Format, FormatRaw)
+
+	var src string
+	switch s.Type {
+	case SourceV4l2:
+		src = "v4l2src" + sourceProperty("device", s.Device)
+	case SourceLibcamera:
+		src = "libcamerasrc" + sourceProperty("camera-name", s.Device)
+	case SourceTest:
+		src = "videotestsrc is-live=true" + sourceProperty("pattern", s.Pattern)
+	case SourceFile, SourceRtsp:
+		return fmt.Sprintf("%s ! videoconvert ! videoscale ! videorate ! video/x-raw, %s ! queue", demuxed(s, "video"), caps), nil
+	default:
+		return "", fmt.Errorf("%s is not a video source", s.Type)
+	}
+
+	if s.Type != SourceV4l2 && format != FormatRaw {
+		return "", fmt.Errorf("%s source only produces raw video", s.Type)
+	}
+
+	if format == FormatRaw {
+		if s.Decoder != "" {
+			return "", errors.New("raw video does not need a decoder")
+		}
+
+		// The running time shows that test video is live
+		if s.Type == SourceTest {
+			return fmt.Sprintf("%s ! video/x-raw, %s ! timeoverlay halignment=right valignment=top ! videoconvert ! queue", src, caps), nil
+		}
+
+		return fmt.Sprintf("%s ! video/x-raw, %s ! videoconvert ! queue", src, caps), nil
+	}
+
+	return fmt.Sprintf("%s ! %s, %s ! %s ! videoconvert ! queue", src, FormatCaps[format], caps, decoder(s, format)), nil
+}
+
+// sourceAudio returns the pipeline of a structured audio source
+func sourceAudio(s *config.SourceConfiguration) (string, error) {
+	if err := checkSource(s); err != nil {
+		return "", err
+	}
+
+	if s.Format != "" && s.Format != FormatRaw {
+		return "", fmt.Errorf("%s is not an audio format", s.Format)
+	}
+	if s.Decoder != "" && s.Type != SourceFile && s.Type != SourceRtsp {
+		return "", fmt.Errorf("%s source does not need a decoder", s.Type)
+	}
+
+	var src string
+	switch s.Type {
+	case SourceAlsa:
+		src = "alsasrc" + sourceProperty("device", s.Device)
+	case SourcePulse:
+		src = "pulsesrc" + sourceProperty("device", s.Device)
+	case SourceTest:
+		src = "audiotestsrc is-live=true" + sourceProperty("wave", s.Pattern)
+	case SourceFile, SourceRtsp:
+		src = demuxed(s, "audio")
+	default:
+		return "", fmt.Errorf("%s is not an audio source", s.Type)
+	}
+
+	return fmt.Sprintf("%s ! audioconvert ! audioresample ! queue", src), nil
+}
+
+// UseTestSources replaces the configured sources with test sources, so that
+// streaming can be tried without capture devices. Configured test sources
+// are kept.
+func UseTestSources(conf *config.Configuration) {
+	if conf.VideoSource == nil || conf.VideoSource.Type != SourceTest {
+		conf.VideoSource = &config.SourceConfiguration{Type: SourceTest}
+	}
+	if conf.AudioSource == nil || conf.AudioSource.Type != SourceTest {
+		conf.AudioSource = &config.SourceConfiguration{Type: SourceTest}
+	}
+
+	conf.VideoDevice = ""
+	conf.AudioDevice = ""
+}
+
+// CheckSources validates the structured sources, so that errors are found on
+// start rather than when a capture process is started
+func CheckSources(conf *config.Configuration) error {
+	if conf.VideoSource != nil {
+		if _, err := sourceVideo(conf.VideoSource, conf); err != nil {
+			return fmt.Errorf("invalid video source: %w", err)
+		}
+	}
+
+	if conf.AudioSource != nil {
+		if _, err := sourceAudio(conf.AudioSource); err != nil {
+			return fmt.Errorf("invalid audio source: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// ConfiguredSource is the source described by the configuration, either by
+// the structured sources or by the raw video_device and audio_device
+type ConfiguredSource struct {
+	config   *config.Configuration
+	settings *config.Settings
+}
+
+func NewSource(conf *config.Configuration, settings *config.Settings) *ConfiguredSource {
+	return &ConfiguredSource{config: conf, settings: settings}
+}
+
+// VideoPipeline returns the pipeline of the video source, converted to the
+// configured size. A raw video_device is used as given.
+func (s *ConfiguredSource) VideoPipeline() (string, error) {
+	conf := s.config
+	if conf.VideoSource == nil {
+		return fmt.Sprintf("%s ! video/x-raw, width=%d, height=%d, framerate=%d/1 ! videoconvert ! queue", conf.VideoDevice, conf.ImageWidth, conf.ImageHeight, conf.FrameRate), nil
+	}
+
+	return sourceVideo(conf.VideoSource, conf)
+}
+
+// AudioPipeline returns the pipeline of the audio source, which is silence
+// while audio is disabled
+func (s *ConfiguredSource) AudioPipeline() (string, error) {
+	conf := s.config
+	if !s.settings.AudioEnabled() {
+		return "audiotestsrc wave=silence is-live=true ! audioconvert ! queue", nil
+	}
+
+	if conf.AudioSource == nil {
+		return fmt.Sprintf("%s ! audioconvert ! queue", conf.AudioDevice), nil
+	}
+
+	return sourceAudio(conf.AudioSource)
+}
@@ -0,0 +1,147 @@
+/*
+ Copyright (c) 2024 Neeraj Jakhar
+
+ This program is free software: you can redistribute it and/or modify
+ it under the terms of the GNU General Public License as published by
+ the Free Software Foundation, either version 3 of the License, or
+ (at your option) any later version.
+
+ This program is distributed in the hope that it will be useful,
+ but WITHOUT ANY WARRANTY; without even the implied warranty of
+ MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License
+ along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package media
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/homebackend/go-webrtc/pkg/config"
+	"github.com/homebackend/go-webrtc/pkg/session"
+)
+
+// The configured source is what the server streams from
+var _ session.Source = &ConfiguredSource{}
+
+func testConfiguration() *config.Configuration {
+	return &config.Configuration{ImageWidth: 640, ImageHeight: 480, FrameRate: 30}
+}
+
+func TestVideoPipeline(t *testing.T) {
+	tests := []struct {
+		source   config.SourceConfiguration
+		pipeline string
+	}{
+		{config.SourceConfiguration{Type: SourceV4l2, Device: "/dev/video0"},
+			`v4l2src device="/dev/video0" ! video/x-raw, width=640, height=480, framerate=30/1 ! videoconvert ! queue`},
+		{config.SourceConfiguration{Type: SourceV4l2, Format: FormatMjpeg},
+			`v4l2src ! image/jpeg, width=640, height=480, framerate=30/1 ! jpegdec ! videoconvert ! queue`},
+		{config.SourceConfiguration{Type: SourceRtsp, Location: "rtsp://camera/stream"},
+			`rtspsrc location="rtsp://camera/stream" latency=200 ! application/x-rtp, media=video ! decodebin ! videoconvert ! videoscale ! videorate ! video/x-raw, width=640, height=480, framerate=30/1 ! queue`},
+	}
+
+	for _, test := range tests {
+		conf := testConfiguration()
+		conf.VideoSource = &test.source
+
+		pipeline, err := NewSource(conf, config.NewSettings(conf)).VideoPipeline()
+		if err != nil {
+			t.Errorf("%s source: %v", test.source.Type, err)
+		} else if pipeline != test.pipeline {
+			t.Errorf("%s source: unexpected pipeline %s", test.source.Type, pipeline)
+		}
+	}
+}
+
+func TestVideoDevice(t *testing.T) {
+	conf := testConfiguration()
+	conf.VideoDevice = "v4l2src"
+
+	pipeline, err := NewSource(conf, config.NewSettings(conf)).VideoPipeline()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(pipeline, "v4l2src ! video/x-raw, width=640, height=480") {
+		t.Errorf("unexpected pipeline: %s", pipeline)
+	}
+}
+
+func TestAudioPipeline(t *testing.T) {
+	conf := testConfiguration()
+	conf.AudioSource = &config.SourceConfiguration{Type: SourcePulse}
+	settings := config.NewSettings(conf)
+	source := NewSource(conf, settings)
+
+	if pipeline, err := source.AudioPipeline(); err != nil || pipeline != "pulsesrc ! audioconvert ! audioresample ! queue" {
+		t.Errorf("unexpected pipeline: %s %v", pipeline, err)
+	}
+
+	settings.SetAudioEnabled(false)
+	if pipeline, _ := source.AudioPipeline(); !strings.HasPrefix(pipeline, "audiotestsrc wave=silence") {
+		t.Errorf("disabled audio is not silent: %s", pipeline)
+	}
+}
+
+func TestCheckSources(t *testing.T) {
+	invalid := []config.Configuration{
+		{VideoSource: &config.SourceConfiguration{Type: SourceAlsa}},
+		{VideoSource: &config.SourceConfiguration{Type: SourceFile}},
+		{VideoSource: &config.SourceConfiguration{Type: SourceTest, Format: FormatH264}},
+		{AudioSource: &config.SourceConfiguration{Type: SourceAlsa, Decoder: "decodebin"}},
+		{AudioSource: &config.SourceConfiguration{Type: SourceV4l2}},
+	}
+
+	for i := range invalid {
+		if err := CheckSources(&invalid[i]); err == nil {
+			t.Errorf("invalid sources accepted: %v %v", invalid[i].VideoSource, invalid[i].AudioSource)
+		}
+	}
+
+	conf := testConfiguration()
+	UseTestSources(conf)
+	if err := CheckSources(conf); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCodecPipeline(t *testing.T) {
+	pipeline, err := CodecPipeline("opus", "audiotestsrc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pipeline != "audiotestsrc ! opusenc ! appsink name=appsink" {
+		t.Errorf("unexpected pipeline: %s", pipeline)
+	}
+
+	if _, err := CodecPipeline("mp3", "audiotestsrc"); err == nil {
+		t.Error("unknown codec accepted")
+	}
+}
+
+func TestVideoCapture(t *testing.T) {
+	conf := testConfiguration()
+	conf.Motion = &config.MotionConfiguration{}
+	conf.Privacy = &config.PrivacyConfiguration{}
+	conf.Overlay = &config.OverlayConfiguration{TextPosition: "top-right"}
+
+	video, motion := VideoCapture(conf, "videotestsrc", `say "hi"`)
+
+	// Masks are painted before anything else sees the video
+	privacy := strings.Index(video, "appsink name=privacysink")
+	tee := strings.Index(video, "tee name=motiontee")
+	overlay := strings.Index(video, "textoverlay name=textoverlay")
+	if privacy < 0 || tee < privacy || overlay < tee {
+		t.Errorf("unexpected order of the capture: %s", video)
+	}
+	if !strings.Contains(video, `text="say \"hi\"" valignment=top halignment=right`) {
+		t.Errorf("text overlay not quoted or aligned: %s", video)
+	}
+	if !strings.HasPrefix(motion, " motiontee. ! ") || !strings.Contains(motion, "appsink name=motion") {
+		t.Errorf("unexpected motion branch: %s", motion)
+	}
+}
@@ -0,0 +1,108 @@
+/*
+ Copyright (c) 2024 Neeraj Jakhar
+
+ This program is free software: you can redistribute it and/or modify
+ it under the terms of the GNU General Public License as published by
+ the Free Software Foundation, either version 3 of the License, or
+ (at your option) any later version.
+
+ This program is distributed in the hope that it will be useful,
+ but WITHOUT ANY WARRANTY; without even the implied warranty of
+ MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License
+ along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package media
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/homebackend/go-webrtc/pkg/session"
+	"github.com/pion/webrtc/v3"
+)
+
+// How often the stats of the connection are reported to the parent process
+const statsInterval = 5 * time.Second
+
+// candidateType returns the type of the remote candidate of the nominated
+// candidate pair, which is empty until a pair has been nominated
+func candidateType(report webrtc.StatsReport) string {
+	for _, stats := range report {
+		pair, ok := stats.(webrtc.ICECandidatePairStats)
+		if !ok || !pair.Nominated || pair.State != webrtc.StatsICECandidatePairStateSucceeded {
+			continue
+		}
+
+		if candidate, ok := report[pair.RemoteCandidateID].(webrtc.ICECandidateStats); ok {
+			return candidate.CandidateType.String()
+		}
+	}
+
+	return ""
+}
+
+// bytesSent returns the bytes sent on the ICE transport
+func bytesSent(report webrtc.StatsReport) uint64 {
+	for _, stats := range report {
+		if transport, ok := stats.(webrtc.TransportStats); ok {
+			return transport.BytesSent
+		}
+	}
+
+	return 0
+}
+
+// bitrate returns the bits per second of the bytes sent in the elapsed time
+func bitrate(sent, previous uint64, elapsed time.Duration) uint64 {
+	if elapsed <= 0 || sent < previous {
+		return 0
+	}
+
+	return uint64(float64(sent-previous) * 8 / elapsed.Seconds())
+}
+
+// senderCodecs returns the codecs negotiated for the tracks sent to the viewer
+func senderCodecs(peerConnection *webrtc.PeerConnection) []string {
+	var codecs []string
+	for _, sender := range peerConnection.GetSenders() {
+		if parameters := sender.GetParameters(); len(parameters.Codecs) > 0 {
+			codecs = append(codecs, parameters.Codecs[0].MimeType)
+		}
+	}
+
+	return codecs
+}
+
+func printStats(stats session.StatsReport) {
+	if s, err := json.Marshal(stats); err == nil {
+		fmt.Println(session.STATS + string(s))
+	} else {
+		log.Println(err)
+	}
+}
+
+// reportStats periodically prints the stats of the connection to the viewer
+func reportStats(peerConnection *webrtc.PeerConnection) {
+	ticker := time.NewTicker(statsInterval)
+	defer ticker.Stop()
+
+	var sent uint64
+	last := time.Now()
+	for now := range ticker.C {
+		report := peerConnection.GetStats()
+		total := bytesSent(report)
+
+		printStats(session.StatsReport{
+			Codecs:        senderCodecs(peerConnection),
+			CandidateType: candidateType(report),
+			Bitrate:       bitrate(total, sent, now.Sub(last)),
+		})
+		sent, last = total, now
+	}
+}
@@ -0,0 +1,52 @@
+/*
+ Copyright (c) 2024 Neeraj Jakhar
+
+ This program is free software: you can redistribute it and/or modify
+ it under the terms of the GNU General Public License as published by
+ the Free Software Foundation, either version 3 of the License, or
+ (at your option) any later version.
+
+ This program is distributed in the hope that it will be useful,
+ but WITHOUT ANY WARRANTY; without even the implied warranty of
+ MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License
+ along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package media
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+func TestCandidateType(t *testing.T) {
+	report := webrtc.StatsReport{
+		"waiting": webrtc.ICECandidatePairStats{RemoteCandidateID: "host", State: webrtc.StatsICECandidatePairStateWaiting},
+		"host":    webrtc.ICECandidateStats{CandidateType: webrtc.ICECandidateTypeHost},
+		"relay":   webrtc.ICECandidateStats{CandidateType: webrtc.ICECandidateTypeRelay},
+	}
+	if candidate := candidateType(report); candidate != "" {
+		t.Errorf("candidate type without a nominated pair: %s", candidate)
+	}
+
+	report["nominated"] = webrtc.ICECandidatePairStats{RemoteCandidateID: "relay", Nominated: true, State: webrtc.StatsICECandidatePairStateSucceeded}
+	if candidate := candidateType(report); candidate != "relay" {
+		t.Errorf("unexpected candidate type: %s", candidate)
+	}
+}
+
+func TestBitrate(t *testing.T) {
+	report := webrtc.StatsReport{"iceTransport": webrtc.TransportStats{BytesSent: 3000}}
+	if rate := bitrate(bytesSent(report), 1000, 2*time.Second); rate != 8000 {
+		t.Errorf("unexpected bitrate: %d", rate)
+	}
+
+	if rate := bitrate(1000, 3000, time.Second); rate != 0 {
+		t.Errorf("bitrate of a reset counter: %d", rate)
+	}
+}
@@ -0,0 +1,389 @@
+/*
+ Copyright (c) 2024 Neeraj Jakhar
+
+ This program is free software: you can redistribute it and/or modify
+ it under the terms of the GNU General Public License as published by
+ the Free Software Foundation, either version 3 of the License, or
+ (at your option) any later version.
+
+ This program is distributed in the hope that it will be useful,
+ but WITHOUT ANY WARRANTY; without even the implied warranty of
+ MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License
+ along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package media
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/go-gst/go-gst/gst"
+	"github.com/go-gst/go-gst/gst/app"
+	"github.com/homebackend/go-webrtc/pkg/config"
+	"github.com/homebackend/go-webrtc/pkg/session"
+	"github.com/homebackend/go-webrtc/pkg/signalling"
+	"github.com/homebackend/go-webrtc/pkg/turn"
+	"github.com/pion/interceptor"
+	"github.com/pion/webrtc/v3"
+	pionmedia "github.com/pion/webrtc/v3/pkg/media"
+)
+
+// StartStreaming runs in the streaming child process. It answers the offer
+// in the sdp file and streams until the process is terminated.
+func StartStreaming(conf *config.Configuration, videoSrc, audioSrc, sdpFile, text string, masks []config.PrivacyMask, wait bool) {
+	startExecuting(conf, videoSrc, audioSrc, sdpFile, text, masks, wait)
+}
+
+// newPeerConnection creates the peer connection of a streaming process with
+// the configured ICE servers
+func newPeerConnection(conf *config.Configuration) (*webrtc.PeerConnection, error) {
+	webrtcConfig := webrtc.Configuration{}
+	if conf.UseInternalTurn {
+		if conf.TurnConfiguration.TurnType == config.TurnInternal {
+			webrtcConfig.ICEServers = turn.IceServers(conf.TurnConfiguration)
+		} else {
+			m := &webrtc.MediaEngine{}
+			if err := m.RegisterDefaultCodecs(); err != nil {
+				return nil, err
+			}
+
+			i := &interceptor.Registry{}
+			if err := webrtc.RegisterDefaultInterceptors(m, i); err != nil {
+				return nil, err
+			}
+
+			s := webrtc.SettingEngine{}
+			s.SetNAT1To1IPs([]string{conf.TurnConfiguration.PublicIp}, webrtc.ICECandidateTypeSrflx)
+
+			webrtcConfig.ICEServers = make([]webrtc.ICEServer, 1)
+			webrtcConfig.ICEServers[0].URLs = make([]string, 1)
+			webrtcConfig.ICEServers[0].URLs[0] = "stun:stun.l.google.com:19302"
+
+			api := webrtc.NewAPI(webrtc.WithMediaEngine(m), webrtc.WithInterceptorRegistry(i), webrtc.WithSettingEngine(s))
+			return api.NewPeerConnection(webrtcConfig)
+		}
+	} else if conf.OpenRelayConfig != nil {
+		fmt.Println("Found Open Relay Config")
+		url := fmt.Sprintf("https://%s/api/v1/turn/credentials?apiKey=%s", conf.OpenRelayConfig.AppName, conf.OpenRelayConfig.ApiKey)
+		fmt.Printf("Url: %s\n", url)
+		response, err := http.Get(url)
+		if err != nil {
+			return nil, err
+		}
+		defer response.Body.Close()
+
+		responseData, err := io.ReadAll(response.Body)
+		if err != nil {
+			return nil, err
+		}
+
+		var iceServers []config.ICEServer
+		if err := json.Unmarshal(responseData, &iceServers); err != nil {
+			return nil, err
+		}
+
+		webrtcConfig.ICEServers = make([]webrtc.ICEServer, len(iceServers))
+		for i, iceServer := range iceServers {
+			webrtcConfig.ICEServers[i].URLs = make([]string, 1)
+			webrtcConfig.ICEServers[i].URLs[0] = iceServer.URLs
+			webrtcConfig.ICEServers[i].Username = iceServer.Username
+			webrtcConfig.ICEServers[i].Credential = iceServer.Credential
+			webrtcConfig.ICEServers[i].CredentialType = iceServer.CredentialType
+		}
+	} else if len(conf.IceServers) > 0 {
+		fmt.Println("Found ICE Servers")
+		webrtcConfig.ICEServers = conf.IceServers
+	} else {
+		fmt.Println("Using default Ice Servers")
+		webrtcConfig.ICEServers = make([]webrtc.ICEServer, 1)
+		webrtcConfig.ICEServers[0].URLs = make([]string, 1)
+		webrtcConfig.ICEServers[0].URLs[0] = "stun:stun.l.google.com:19302"
+	}
+
+	fmt.Printf("Webrtc config: %v\n", webrtcConfig)
+	return webrtc.NewPeerConnection(webrtcConfig)
+}
+
+func printAnswer(answer webrtc.SessionDescription) {
+	localDescription, err := signalling.EncodeDescription(answer)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	fmt.Println(session.ANSWER + localDescription)
+}
+
+func printState(state, reason string) {
+	if s, err := json.Marshal(signalling.StateEvent{State: state, Reason: reason}); err == nil {
+		fmt.Println(session.STATE + string(s))
+	} else {
+		log.Println(err)
+	}
+}
+
+// pipelineFailure reports the error to the parent process before exiting
+func pipelineFailure(err error) {
+	printState(signalling.StatePipelineError, err.Error())
+	log.Fatalln(err)
+}
+
+func startExecuting(conf *config.Configuration, videoSrc, audioSrc, sdpFile, text string, masks []config.PrivacyMask, wait bool) {
+	gst.Init(nil)
+
+	peerConnection, err := newPeerConnection(conf)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	defer func() {
+		peerConnection.Close()
+	}()
+
+	peerConnection.OnICEConnectionStateChange(func(connectionState webrtc.ICEConnectionState) {
+		fmt.Printf("Connection State has changed %s \n", connectionState.String())
+	})
+
+	// The peer connection fails once ICE fails, failures are only reported
+	// here so that clients see a single failed state
+	peerConnection.OnConnectionStateChange(func(connectionState webrtc.PeerConnectionState) {
+		fmt.Printf("Peer Connection State has changed %s \n", connectionState.String())
+		switch connectionState {
+		case webrtc.PeerConnectionStateConnecting:
+			printState(signalling.StateConnecting, "")
+		case webrtc.PeerConnectionStateConnected:
+			printState(signalling.StateConnected, "")
+		case webrtc.PeerConnectionStateDisconnected:
+			printState(signalling.StateDisconnected, "")
+		case webrtc.PeerConnectionStateFailed:
+			printState(signalling.StateFailed, "peer connection could not be established")
+			log.Fatalln("Exiting as connection could not be established")
+		}
+	})
+
+	peerConnection.OnDataChannel(reportVisibility)
+
+	audioTrack, err := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: "audio/opus"}, "audio", "pion1")
+	if err != nil {
+		log.Fatalln(err)
+	}
+	_, err = peerConnection.AddTrack(audioTrack)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	// Create a video track
+	videoTrack, err := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: "video/vp8"}, "video", "pion2")
+	if err != nil {
+		log.Fatalln(err)
+	}
+	_, err = peerConnection.AddTrack(videoTrack)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	buf, err := os.ReadFile(sdpFile)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	offer, err := signalling.DecodeDescription(string(buf))
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	err = peerConnection.SetRemoteDescription(offer)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	overlay := &TextOverlay{}
+	painter := NewPrivacyPainter(masks)
+	go readRemoteCandidates(peerConnection, overlay, painter)
+
+	peerConnection.OnICECandidate(func(i *webrtc.ICECandidate) {
+		if wait {
+			if i == nil {
+				fmt.Println(session.EOF)
+			} else {
+				fmt.Printf("Gathered candidate: %s\n", i.String())
+				if c, err := json.Marshal(i.ToJSON()); err == nil {
+					fmt.Println(session.CANDIDATE + string(c))
+				} else {
+					log.Fatalln(err)
+				}
+			}
+		} else {
+			if i == nil {
+				fmt.Println("All candidates have been gathered")
+				printAnswer(*peerConnection.LocalDescription())
+				fmt.Println(session.EOF)
+			}
+		}
+	})
+
+	if answer, err := peerConnection.CreateAnswer(nil); err != nil {
+		log.Fatalln(err)
+	} else {
+		if wait {
+			printAnswer(answer)
+		}
+		peerConnection.SetLocalDescription(answer)
+	}
+
+	audioSrc, sound := WithSoundDetection(conf, audioSrc)
+	audio := &supervisedPipeline{
+		codec:       "opus",
+		tracks:      []*webrtc.TrackLocalStaticSample{audioTrack},
+		source:      audioSrc,
+		branches:    sound,
+		placeholder: placeholderSource(conf, "opus"),
+		attach: func(pipeline *gst.Pipeline) {
+			if sound != "" {
+				measureLevel(pipeline)
+			}
+		},
+	}
+	audio.run()
+
+	videoSrc, motion := VideoCapture(conf, videoSrc, text)
+	video := &supervisedPipeline{
+		codec:       "vp8",
+		tracks:      []*webrtc.TrackLocalStaticSample{videoTrack},
+		source:      videoSrc,
+		branches:    motion,
+		placeholder: placeholderSource(conf, "vp8"),
+		attach: func(pipeline *gst.Pipeline) {
+			if motion != "" {
+				detectMotion(conf, pipeline)
+			}
+			paintPrivacyMasks(pipeline, painter, 0)
+			overlay.Attach(pipeline)
+		},
+	}
+	video.run()
+
+	go reportStats(peerConnection)
+
+	select {}
+}
+
+// readRemoteCandidates adds the candidates trickled by the remote peer, which
+// are written to stdin by the parent process along with capture setting updates
+func readRemoteCandidates(peerConnection *webrtc.PeerConnection, overlay *TextOverlay, painter *PrivacyPainter) {
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		m := scanner.Text()
+		if overlay.HandleLine(m) || painter.HandleLine(m) || !strings.HasPrefix(m, session.CANDIDATE) {
+			continue
+		}
+
+		var candidate webrtc.ICECandidateInit
+		if err := json.Unmarshal([]byte(m[len(session.CANDIDATE):]), &candidate); err != nil {
+			log.Println(err)
+			continue
+		}
+
+		if err := peerConnection.AddICECandidate(candidate); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// VideoCapture applies the privacy masks, motion detection and overlays to the
+// video source. The returned motion detection branch has to be appended to
+// the pipeline.
+func VideoCapture(conf *config.Configuration, videoSrc, text string) (string, string) {
+	videoSrc, motion := withMotionDetection(conf, WithPrivacyMasks(conf, videoSrc))
+	return WithOverlay(conf, videoSrc, text), motion
+}
+
+// CodecPipeline returns the pipeline description which encodes the source
+// with the codec into the appsink
+func CodecPipeline(codecName, pipelineSrc string) (string, error) {
+	pipelineStr := "appsink name=appsink"
+	switch codecName {
+	case "vp8":
+		pipelineStr = pipelineSrc + " ! vp8enc error-resilient=partitions keyframe-max-dist=10 auto-alt-ref=true cpu-used=5 deadline=1 ! " + pipelineStr
+	case "vp9":
+		pipelineStr = pipelineSrc + " ! vp9enc ! " + pipelineStr
+	case "h264":
+		pipelineStr = pipelineSrc + " ! video/x-raw,format=I420 ! x264enc speed-preset=ultrafast tune=zerolatency key-int-max=20 ! video/x-h264,stream-format=byte-stream ! " + pipelineStr
+	case "opus":
+		pipelineStr = pipelineSrc + " ! opusenc ! " + pipelineStr
+	case "pcmu":
+		pipelineStr = pipelineSrc + " ! audio/x-raw, rate=8000 ! mulawenc ! " + pipelineStr
+	case "pcma":
+		pipelineStr = pipelineSrc + " ! audio/x-raw, rate=8000 ! alawenc ! " + pipelineStr
+	default:
+		return "", fmt.Errorf("unhandled codec %s", codecName)
+	}
+
+	return pipelineStr, nil
+}
+
+// Create the appropriate GStreamer pipeline depending on what codec we are working with.
+// Additional branches of a tee in the source can be passed in branches. The
+// pipeline is returned before it is started.
+func pipelineForCodec(codecName string, tracks []*webrtc.TrackLocalStaticSample, pipelineSrc, branches string) (*gst.Pipeline, error) {
+	pipelineStr, err := CodecPipeline(codecName, pipelineSrc)
+	if err != nil {
+		return nil, err
+	}
+
+	pipelineStr += branches
+
+	log.Println(pipelineStr)
+	pipeline, err := gst.NewPipelineFromString(pipelineStr)
+	if err != nil {
+		return nil, err
+	}
+
+	appSink, err := pipeline.GetElementByName("appsink")
+	if err != nil {
+		return nil, err
+	}
+
+	// Write errors are logged once until writing succeeds again, a closing
+	// peer connection is handled by the connection state
+	failing := false
+	app.SinkFromElement(appSink).SetCallbacks(&app.SinkCallbacks{
+		NewSampleFunc: func(sink *app.Sink) gst.FlowReturn {
+			sample := sink.PullSample()
+			if sample == nil {
+				return gst.FlowEOS
+			}
+
+			buffer := sample.GetBuffer()
+			if buffer == nil {
+				return gst.FlowError
+			}
+
+			samples := buffer.Map(gst.MapRead).Bytes()
+			defer buffer.Unmap()
+
+			for _, t := range tracks {
+				if err := t.WriteSample(pionmedia.Sample{Data: samples, Duration: *buffer.Duration().AsDuration()}); err != nil {
+					if !failing {
+						log.Printf("Unable to write %s sample: %v", codecName, err)
+					}
+					failing = true
+				} else {
+					failing = false
+				}
+			}
+
+			return gst.FlowOK
+		},
+	})
+
+	return pipeline, nil
+}
@@ -0,0 +1,250 @@
+/*
+ Copyright (c) 2024 Neeraj Jakhar
+
+ This program is free software: you can redistribute it and/or modify
+ it under the terms of the GNU General Public License as published by
+ the Free Software Foundation, either version 3 of the License, or
+ (at your option) any later version.
+
+ This program is distributed in the hope that it will be useful,
+ but WITHOUT ANY WARRANTY; without even the implied warranty of
+ MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License
+ along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package media
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/go-gst/go-gst/gst"
+	"github.com/homebackend/go-webrtc/pkg/config"
+	"github.com/homebackend/go-webrtc/pkg/signalling"
+	"github.com/pion/webrtc/v3"
+)
+
+const (
+	restartInitialBackoff = time.Second
+	restartMaxBackoff     = 30 * time.Second
+	// A restarted pipeline which runs this long has recovered for good,
+	// later failures start over with the initial backoff
+	restartStableAfter = time.Minute
+	// How long a restarted source may take to deliver data
+	restartPlayingTimeout = 10 * time.Second
+)
+
+// pipelineFault tells what stopped a pipeline
+type pipelineFault int
+
+const (
+	// The source stopped delivering, e.g. a camera was unplugged. Restarting
+	// the pipeline may help once the device is back.
+	faultSource pipelineFault = iota
+	// Any other element failed, restarting would fail the same way
+	faultFatal
+	// A source which is not live, such as a file, reached its end
+	faultEnded
+)
+
+// classifyFault decides what stopped the pipeline from the type of the bus
+// message, whether the pipeline is live and the domain and code of errors.
+// Live sources only end the stream when their device goes away.
+func classifyFault(messageType gst.MessageType, live bool, domain gst.Domain, code gst.ErrorCode) pipelineFault {
+	if messageType == gst.MessageEOS {
+		if live {
+			return faultSource
+		}
+		return faultEnded
+	}
+
+	// Only devices which cannot be opened or read may come back. Errors
+	// such as refused formats would not go away by restarting.
+	if domain != gst.DomainResource {
+		return faultFatal
+	}
+
+	switch code {
+	case gst.ResourceErrorFailed, gst.ResourceErrorNotFound, gst.ResourceErrorBusy,
+		gst.ResourceErrorOpenRead, gst.ResourceErrorOpenReadWrite, gst.ResourceErrorRead:
+		return faultSource
+	default:
+		return faultFatal
+	}
+}
+
+// nextBackoff doubles the time to wait before the next restart, up to the
+// maximum
+func nextBackoff(backoff time.Duration) time.Duration {
+	return min(2*backoff, restartMaxBackoff)
+}
+
+// placeholderSource returns the source streamed while the source of the codec
+// is restarted, black video or silence
+func placeholderSource(conf *config.Configuration, codecName string) string {
+	switch codecName {
+	case "opus", "pcmu", "pcma":
+		return "audiotestsrc wave=silence is-live=true ! audioconvert ! queue"
+	default:
+		return fmt.Sprintf("videotestsrc pattern=black is-live=true ! video/x-raw, width=%d, height=%d, framerate=%d/1 ! videoconvert ! queue",
+			conf.ImageWidth, conf.ImageHeight, conf.FrameRate)
+	}
+}
+
+// supervisedPipeline feeds the tracks from the source, restarting the
+// pipeline with exponential backoff when the source fails. A placeholder
+// feeds the tracks in the meantime so that the peer connection stays up.
+type supervisedPipeline struct {
+	codec       string
+	tracks      []*webrtc.TrackLocalStaticSample
+	source      string
+	branches    string
+	placeholder string
+	// attach sets up the branches of every started source pipeline, such as
+	// motion detection and overlays
+	attach func(*gst.Pipeline)
+}
+
+// start creates the pipeline of the source and starts playing it
+func (p *supervisedPipeline) start(source, branches string) (*gst.Pipeline, error) {
+	pipeline, err := pipelineForCodec(p.codec, p.tracks, source, branches)
+	if err != nil {
+		return nil, err
+	}
+
+	if source == p.source && p.attach != nil {
+		p.attach(pipeline)
+	}
+
+	if err := pipeline.SetState(gst.StatePlaying); err != nil {
+		pipeline.SetState(gst.StateNull)
+		return nil, err
+	}
+
+	return pipeline, nil
+}
+
+// wait blocks until the pipeline stops and returns why
+func wait(pipeline *gst.Pipeline) (pipelineFault, error) {
+	msg := pipeline.GetBus().TimedPopFiltered(gst.ClockTimeNone, gst.MessageEOS|gst.MessageError)
+	if msg == nil {
+		return faultFatal, errors.New("pipeline bus closed")
+	}
+	if msg.Type() == gst.MessageEOS {
+		return classifyFault(gst.MessageEOS, isLive(pipeline), "", 0), fmt.Errorf("%s ended the stream", msg.Source())
+	}
+
+	gerr := msg.ParseError()
+	domain, code := errorCode(msg)
+	log.Printf("Pipeline error from %s: %s (%s)", msg.Source(), gerr.Error(), gerr.DebugString())
+	return classifyFault(gst.MessageError, false, domain, code), fmt.Errorf("%s: %s", msg.Source(), gerr.Error())
+}
+
+// isLive tells whether the source of the playing pipeline is live, such as
+// a capture device
+func isLive(pipeline *gst.Pipeline) bool {
+	query := gst.NewLatencyQuery()
+	if !pipeline.Query(query) {
+		return false
+	}
+
+	live, _, _ := query.ParseLatency()
+	return live
+}
+
+// awaitPlaying waits until the pipeline plays, which it does once its sinks
+// received data from the source
+func awaitPlaying(pipeline *gst.Pipeline) error {
+	switch result, _ := pipeline.GetState(gst.StatePlaying, gst.ClockTime(restartPlayingTimeout)); result {
+	case gst.StateChangeSuccess, gst.StateChangeNoPreroll:
+		return nil
+	case gst.StateChangeAsync:
+		return fmt.Errorf("no data within %s", restartPlayingTimeout)
+	default:
+		return errors.New("pipeline failed to play")
+	}
+}
+
+// run starts the pipeline and keeps it running until the process exits.
+// The parent process is told when the device is lost and recovered. The
+// process exits once a source which is not live has ended.
+func (p *supervisedPipeline) run() {
+	pipeline, err := p.start(p.source, p.branches)
+	if err != nil {
+		pipelineFailure(err)
+	}
+
+	go func() {
+		backoff := restartInitialBackoff
+		for {
+			started := time.Now()
+			fault, err := wait(pipeline)
+			pipeline.SetState(gst.StateNull)
+			switch fault {
+			case faultFatal:
+				pipelineFailure(err)
+			case faultEnded:
+				log.Printf("Source of %s ended: %v", p.codec, err)
+				os.Exit(0)
+			}
+
+			if time.Since(started) >= restartStableAfter {
+				backoff = restartInitialBackoff
+			}
+
+			log.Printf("Source of %s lost: %v", p.codec, err)
+			printState(signalling.StateDeviceLost, err.Error())
+			pipeline = p.restart(&backoff)
+			printState(signalling.StateDeviceRecovered, "")
+		}
+	}()
+}
+
+// restart streams the placeholder until the source pipeline can be started
+// again, waiting longer after every failed attempt
+func (p *supervisedPipeline) restart(backoff *time.Duration) *gst.Pipeline {
+	placeholder, err := p.start(p.placeholder, "")
+	if err != nil {
+		log.Printf("Unable to start placeholder of %s: %v", p.codec, err)
+	}
+	defer func() {
+		if placeholder != nil {
+			placeholder.SetState(gst.StateNull)
+		}
+	}()
+
+	for {
+		log.Printf("Restarting source of %s in %s", p.codec, *backoff)
+		time.Sleep(*backoff)
+		*backoff = nextBackoff(*backoff)
+
+		// Both pipelines write to the tracks, the placeholder has to stop
+		// before the source is back
+		if placeholder != nil {
+			placeholder.SetState(gst.StateNull)
+		}
+
+		// The source has only recovered once it delivers data again
+		pipeline, err := p.start(p.source, p.branches)
+		if err == nil {
+			if err = awaitPlaying(pipeline); err == nil {
+				log.Printf("Source of %s recovered", p.codec)
+				return pipeline
+			}
+			pipeline.SetState(gst.StateNull)
+		}
+
+		log.Printf("Unable to restart source of %s: %v", p.codec, err)
+		if placeholder != nil {
+			if err := placeholder.SetState(gst.StatePlaying); err != nil {
+				log.Println(err)
+			}
+		}
+	}
+}
@@ -0,0 +1,84 @@
+/*
+ Copyright (c) 2024 Neeraj Jakhar
+
+ This program is free software: you can redistribute it and/or modify
+ it under the terms of the GNU General Public License as published by
+ the Free Software Foundation, either version 3 of the License, or
+ (at your option) any later version.
+
+ This program is distributed in the hope that it will be useful,
+ but WITHOUT ANY WARRANTY; without even the implied warranty of
+ MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License
+ along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package media
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-gst/go-gst/gst"
+)
+
+func TestClassifyFault(t *testing.T) {
+	tests := []struct {
+		messageType gst.MessageType
+		live        bool
+		domain      gst.Domain
+		code        gst.ErrorCode
+		fault       pipelineFault
+	}{
+		// An unplugged camera ends the stream or fails to be read
+		{gst.MessageEOS, true, "", 0, faultSource},
+		{gst.MessageError, false, gst.DomainResource, gst.ResourceErrorRead, faultSource},
+		{gst.MessageError, false, gst.DomainResource, gst.ResourceErrorNotFound, faultSource},
+		{gst.MessageError, false, gst.DomainResource, gst.ResourceErrorBusy, faultSource},
+		// Files end for good
+		{gst.MessageEOS, false, "", 0, faultEnded},
+		{gst.MessageError, false, gst.DomainResource, gst.ResourceErrorSettings, faultFatal},
+		{gst.MessageError, false, gst.DomainResource, gst.ResourceErrorNotAuthorized, faultFatal},
+		// Refused formats are reported by sources as stream errors
+		{gst.MessageError, false, gst.DomainStream, gst.StreamErrorFailed, faultFatal},
+		{gst.MessageError, false, gst.DomainStream, gst.StreamErrorFormat, faultFatal},
+		{gst.MessageError, false, gst.DomainCore, gst.CoreErrorNegotiation, faultFatal},
+	}
+
+	for _, test := range tests {
+		if fault := classifyFault(test.messageType, test.live, test.domain, test.code); fault != test.fault {
+			t.Errorf("%d (live %t) %s %d: unexpected fault %d", test.messageType, test.live, test.domain, test.code, fault)
+		}
+	}
+}
+
+func TestNextBackoff(t *testing.T) {
+	backoff := restartInitialBackoff
+	for i := 0; i < 3; i++ {
+		backoff = nextBackoff(backoff)
+	}
+	if backoff != 8*time.Second {
+		t.Errorf("backoff not doubled: %s", backoff)
+	}
+
+	for i := 0; i < 10; i++ {
+		backoff = nextBackoff(backoff)
+	}
+	if backoff != restartMaxBackoff {
+		t.Errorf("backoff not limited: %s", backoff)
+	}
+}
+
+func TestPlaceholderSource(t *testing.T) {
+	conf := testConfiguration()
+
+	if video := placeholderSource(conf, "vp8"); !strings.HasPrefix(video, "videotestsrc pattern=black is-live=true ! video/x-raw, width=640, height=480, framerate=30/1") {
+		t.Errorf("unexpected video placeholder: %s", video)
+	}
+	if audio := placeholderSource(conf, "opus"); !strings.HasPrefix(audio, "audiotestsrc wave=silence") {
+		t.Errorf("audio placeholder is not silent: %s", audio)
+	}
+}
@@ -0,0 +1,64 @@
+/*
+ Copyright (c) 2024 Neeraj Jakhar
+
+ This program is free software: you can redistribute it and/or modify
+ it under the terms of the GNU General Public License as published by
+ the Free Software Foundation, either version 3 of the License, or
+ (at your option) any later version.
+
+ This program is distributed in the hope that it will be useful,
+ but WITHOUT ANY WARRANTY; without even the implied warranty of
+ MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License
+ along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package media
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/homebackend/go-webrtc/pkg/session"
+	"github.com/pion/webrtc/v3"
+)
+
+// Label of the data channel on which viewers report whether they show the
+// stream, e.g. when the browser tab is hidden
+const visibilityLabel = "visibility"
+
+// visibilityLine returns the line telling the parent process about the
+// visibility reported by the viewer
+func visibilityLine(message []byte) (string, error) {
+	var report session.VisibilityReport
+	if err := json.Unmarshal(message, &report); err != nil {
+		return "", err
+	}
+
+	data, err := json.Marshal(report)
+	if err != nil {
+		return "", err
+	}
+
+	return session.VISIBILITY + string(data), nil
+}
+
+// reportVisibility passes the visibility reported on the data channel to the
+// parent process, other data channels are ignored
+func reportVisibility(channel *webrtc.DataChannel) {
+	if channel.Label() != visibilityLabel {
+		log.Printf("Ignoring data channel %s", channel.Label())
+		return
+	}
+
+	channel.OnMessage(func(message webrtc.DataChannelMessage) {
+		if line, err := visibilityLine(message.Data); err != nil {
+			log.Printf("Invalid visibility from viewer: %v", err)
+		} else {
+			fmt.Println(line)
+		}
+	})
+}
@@ -0,0 +1,38 @@
+/*
+ Copyright (c) 2024 Neeraj Jakhar
+
+ This program is free software: you can redistribute it and/or modify
+ it under the terms of the GNU General Public License as published by
+ the Free Software Foundation, either version 3 of the License, or
+ (at your option) any later version.
+
+ This program is distributed in the hope that it will be useful,
+ but WITHOUT ANY WARRANTY; without even the implied warranty of
+ MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License
+ along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package media
+
+import (
+	"testing"
+
+	"github.com/homebackend/go-webrtc/pkg/session"
+)
+
+func TestVisibilityLine(t *testing.T) {
+	line, err := visibilityLine([]byte(`{"visible": false, "other": 1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line != session.VISIBILITY+`{"visible":false}` {
+		t.Errorf("unexpected line: %s", line)
+	}
+
+	if _, err := visibilityLine([]byte("hidden")); err == nil {
+		t.Error("invalid message accepted")
+	}
+}
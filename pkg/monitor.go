@@ -0,0 +1,227 @@
+/*
+ Copyright (c) 2024 Neeraj Jakhar
+
+ This program is free software: you can redistribute it and/or modify
+ it under the terms of the GNU General Public License as published by
+ the Free Software Foundation, either version 3 of the License, or
+ (at your option) any later version.
+
+ This program is distributed in the hope that it will be useful,
+ but WITHOUT ANY WARRANTY; without even the implied warranty of
+ MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License
+ along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bufio"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/homebackend/go-webrtc/pkg/config"
+	"github.com/homebackend/go-webrtc/pkg/session"
+)
+
+const monitorRestartDelay = 10 * time.Second
+
+// Monitor collects the motion and sound reports of all capture processes
+// and turns them into notifications. While the capture devices are not used
+// by anything else, a monitor process is run to watch them.
+type Monitor struct {
+	sync.Mutex
+	configFile string
+	config     *config.Configuration
+	source     session.Source
+	notifier   *Notifier
+	users      int
+	pid        int
+	exited     chan struct{}
+	motion     bool
+	lastMotion time.Time
+	sound      bool
+	loudSince  time.Time
+	lastLoud   time.Time
+	level      float64
+	lastLevel  time.Time
+}
+
+// NewMonitor returns nil if neither motion nor sound detection is
+// configured, all methods can be called on a nil monitor
+func NewMonitor(configFile string, config *config.Configuration, source session.Source, notifier *Notifier) *Monitor {
+	if config.Motion == nil && config.Sound == nil {
+		return nil
+	}
+
+	return &Monitor{
+		configFile: configFile,
+		config:     config,
+		source:     source,
+		notifier:   notifier,
+	}
+}
+
+// Start runs the monitor process unless the capture devices are in use
+func (m *Monitor) Start() {
+	if m == nil {
+		return
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	m.start()
+}
+
+func (m *Monitor) start() {
+	if m.users > 0 || m.pid != 0 {
+		return
+	}
+
+	args := []string{"monitor", "-c", m.configFile}
+	if m.config.Motion != nil {
+		videoSrc, err := m.source.VideoPipeline()
+		if err != nil {
+			log.Printf("Unable to start monitor: %v", err)
+			return
+		}
+		args = append(args, "-v", videoSrc)
+	}
+	if m.config.Sound != nil {
+		audioSrc, err := m.source.AudioPipeline()
+		if err != nil {
+			log.Printf("Unable to start monitor: %v", err)
+			return
+		}
+		args = append(args, "-a", audioSrc)
+	}
+
+	cmd := exec.Command(os.Args[0], args...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	cmd.Stderr = log.Writer()
+	if err := cmd.Start(); err != nil {
+		log.Printf("Unable to start monitor: %v", err)
+		return
+	}
+
+	log.Printf("Started monitor with pid: %d", cmd.Process.Pid)
+	exited := make(chan struct{})
+	m.pid = cmd.Process.Pid
+	m.exited = exited
+
+	go func() {
+		m.Forward(stdout)
+		err := cmd.Wait()
+
+		m.Lock()
+		defer m.Unlock()
+
+		m.pid = 0
+		close(exited)
+		if m.users == 0 {
+			log.Printf("Monitor exited unexpectedly (%v), restarting in %s", err, monitorRestartDelay)
+			time.AfterFunc(monitorRestartDelay, m.Start)
+		}
+	}()
+}
+
+// Pause stops the monitor process and waits for it to release the capture
+// devices. Every Pause has to be followed by a Resume.
+func (m *Monitor) Pause() {
+	if m == nil {
+		return
+	}
+
+	m.Lock()
+	m.users++
+	pid, exited := m.pid, m.exited
+	m.Unlock()
+
+	if pid != 0 {
+		syscall.Kill(pid, syscall.SIGINT)
+		<-exited
+	}
+}
+
+// Resume restarts the monitor process once the capture devices are not in
+// use anymore
+func (m *Monitor) Resume() {
+	if m == nil {
+		return
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	m.users--
+	m.start()
+}
+
+// Forward reads the output of a capture process, reports are handled and
+// everything else is logged
+func (m *Monitor) Forward(r io.Reader) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !m.HandleReport(line) {
+			log.Println(line)
+		}
+	}
+}
+
+// HandleReport handles a line printed by a capture process if it is a
+// motion or sound report and returns whether it was one
+func (m *Monitor) HandleReport(line string) bool {
+	switch {
+	case strings.HasPrefix(line, session.MOTION):
+		if m != nil {
+			m.handleMotionReport(line[len(session.MOTION):])
+		}
+	case strings.HasPrefix(line, session.LEVEL):
+		if m != nil {
+			m.handleLevelReport(line[len(session.LEVEL):])
+		}
+	default:
+		return false
+	}
+
+	return true
+}
+
+// MonitorStats is the current state of the monitor. The audio level is
+// only set while some process is capturing audio.
+type MonitorStats struct {
+	Motion     bool     `json:"motion"`
+	Sound      bool     `json:"sound"`
+	AudioLevel *float64 `json:"audio_level"`
+}
+
+func (m *Monitor) Stats() MonitorStats {
+	if m == nil {
+		return MonitorStats{}
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	stats := MonitorStats{Motion: m.motion, Sound: m.sound}
+	if time.Since(m.lastLevel) < levelStaleAfter {
+		level := m.level
+		stats.AudioLevel = &level
+	}
+
+	return stats
+}
@@ -0,0 +1,125 @@
+/*
+ Copyright (c) 2024 Neeraj Jakhar
+
+ This program is free software: you can redistribute it and/or modify
+ it under the terms of the GNU General Public License as published by
+ the Free Software Foundation, either version 3 of the License, or
+ (at your option) any later version.
+
+ This program is distributed in the hope that it will be useful,
+ but WITHOUT ANY WARRANTY; without even the implied warranty of
+ MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License
+ along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/homebackend/go-webrtc/pkg/config"
+	"github.com/homebackend/go-webrtc/pkg/session"
+)
+
+const DefaultMotionCooldown = 10
+
+func (m *Monitor) motionCooldown() time.Duration {
+	if m.config.Motion.Cooldown == 0 {
+		return DefaultMotionCooldown * time.Second
+	}
+
+	return time.Duration(m.config.Motion.Cooldown) * time.Second
+}
+
+// handleMotionReport starts motion, which then lasts until no motion has
+// been reported for the cooldown period
+func (m *Monitor) handleMotionReport(report string) {
+	var motionReport session.MotionReport
+	if err := json.Unmarshal([]byte(report), &motionReport); err != nil {
+		log.Printf("Invalid motion report from child: %v", err)
+		return
+	}
+
+	m.Lock()
+	m.lastMotion = time.Now()
+	started := !m.motion
+	m.motion = true
+	m.Unlock()
+
+	if started {
+		time.AfterFunc(m.motionCooldown(), m.checkMotionEnded)
+		m.notifier.Notify(NotificationMotionStarted, map[string]interface{}{"score": motionReport.Score})
+	}
+}
+
+func (m *Monitor) checkMotionEnded() {
+	m.Lock()
+	remaining := m.motionCooldown() - time.Since(m.lastMotion)
+	if remaining > 0 {
+		m.Unlock()
+		time.AfterFunc(remaining, m.checkMotionEnded)
+		return
+	}
+
+	m.motion = false
+	m.Unlock()
+
+	m.notifier.Notify(NotificationMotionStopped, nil)
+}
+
+// SetupMotionRecording records while motion is detected if configured. A
+// recording started by motion is stopped once motion ends, recordings
+// started otherwise are left alone.
+func SetupMotionRecording(config *config.Configuration, notifier *Notifier, recorder *Recorder) {
+	if config.Motion == nil || !config.Motion.Record {
+		return
+	}
+
+	var lock sync.Mutex
+	streaming := false
+	recording := false
+
+	notifier.Subscribe(func(notification Notification) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		switch notification.Type {
+		case NotificationStreamingStarted:
+			streaming = true
+		case NotificationStreamingStopped:
+			streaming = false
+		case NotificationMotionStarted:
+			// The video device cannot be shared with a running stream
+			if streaming || recording {
+				return
+			}
+
+			// Starting the recording waits for the monitor process, which
+			// may be the one reporting motion
+			recording = true
+			go func() {
+				if _, err := recorder.StartRecording(); err != nil {
+					log.Printf("Unable to record motion: %v", err)
+					lock.Lock()
+					recording = false
+					lock.Unlock()
+				}
+			}()
+		case NotificationMotionStopped:
+			if recording {
+				recording = false
+				go func() {
+					if err := recorder.StopRecording(); err != nil {
+						log.Printf("Unable to stop motion recording: %v", err)
+					}
+				}()
+			}
+		}
+	})
+}
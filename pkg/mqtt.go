@@ -0,0 +1,428 @@
+/*
+ Copyright (c) 2024 Neeraj Jakhar
+
+ This program is free software: you can redistribute it and/or modify
+ it under the terms of the GNU General Public License as published by
+ the Free Software Foundation, either version 3 of the License, or
+ (at your option) any later version.
+
+ This program is distributed in the hope that it will be useful,
+ but WITHOUT ANY WARRANTY; without even the implied warranty of
+ MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License
+ along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/homebackend/go-webrtc/pkg/config"
+	"github.com/homebackend/go-webrtc/pkg/signalling"
+)
+
+const (
+	DefaultMqttClientId    = "gowebrtc"
+	DefaultMqttTopicPrefix = "gowebrtc"
+	mqttQos                = 1
+	mqttConnectRetry       = 10 * time.Second
+)
+
+const (
+	MqttStatusOnline  = "online"
+	MqttStatusOffline = "offline"
+	MqttStateOn       = "ON"
+	MqttStateOff      = "OFF"
+)
+
+const (
+	CommandStartRecording = "start_recording"
+	CommandStopRecording  = "stop_recording"
+	CommandSnapshot       = "snapshot"
+)
+
+type MqttCommand struct {
+	Id      string `json:"id,omitempty"`
+	Command string `json:"command" validate:"required,oneof=start_recording stop_recording snapshot"`
+}
+
+type MqttCommandResult struct {
+	Id      string `json:"id,omitempty"`
+	Command string `json:"command"`
+	Success bool   `json:"success"`
+	Message string `json:"message,omitempty"`
+}
+
+// MqttBridge connects the service to an MQTT broker. It publishes device
+// status and notifications, accepts commands and, if enabled, carries
+// signalling sessions using the same events as the websocket.
+type MqttBridge struct {
+	sync.Mutex
+	config   *config.Configuration
+	settings *config.Settings
+	manager  *Manager
+	notifier *Notifier
+	recorder *Recorder
+	client   mqtt.Client
+	sessions map[string]*mqttSession
+	// Whether somebody is streaming, snapshots are skipped meanwhile
+	streaming bool
+}
+
+type mqttSession struct {
+	client  *Client
+	ingress chan signalling.Event
+}
+
+func NewMqttBridge(config *config.Configuration, settings *config.Settings, manager *Manager, notifier *Notifier, recorder *Recorder) *MqttBridge {
+	return &MqttBridge{
+		config:   config,
+		settings: settings,
+		manager:  manager,
+		notifier: notifier,
+		recorder: recorder,
+		sessions: make(map[string]*mqttSession),
+	}
+}
+
+func (b *MqttBridge) topic(parts ...string) string {
+	prefix := b.config.Mqtt.TopicPrefix
+	if prefix == "" {
+		prefix = DefaultMqttTopicPrefix
+	}
+
+	return strings.Join(append([]string{prefix}, parts...), "/")
+}
+
+// Connect starts connecting to the broker. Connection failures are retried
+// in the background.
+func (b *MqttBridge) clientId() string {
+	if b.config.Mqtt.ClientId == "" {
+		return DefaultMqttClientId
+	}
+
+	return b.config.Mqtt.ClientId
+}
+
+func (b *MqttBridge) Connect() {
+	opts := mqtt.NewClientOptions().
+		AddBroker(b.config.Mqtt.Broker).
+		SetClientID(b.clientId()).
+		SetUsername(b.config.Mqtt.User).
+		SetPassword(b.config.Mqtt.Password).
+		SetWill(b.topic("status"), MqttStatusOffline, mqttQos, true).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetConnectRetryInterval(mqttConnectRetry).
+		SetOnConnectHandler(b.onConnect).
+		SetConnectionLostHandler(func(client mqtt.Client, err error) {
+			log.Printf("MQTT connection lost: %v", err)
+		})
+
+	b.client = mqtt.NewClient(opts)
+	b.notifier.Subscribe(b.publishNotification)
+
+	log.Printf("Connecting to MQTT broker: %s", b.config.Mqtt.Broker)
+	token := b.client.Connect()
+	go func() {
+		if token.Wait() && token.Error() != nil {
+			log.Printf("MQTT connection failed: %v", token.Error())
+		}
+	}()
+
+	if b.config.Mqtt.SnapshotInterval > 0 {
+		go b.publishSnapshots(time.Duration(b.config.Mqtt.SnapshotInterval) * time.Second)
+	}
+}
+
+// onConnect is called on every (re)connection, subscriptions are set up
+// here as they do not survive a clean session
+func (b *MqttBridge) onConnect(client mqtt.Client) {
+	log.Println("Connected to MQTT broker")
+	b.publish(b.topic("status"), true, MqttStatusOnline)
+	b.publish(b.topic("recording"), true, onOff(b.recorder.IsRecording()))
+	b.publish(b.topic("audio"), true, onOff(b.settings.AudioEnabled()))
+
+	b.subscribe(b.topic("command"), b.onCommand)
+	b.subscribe(b.topic("recording", "set"), b.onRecordingSet)
+	b.subscribe(b.topic("audio", "set"), b.onAudioSet)
+	if b.config.Overlay != nil {
+		b.publish(b.topic("overlay"), true, b.settings.OverlayText())
+		b.subscribe(b.topic("overlay", "set"), b.onOverlaySet)
+	}
+	if b.config.Mqtt.Signalling && b.manager != nil {
+		b.subscribe(b.topic("session", "+", "request"), b.onSessionRequest)
+	}
+
+	if b.config.Mqtt.HomeAssistant {
+		b.publish(b.topic("motion"), true, MqttStateOff)
+		b.publish(b.topic("sound"), true, MqttStateOff)
+		b.publishDiscovery()
+		// Home Assistant announces restarts, discovery has to be repeated then
+		b.subscribe(b.discoveryPrefix()+"/status", b.onHomeAssistantStatus)
+	}
+}
+
+func (b *MqttBridge) subscribe(topic string, handler mqtt.MessageHandler) {
+	token := b.client.Subscribe(topic, mqttQos, handler)
+	go func() {
+		if token.Wait() && token.Error() != nil {
+			log.Printf("MQTT subscription to %s failed: %v", topic, token.Error())
+		}
+	}()
+}
+
+func (b *MqttBridge) publish(topic string, retained bool, payload interface{}) {
+	token := b.client.Publish(topic, mqttQos, retained, payload)
+	go func() {
+		if token.Wait() && token.Error() != nil {
+			log.Printf("MQTT publish to %s failed: %v", topic, token.Error())
+		}
+	}()
+}
+
+func (b *MqttBridge) publishJSON(topic string, retained bool, payload interface{}) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	b.publish(topic, retained, data)
+}
+
+func onOff(on bool) string {
+	if on {
+		return MqttStateOn
+	}
+
+	return MqttStateOff
+}
+
+func (b *MqttBridge) publishNotification(notification Notification) {
+	b.publishJSON(b.topic("event"), false, notification)
+
+	switch notification.Type {
+	case NotificationStreamingStarted:
+		b.setStreaming(true)
+		b.publish(b.topic("streaming"), true, MqttStateOn)
+	case NotificationStreamingStopped:
+		b.setStreaming(false)
+		b.publish(b.topic("streaming"), true, MqttStateOff)
+	case NotificationRecordingStarted:
+		b.publish(b.topic("recording"), true, MqttStateOn)
+	case NotificationRecordingFinished:
+		b.publish(b.topic("recording"), true, MqttStateOff)
+	case NotificationMotionStarted:
+		b.publish(b.topic("motion"), true, MqttStateOn)
+	case NotificationMotionStopped:
+		b.publish(b.topic("motion"), true, MqttStateOff)
+	case NotificationSoundStarted:
+		b.publish(b.topic("sound"), true, MqttStateOn)
+	case NotificationSoundStopped:
+		b.publish(b.topic("sound"), true, MqttStateOff)
+	}
+}
+
+func (b *MqttBridge) setStreaming(streaming bool) {
+	b.Lock()
+	defer b.Unlock()
+
+	b.streaming = streaming
+}
+
+func (b *MqttBridge) isStreaming() bool {
+	b.Lock()
+	defer b.Unlock()
+
+	return b.streaming
+}
+
+// publishSnapshots periodically publishes a snapshot while the devices are
+// not in use, so that the snapshot topic shows a recent image
+func (b *MqttBridge) publishSnapshots(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if !b.client.IsConnected() || b.isStreaming() || b.recorder.IsRecording() {
+			continue
+		}
+
+		if image, err := b.recorder.Snapshot(); err != nil {
+			log.Println(err)
+		} else {
+			b.publish(b.topic("snapshot"), true, image)
+		}
+	}
+}
+
+// onRecordingSet handles the ON and OFF payloads of the recording switch
+func (b *MqttBridge) onRecordingSet(client mqtt.Client, msg mqtt.Message) {
+	switch string(msg.Payload()) {
+	case MqttStateOn:
+		go b.handleCommand(MqttCommand{Command: CommandStartRecording})
+	case MqttStateOff:
+		go b.handleCommand(MqttCommand{Command: CommandStopRecording})
+	default:
+		log.Printf("Invalid recording state: %s", msg.Payload())
+	}
+}
+
+// onAudioSet handles the ON and OFF payloads of the audio switch. The change
+// takes effect for streams and recordings started afterwards.
+func (b *MqttBridge) onAudioSet(client mqtt.Client, msg mqtt.Message) {
+	switch string(msg.Payload()) {
+	case MqttStateOn:
+		b.settings.SetAudioEnabled(true)
+	case MqttStateOff:
+		b.settings.SetAudioEnabled(false)
+	default:
+		log.Printf("Invalid audio state: %s", msg.Payload())
+		return
+	}
+
+	log.Printf("Audio enabled: %t", b.settings.AudioEnabled())
+	b.publish(b.topic("audio"), true, onOff(b.settings.AudioEnabled()))
+}
+
+// onOverlaySet sets the overlay text of running and future captures
+func (b *MqttBridge) onOverlaySet(client mqtt.Client, msg mqtt.Message) {
+	text := string(msg.Payload())
+	if len(text) > maxOverlayText {
+		log.Printf("Overlay text is longer than %d characters", maxOverlayText)
+		return
+	}
+
+	log.Printf("Overlay text set to: %s", text)
+	b.settings.SetOverlayText(text)
+	b.publish(b.topic("overlay"), true, text)
+}
+
+func (b *MqttBridge) onCommand(client mqtt.Client, msg mqtt.Message) {
+	var command MqttCommand
+	if err := json.Unmarshal(msg.Payload(), &command); err != nil {
+		b.publishJSON(b.topic("command", "result"), false, MqttCommandResult{Success: false, Message: err.Error()})
+		return
+	}
+
+	// Commands such as snapshot take a while, do not hold up other messages
+	go b.handleCommand(command)
+}
+
+func (b *MqttBridge) handleCommand(command MqttCommand) {
+	log.Printf("MQTT command: %s", command.Command)
+	result := MqttCommandResult{Id: command.Id, Command: command.Command, Success: true}
+
+	var err error
+	if err = validate.Struct(command); err == nil {
+		switch command.Command {
+		case CommandStartRecording:
+			var file string
+			if file, err = b.recorder.StartRecording(); err == nil {
+				result.Message = file
+			}
+		case CommandStopRecording:
+			err = b.recorder.StopRecording()
+		case CommandSnapshot:
+			var image []byte
+			if image, err = b.recorder.Snapshot(); err == nil {
+				b.publish(b.topic("snapshot"), true, image)
+			}
+		}
+	}
+
+	if err != nil {
+		log.Printf("MQTT command %s failed: %v", command.Command, err)
+		result.Success = false
+		result.Message = err.Error()
+	}
+
+	b.publishJSON(b.topic("command", "result"), false, result)
+}
+
+// onSessionRequest receives the client events of a signalling session on
+// <prefix>/session/<session>/request, the session id is chosen by the client
+func (b *MqttBridge) onSessionRequest(client mqtt.Client, msg mqtt.Message) {
+	parts := strings.Split(msg.Topic(), "/")
+	sessionId := parts[len(parts)-2]
+
+	var request signalling.Event
+	if err := json.Unmarshal(msg.Payload(), &request); err != nil {
+		log.Printf("Error processing event: %v", err)
+		b.publishSessionEvent(sessionId, GetErrorEvent(&signalling.ProtocolError{Code: signalling.ErrorCodeMalformedMessage, Message: err.Error()}))
+		return
+	}
+
+	session := b.getSession(sessionId)
+	select {
+	case session.ingress <- request:
+	default:
+		errorEvent := GetErrorEvent(&signalling.ProtocolError{Code: signalling.ErrorCodeInternal, Message: "too many pending events"})
+		b.publishSessionEvent(sessionId, errorEvent.ReplyTo(request))
+	}
+}
+
+func (b *MqttBridge) publishSessionEvent(sessionId string, event signalling.Event) {
+	b.publishJSON(b.topic("session", sessionId, "event"), false, event)
+}
+
+// getSession returns the session with the id, creating it if required. Each
+// session is a client of the manager, like a websocket connection.
+func (b *MqttBridge) getSession(sessionId string) *mqttSession {
+	b.Lock()
+	defer b.Unlock()
+
+	if session, ok := b.sessions[sessionId]; ok {
+		return session
+	}
+
+	log.Printf("New MQTT session: %s", sessionId)
+	session := &mqttSession{
+		client:  NewClient(nil, "mqtt:"+sessionId, b.manager),
+		ingress: make(chan signalling.Event, pendingCandidates),
+	}
+	b.sessions[sessionId] = session
+	b.manager.addClient(session.client)
+
+	go func() {
+		for {
+			select {
+			case event := <-session.client.egress:
+				b.publishSessionEvent(sessionId, event)
+			case <-session.client.done:
+				log.Printf("MQTT session ended: %s", sessionId)
+				b.removeSession(sessionId)
+				return
+			}
+		}
+	}()
+
+	go func() {
+		for {
+			select {
+			case event := <-session.ingress:
+				b.manager.handleRequest(event, session.client)
+			case <-session.client.done:
+				return
+			}
+		}
+	}()
+
+	return session
+}
+
+func (b *MqttBridge) removeSession(sessionId string) {
+	b.Lock()
+	defer b.Unlock()
+
+	delete(b.sessions, sessionId)
+}
@@ -0,0 +1,596 @@
+/*
+ Copyright (c) 2024 Neeraj Jakhar
+
+ This program is free software: you can redistribute it and/or modify
+ it under the terms of the GNU General Public License as published by
+ the Free Software Foundation, either version 3 of the License, or
+ (at your option) any later version.
+
+ This program is distributed in the hope that it will be useful,
+ but WITHOUT ANY WARRANTY; without even the implied warranty of
+ MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License
+ along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/homebackend/go-webrtc/pkg/config"
+	"github.com/homebackend/go-webrtc/pkg/session"
+	"github.com/homebackend/go-webrtc/pkg/signalling"
+)
+
+// MQTT control packet types
+const (
+	mqttConnect     = 1
+	mqttConnack     = 2
+	mqttPublish     = 3
+	mqttPuback      = 4
+	mqttSubscribe   = 8
+	mqttSuback      = 9
+	mqttUnsubscribe = 10
+	mqttUnsuback    = 11
+	mqttPingreq     = 12
+	mqttPingresp    = 13
+	mqttDisconnect  = 14
+)
+
+// testBroker is a minimal MQTT 3.1.1 broker. It supports what the bridge
+// uses: retained messages, wildcard subscriptions and QoS 1 publishing.
+// Messages are delivered with QoS 0.
+type testBroker struct {
+	sync.Mutex
+	listener      net.Listener
+	subscriptions map[*brokerConnection][]string
+	retained      map[string][]byte
+}
+
+type brokerConnection struct {
+	sync.Mutex
+	conn net.Conn
+}
+
+func (c *brokerConnection) write(header byte, body []byte) error {
+	c.Lock()
+	defer c.Unlock()
+
+	packet := []byte{header}
+	length := len(body)
+	for {
+		b := byte(length % 128)
+		length /= 128
+		if length > 0 {
+			b |= 128
+		}
+		packet = append(packet, b)
+		if length == 0 {
+			break
+		}
+	}
+
+	_, err := c.conn.Write(append(packet, body...))
+	return err
+}
+
+func startTestBroker(t *testing.T) *testBroker {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := &testBroker{
+		listener:      listener,
+		subscriptions: make(map[*brokerConnection][]string),
+		retained:      make(map[string][]byte),
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go b.serve(&brokerConnection{conn: conn})
+		}
+	}()
+
+	return b
+}
+
+func (b *testBroker) url() string {
+	return "tcp://" + b.listener.Addr().String()
+}
+
+func readPacket(r *bufio.Reader) (byte, []byte, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	length, multiplier := 0, 1
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		length += int(b&127) * multiplier
+		multiplier *= 128
+		if b&128 == 0 {
+			break
+		}
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+
+	return header, body, nil
+}
+
+func readString(data []byte) (string, []byte) {
+	length := binary.BigEndian.Uint16(data)
+	return string(data[2 : 2+length]), data[2+length:]
+}
+
+func encodeString(s string) []byte {
+	return append(binary.BigEndian.AppendUint16(nil, uint16(len(s))), s...)
+}
+
+func (b *testBroker) serve(c *brokerConnection) {
+	defer func() {
+		b.Lock()
+		delete(b.subscriptions, c)
+		b.Unlock()
+		c.conn.Close()
+	}()
+
+	r := bufio.NewReader(c.conn)
+	for {
+		header, body, err := readPacket(r)
+		if err != nil {
+			return
+		}
+
+		switch header >> 4 {
+		case mqttConnect:
+			c.write(mqttConnack<<4, []byte{0, 0})
+		case mqttPublish:
+			qos := (header >> 1) & 3
+			topic, rest := readString(body)
+			if qos > 0 {
+				c.write(mqttPuback<<4, rest[:2])
+				rest = rest[2:]
+			}
+			b.publish(topic, rest, header&1 == 1)
+		case mqttSubscribe:
+			id, rest := body[:2], body[2:]
+			var topics []string
+			for len(rest) > 0 {
+				var topic string
+				topic, rest = readString(rest)
+				// Requested QoS, QoS 0 is granted
+				rest = rest[1:]
+				topics = append(topics, topic)
+			}
+
+			b.Lock()
+			b.subscriptions[c] = append(b.subscriptions[c], topics...)
+			b.Unlock()
+
+			c.write(mqttSuback<<4, append(id, make([]byte, len(topics))...))
+			b.sendRetained(c, topics)
+		case mqttUnsubscribe:
+			c.write(mqttUnsuback<<4, body[:2])
+		case mqttPingreq:
+			c.write(mqttPingresp<<4, nil)
+		case mqttDisconnect:
+			return
+		}
+	}
+}
+
+func (b *testBroker) publish(topic string, payload []byte, retain bool) {
+	b.Lock()
+	if retain {
+		if len(payload) == 0 {
+			delete(b.retained, topic)
+		} else {
+			b.retained[topic] = payload
+		}
+	}
+
+	var receivers []*brokerConnection
+	for c, filters := range b.subscriptions {
+		for _, filter := range filters {
+			if topicMatches(filter, topic) {
+				receivers = append(receivers, c)
+				break
+			}
+		}
+	}
+	b.Unlock()
+
+	packet := append(encodeString(topic), payload...)
+	for _, c := range receivers {
+		c.write(mqttPublish<<4, packet)
+	}
+}
+
+func (b *testBroker) sendRetained(c *brokerConnection, filters []string) {
+	b.Lock()
+	defer b.Unlock()
+
+	for topic, payload := range b.retained {
+		for _, filter := range filters {
+			if topicMatches(filter, topic) {
+				c.write(mqttPublish<<4|1, append(encodeString(topic), payload...))
+				break
+			}
+		}
+	}
+}
+
+func topicMatches(filter, topic string) bool {
+	filterParts := strings.Split(filter, "/")
+	topicParts := strings.Split(topic, "/")
+	for i, part := range filterParts {
+		if part == "#" {
+			return true
+		}
+		if i >= len(topicParts) || (part != "+" && part != topicParts[i]) {
+			return false
+		}
+	}
+
+	return len(filterParts) == len(topicParts)
+}
+
+// testSessions is a SessionManager answering every stream request
+type testSessions struct {
+	sync.Mutex
+	streaming bool
+	viewers   []session.Viewer
+	stopped   chan string
+	// Leased while streaming, like the streamer does
+	devices *session.DeviceLease
+	// Refuse further sessions, as if the slots were taken
+	refuse bool
+}
+
+func newTestSessions() *testSessions {
+	return &testSessions{stopped: make(chan string, 10), devices: session.NewDeviceLease()}
+}
+
+func (s *testSessions) Stream(viewer session.Viewer, sdp string, remoteCandidates <-chan string, handlers session.Handlers) {
+	s.Lock()
+	refuse := s.refuse
+	if !refuse {
+		s.lease(true)
+		s.viewers = append(s.viewers, viewer)
+	}
+	s.Unlock()
+
+	if refuse {
+		handlers.Error("refused")
+		return
+	}
+
+	handlers.Answer("answer-" + sdp)
+	handlers.State(signalling.StateConnected, "")
+}
+
+func (s *testSessions) Stop() bool {
+	return false
+}
+
+func (s *testSessions) StopSession(id string, reason string) bool {
+	s.Lock()
+	s.lease(false)
+	s.Unlock()
+
+	s.stopped <- id
+	return true
+}
+
+func (s *testSessions) SetVisible(id string, visible bool) bool {
+	return false
+}
+
+func (s *testSessions) Streaming() bool {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.streaming
+}
+
+func (s *testSessions) Sessions() []*session.Session {
+	return nil
+}
+
+func (s *testSessions) setStreaming(streaming bool) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.lease(streaming)
+}
+
+// lease acquires or releases the devices as the streaming state changes.
+// Called with the lock held.
+func (s *testSessions) lease(streaming bool) {
+	if streaming && !s.streaming {
+		s.devices.Acquire(session.UseStreaming)
+	} else if !streaming && s.streaming {
+		s.devices.Release(session.UseStreaming)
+	}
+	s.streaming = streaming
+}
+
+// mqttTestClient watches everything published to the broker
+type mqttTestClient struct {
+	client   mqtt.Client
+	messages chan mqtt.Message
+}
+
+func connectTestClient(t *testing.T, broker *testBroker) *mqttTestClient {
+	c := &mqttTestClient{messages: make(chan mqtt.Message, 100)}
+	c.client = mqtt.NewClient(mqtt.NewClientOptions().AddBroker(broker.url()).SetClientID("test"))
+	if token := c.client.Connect(); token.Wait() && token.Error() != nil {
+		t.Fatal(token.Error())
+	}
+	t.Cleanup(func() { c.client.Disconnect(0) })
+
+	token := c.client.Subscribe("#", 0, func(client mqtt.Client, msg mqtt.Message) {
+		c.messages <- msg
+	})
+	if token.Wait() && token.Error() != nil {
+		t.Fatal(token.Error())
+	}
+
+	return c
+}
+
+func (c *mqttTestClient) publish(t *testing.T, topic string, payload string) {
+	if token := c.client.Publish(topic, 1, false, payload); token.Wait() && token.Error() != nil {
+		t.Fatal(token.Error())
+	}
+}
+
+// await returns the next payload published to the topic, other messages
+// are skipped
+func (c *mqttTestClient) await(t *testing.T, topic string) []byte {
+	t.Helper()
+
+	timeout := time.After(eventTimeout)
+	for {
+		select {
+		case msg := <-c.messages:
+			if msg.Topic() == topic {
+				return msg.Payload()
+			}
+		case <-timeout:
+			t.Fatalf("Nothing published to %s", topic)
+		}
+	}
+}
+
+func (c *mqttTestClient) expect(t *testing.T, topic string, want string) {
+	t.Helper()
+
+	if payload := string(c.await(t, topic)); payload != want {
+		t.Fatalf("Expected %s on %s, got %s", want, topic, payload)
+	}
+}
+
+func (c *mqttTestClient) awaitCommandResult(t *testing.T) MqttCommandResult {
+	t.Helper()
+
+	var result MqttCommandResult
+	if err := json.Unmarshal(c.await(t, "gowebrtc/command/result"), &result); err != nil {
+		t.Fatal(err)
+	}
+
+	return result
+}
+
+func (c *mqttTestClient) awaitSessionEvent(t *testing.T, sessionId string) signalling.Event {
+	t.Helper()
+
+	var event signalling.Event
+	if err := json.Unmarshal(c.await(t, "gowebrtc/session/"+sessionId+"/event"), &event); err != nil {
+		t.Fatal(err)
+	}
+
+	return event
+}
+
+func startTestBridge(t *testing.T, configuration *config.Configuration, sessions *testSessions) (*mqttTestClient, *Notifier, *config.Settings) {
+	broker := startTestBroker(t)
+	client := connectTestClient(t, broker)
+
+	configuration.Url = "/ws"
+	if configuration.Mqtt == nil {
+		configuration.Mqtt = &config.MqttConfiguration{Signalling: true}
+	}
+	configuration.Mqtt.Broker = broker.url()
+	notifier := NewNotifier()
+	manager := NewManager(context.Background(), configuration, notifier, nil, sessions)
+	go manager.processConnection()
+
+	settings := config.NewSettings(configuration)
+	recorder := NewRecorder("", configuration, settings, nil, notifier, nil, sessions.devices)
+	bridge := NewMqttBridge(configuration, settings, manager, notifier, recorder)
+	bridge.Connect()
+	t.Cleanup(func() { bridge.client.Disconnect(0) })
+
+	client.expect(t, "gowebrtc/status", MqttStatusOnline)
+	return client, notifier, settings
+}
+
+func TestMqttStatusTopics(t *testing.T) {
+	client, notifier, settings := startTestBridge(t, &config.Configuration{}, newTestSessions())
+
+	client.expect(t, "gowebrtc/recording", MqttStateOff)
+	client.expect(t, "gowebrtc/audio", MqttStateOn)
+
+	// Notifications are published as events before the status changes
+	notifier.Notify(NotificationStreamingStarted, nil)
+	var notification Notification
+	if err := json.Unmarshal(client.await(t, "gowebrtc/event"), &notification); err != nil {
+		t.Fatal(err)
+	}
+	if notification.Type != NotificationStreamingStarted {
+		t.Fatalf("Unexpected notification: %s", notification.Type)
+	}
+	client.expect(t, "gowebrtc/streaming", MqttStateOn)
+
+	notifier.Notify(NotificationMotionStarted, nil)
+	client.expect(t, "gowebrtc/motion", MqttStateOn)
+	notifier.Notify(NotificationMotionStopped, nil)
+	client.expect(t, "gowebrtc/motion", MqttStateOff)
+
+	client.publish(t, "gowebrtc/audio/set", MqttStateOff)
+	client.expect(t, "gowebrtc/audio", MqttStateOff)
+	if settings.AudioEnabled() {
+		t.Fatal("Audio is still enabled")
+	}
+
+	// Invalid states are ignored
+	client.publish(t, "gowebrtc/audio/set", "maybe")
+	client.publish(t, "gowebrtc/audio/set", MqttStateOn)
+	client.expect(t, "gowebrtc/audio", MqttStateOn)
+}
+
+func TestMqttCommands(t *testing.T) {
+	sessions := newTestSessions()
+	client, _, _ := startTestBridge(t, &config.Configuration{}, sessions)
+
+	client.publish(t, "gowebrtc/command", "not json")
+	if result := client.awaitCommandResult(t); result.Success {
+		t.Fatal("Malformed command succeeded")
+	}
+
+	client.publish(t, "gowebrtc/command", `{"id": "1", "command": "reboot"}`)
+	if result := client.awaitCommandResult(t); result.Success || result.Id != "1" {
+		t.Fatalf("Unexpected result of unknown command: %+v", result)
+	}
+
+	client.publish(t, "gowebrtc/command", `{"id": "2", "command": "stop_recording"}`)
+	result := client.awaitCommandResult(t)
+	if result.Success || result.Id != "2" || result.Message != ErrNotRecording.Error() {
+		t.Fatalf("Unexpected result of stop_recording: %+v", result)
+	}
+
+	// The devices are not shared with a running session
+	sessions.setStreaming(true)
+	for _, command := range []string{CommandStartRecording, CommandSnapshot} {
+		client.publish(t, "gowebrtc/command", `{"id": "3", "command": "`+command+`"}`)
+		result := client.awaitCommandResult(t)
+		if result.Success || result.Command != command || result.Message != ErrDevicesInUse.Error() {
+			t.Fatalf("Unexpected result of %s while streaming: %+v", command, result)
+		}
+	}
+
+	// The recording switch runs the same commands
+	client.publish(t, "gowebrtc/recording/set", MqttStateOn)
+	result = client.awaitCommandResult(t)
+	if result.Success || result.Command != CommandStartRecording || result.Message != ErrDevicesInUse.Error() {
+		t.Fatalf("Unexpected result of recording switch: %+v", result)
+	}
+}
+
+func TestMqttSessionSignalling(t *testing.T) {
+	sessions := newTestSessions()
+	client, _, _ := startTestBridge(t, &config.Configuration{}, sessions)
+
+	client.publish(t, "gowebrtc/session/s1/request", `{"id": "0", "type": "candidate", "payload": {}}`)
+	event := client.awaitSessionEvent(t, "s1")
+	if event.Type != signalling.EventError || event.Id != "0" {
+		t.Fatalf("Expected error for unauthorized session, got %+v", event)
+	}
+
+	client.publish(t, "gowebrtc/session/s1/request", `{"id": "1", "type": "connect", "payload": {"sdp": "b2ZmZXI="}}`)
+	event = client.awaitSessionEvent(t, "s1")
+	if event.Type != signalling.EventConnectAck || event.Id != "1" {
+		t.Fatalf("Expected connect_ack, got %+v", event)
+	}
+
+	event = client.awaitSessionEvent(t, "s1")
+	var answer signalling.AnswerEvent
+	if err := signalling.DecodePayload(event, &answer); event.Type != signalling.EventAnswer || event.Id != "1" || err != nil {
+		t.Fatalf("Expected answer, got %+v (%v)", event, err)
+	}
+	if answer.Answer != "answer-b2ZmZXI=" {
+		t.Fatalf("Unexpected answer: %s", answer.Answer)
+	}
+
+	event = client.awaitSessionEvent(t, "s1")
+	if event.Type != signalling.EventState {
+		t.Fatalf("Expected state, got %+v", event)
+	}
+
+	client.publish(t, "gowebrtc/session/s1/request", `{"type": "disconnect"}`)
+	select {
+	case <-sessions.stopped:
+	case <-time.After(eventTimeout):
+		t.Fatal("Session was not stopped on disconnect")
+	}
+}
+
+func TestMqttViewerNotifications(t *testing.T) {
+	sessions := newTestSessions()
+	client, notifier, _ := startTestBridge(t, &config.Configuration{}, sessions)
+	notifications := make(chan Notification, 10)
+	notifier.Subscribe(func(notification Notification) {
+		if notification.Type == NotificationViewerConnected || notification.Type == NotificationViewerDisconnected {
+			notifications <- notification
+		}
+	})
+
+	expect := func(notificationType string) {
+		t.Helper()
+		select {
+		case notification := <-notifications:
+			if notification.Type != notificationType {
+				t.Fatalf("Expected %s, got %s", notificationType, notification.Type)
+			}
+		case <-time.After(eventTimeout):
+			t.Fatalf("Timed out waiting for %s", notificationType)
+		}
+	}
+
+	client.publish(t, "gowebrtc/session/s1/request", `{"id": "1", "type": "connect", "payload": {"sdp": "b2ZmZXI="}}`)
+	expect(NotificationViewerConnected)
+	client.publish(t, "gowebrtc/session/s1/request", `{"type": "disconnect"}`)
+	expect(NotificationViewerDisconnected)
+
+	// Refused viewers were never connected
+	sessions.Lock()
+	sessions.refuse = true
+	sessions.Unlock()
+	client.publish(t, "gowebrtc/session/s2/request", `{"id": "1", "type": "connect", "payload": {"sdp": "b2ZmZXI="}}`)
+	// The failed state is sent before the client is removed
+	for client.awaitSessionEvent(t, "s2").Type != signalling.EventState {
+	}
+	select {
+	case notification := <-notifications:
+		t.Fatalf("Unexpected notification for a refused viewer: %s", notification.Type)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
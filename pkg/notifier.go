@@ -0,0 +1,88 @@
+/*
+ Copyright (c) 2024 Neeraj Jakhar
+
+ This program is free software: you can redistribute it and/or modify
+ it under the terms of the GNU General Public License as published by
+ the Free Software Foundation, either version 3 of the License, or
+ (at your option) any later version.
+
+ This program is distributed in the hope that it will be useful,
+ but WITHOUT ANY WARRANTY; without even the implied warranty of
+ MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License
+ along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	NotificationViewerConnected    = "viewer_connected"
+	NotificationViewerDisconnected = "viewer_disconnected"
+	NotificationAuthFailure        = "auth_failure"
+	NotificationPipelineFailure    = "pipeline_failure"
+	NotificationDeviceLost         = "device_lost"
+	NotificationDeviceRecovered    = "device_recovered"
+	NotificationStreamingStarted   = "streaming_started"
+	NotificationStreamingStopped   = "streaming_stopped"
+	NotificationRecordingStarted   = "recording_started"
+	NotificationRecordingFinished  = "recording_finished"
+	NotificationSnapshotTaken      = "snapshot_taken"
+	NotificationMotionStarted      = "motion_started"
+	NotificationMotionStopped      = "motion_stopped"
+	NotificationSoundStarted       = "sound_started"
+	NotificationSoundStopped       = "sound_stopped"
+)
+
+// Notification is a service event which is of interest outside of a
+// signalling session, such as a recording being finished
+type Notification struct {
+	Type string                 `json:"type"`
+	Time time.Time              `json:"time"`
+	Data map[string]interface{} `json:"data,omitempty"`
+}
+
+type NotificationHandler func(notification Notification)
+
+// Notifier delivers notifications to all subscribed handlers
+type Notifier struct {
+	sync.RWMutex
+	handlers []NotificationHandler
+}
+
+func NewNotifier() *Notifier {
+	return &Notifier{}
+}
+
+func (n *Notifier) Subscribe(handler NotificationHandler) {
+	n.Lock()
+	defer n.Unlock()
+
+	n.handlers = append(n.handlers, handler)
+}
+
+// Notify calls the subscribed handlers synchronously, handlers doing
+// anything slow are expected to hand the notification off
+func (n *Notifier) Notify(notificationType string, data map[string]interface{}) {
+	notification := Notification{
+		Type: notificationType,
+		Time: time.Now(),
+		Data: data,
+	}
+
+	log.Printf("Notification: %s %v\n", notification.Type, notification.Data)
+
+	n.RLock()
+	defer n.RUnlock()
+
+	for _, handler := range n.handlers {
+		handler(notification)
+	}
+}
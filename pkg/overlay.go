@@ -0,0 +1,56 @@
+/*
+ Copyright (c) 2024 Neeraj Jakhar
+
+ This program is free software: you can redistribute it and/or modify
+ it under the terms of the GNU General Public License as published by
+ the Free Software Foundation, either version 3 of the License, or
+ (at your option) any later version.
+
+ This program is distributed in the hope that it will be useful,
+ but WITHOUT ANY WARRANTY; without even the implied warranty of
+ MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License
+ along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/homebackend/go-webrtc/pkg/config"
+)
+
+const maxOverlayText = 256
+
+type OverlayRequest struct {
+	Text string `json:"text" validate:"max=256"`
+}
+
+// serveOverlay sets the overlay text of running and future captures
+func serveOverlay(conf *config.Configuration, settings *config.Settings) http.HandlerFunc {
+	return withPermission(conf, config.PermissionSettings, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if conf.Overlay == nil {
+			http.Error(w, "Overlay is not configured", http.StatusNotFound)
+			return
+		}
+
+		var request OverlayRequest
+		if err := decodeRequest(r, &request); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		log.Printf("Overlay text set to: %s", request.Text)
+		settings.SetOverlayText(request.Text)
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
@@ -0,0 +1,129 @@
+/*
+ Copyright (c) 2024 Neeraj Jakhar
+
+ This program is free software: you can redistribute it and/or modify
+ it under the terms of the GNU General Public License as published by
+ the Free Software Foundation, either version 3 of the License, or
+ (at your option) any later version.
+
+ This program is distributed in the hope that it will be useful,
+ but WITHOUT ANY WARRANTY; without even the implied warranty of
+ MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License
+ along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/homebackend/go-webrtc/pkg/config"
+)
+
+type PrivacyMasks struct {
+	Masks []config.PrivacyMask `json:"masks" validate:"dive"`
+}
+
+// LoadSettings returns the runtime settings of the configuration. The
+// configured masks are replaced with the ones saved by the api, if there are
+// any.
+func LoadSettings(conf *config.Configuration) *config.Settings {
+	settings := config.NewSettings(conf)
+	if conf.Privacy == nil || conf.Privacy.File == "" {
+		return settings
+	}
+
+	data, err := os.ReadFile(conf.Privacy.File)
+	if errors.Is(err, os.ErrNotExist) {
+		return settings
+	} else if err != nil {
+		log.Fatalln(err)
+	}
+
+	var masks PrivacyMasks
+	if err := json.Unmarshal(data, &masks); err != nil {
+		log.Fatalf("Invalid privacy masks in %s: %v", conf.Privacy.File, err)
+	}
+	if err := validate.Struct(masks); err != nil {
+		log.Fatalf("Invalid privacy masks in %s: %v", conf.Privacy.File, err)
+	}
+
+	log.Printf("Loaded %d privacy masks from %s", len(masks.Masks), conf.Privacy.File)
+	settings.SetPrivacyMasks(masks.Masks)
+	return settings
+}
+
+func savePrivacyMasks(file string, masks PrivacyMasks) error {
+	data, err := json.MarshalIndent(masks, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so that the masks are never lost
+	temp, err := os.CreateTemp(filepath.Dir(file), filepath.Base(file))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+
+	if _, err := temp.Write(data); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(temp.Name(), file)
+}
+
+// servePrivacyMasks returns and replaces the privacy masks. Changes apply
+// to running captures immediately.
+func servePrivacyMasks(conf *config.Configuration, settings *config.Settings) http.HandlerFunc {
+	return withCredentials(conf, func(w http.ResponseWriter, r *http.Request) {
+		if conf.Privacy == nil {
+			http.Error(w, "Privacy masks are not configured", http.StatusNotFound)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, http.StatusOK, PrivacyMasks{Masks: settings.PrivacyMasks()})
+		case http.MethodPut:
+			if !permitted(conf, w, r, config.PermissionSettings) {
+				return
+			}
+
+			var masks PrivacyMasks
+			if err := decodeRequest(r, &masks); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if masks.Masks == nil {
+				masks.Masks = []config.PrivacyMask{}
+			}
+
+			if conf.Privacy.File != "" {
+				if err := savePrivacyMasks(conf.Privacy.File, masks); err != nil {
+					log.Println(err)
+					http.Error(w, "Unable to save privacy masks", http.StatusInternalServerError)
+					return
+				}
+			}
+
+			log.Printf("Privacy masks set to: %v", masks.Masks)
+			settings.SetPrivacyMasks(masks.Masks)
+			writeJSON(w, http.StatusOK, masks)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
@@ -0,0 +1,238 @@
+/*
+ Copyright (c) 2024 Neeraj Jakhar
+
+ This program is free software: you can redistribute it and/or modify
+ it under the terms of the GNU General Public License as published by
+ the Free Software Foundation, either version 3 of the License, or
+ (at your option) any later version.
+
+ This program is distributed in the hope that it will be useful,
+ but WITHOUT ANY WARRANTY; without even the implied warranty of
+ MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License
+ along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/homebackend/go-webrtc/pkg/config"
+	"github.com/homebackend/go-webrtc/pkg/session"
+)
+
+const (
+	DefaultRecordingDirectory = "/var/lib/gowebrtc"
+	recordingTimeFormat       = "20060102-150405"
+	snapshotTimeout           = 15 * time.Second
+)
+
+var (
+	ErrRecordingInProgress = errors.New("recording is already in progress")
+	ErrNotRecording        = errors.New("recording is not in progress")
+	ErrDevicesInUse        = session.ErrDevicesStreaming
+)
+
+// Recorder records the capture devices to files and takes snapshots. Like
+// streaming, the capturing is done by a child process. The devices are
+// leased from the streamer, so nothing is captured while a session is
+// running and no session is started while recording.
+type Recorder struct {
+	sync.Mutex
+	configFile string
+	config     *config.Configuration
+	settings   *config.Settings
+	source     session.Source
+	notifier   *Notifier
+	monitor    *Monitor
+	devices    *session.DeviceLease
+	pid        int
+	file       string
+}
+
+func NewRecorder(configFile string, config *config.Configuration, settings *config.Settings, source session.Source, notifier *Notifier, monitor *Monitor, devices *session.DeviceLease) *Recorder {
+	return &Recorder{
+		configFile: configFile,
+		config:     config,
+		settings:   settings,
+		source:     source,
+		notifier:   notifier,
+		monitor:    monitor,
+		devices:    devices,
+	}
+}
+
+// leaseError returns the error of the recorder for a refused lease of the
+// devices
+func leaseError(err error) error {
+	if errors.Is(err, session.ErrDevicesRecording) {
+		return ErrRecordingInProgress
+	}
+	return err
+}
+
+func (r *Recorder) recordingDirectory() string {
+	if r.config.RecordingDirectory == "" {
+		return DefaultRecordingDirectory
+	}
+
+	return r.config.RecordingDirectory
+}
+
+func (r *Recorder) IsRecording() bool {
+	r.Lock()
+	defer r.Unlock()
+
+	return r.pid != 0
+}
+
+// StartRecording starts recording to a new file in the recording directory
+// and returns the name of the file
+func (r *Recorder) StartRecording() (file string, err error) {
+	if err := r.devices.Acquire(session.UseRecording); err != nil {
+		return "", leaseError(err)
+	}
+	defer func() {
+		if err != nil {
+			r.devices.Release(session.UseRecording)
+		}
+	}()
+
+	if err := os.MkdirAll(r.recordingDirectory(), 0755); err != nil {
+		return "", err
+	}
+
+	videoSrc, err := r.source.VideoPipeline()
+	if err != nil {
+		return "", err
+	}
+	audioSrc, err := r.source.AudioPipeline()
+	if err != nil {
+		return "", err
+	}
+
+	file = filepath.Join(r.recordingDirectory(), time.Now().Format(recordingTimeFormat)+".webm")
+	settingsChanged := r.settings.CaptureSettingsChanged()
+	cmd := exec.Command(os.Args[0], "record", "-c", r.configFile, "-v", videoSrc, "-a", audioSrc, "-o", file,
+		"-t", r.settings.OverlayText(), "-m", session.PrivacyMasksArgument(r.settings))
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return "", err
+	}
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return "", err
+	}
+	cmd.Stderr = log.Writer()
+
+	r.monitor.Pause()
+	if err := cmd.Start(); err != nil {
+		r.monitor.Resume()
+		return "", err
+	}
+
+	log.Printf("Started recording to %s with pid: %d", file, cmd.Process.Pid)
+	r.Lock()
+	r.pid = cmd.Process.Pid
+	r.file = file
+	r.Unlock()
+	r.notifier.Notify(NotificationRecordingStarted, map[string]interface{}{"file": file})
+
+	exited := make(chan struct{})
+	go session.ForwardCaptureSettings(r.config, r.settings, settingsChanged, stdin, exited)
+
+	go func() {
+		defer close(exited)
+
+		data := map[string]interface{}{"file": file}
+		// The recording process reports motion and sound as well
+		r.monitor.Forward(stdout)
+		if err := cmd.Wait(); err != nil {
+			log.Printf("Recording process failed: %v", err)
+			data["error"] = err.Error()
+		}
+		r.monitor.Resume()
+
+		r.Lock()
+		r.pid = 0
+		r.file = ""
+		r.Unlock()
+		r.devices.Release(session.UseRecording)
+
+		r.notifier.Notify(NotificationRecordingFinished, data)
+	}()
+
+	return file, nil
+}
+
+// StopRecording asks the recording process to finish the file and exit
+func (r *Recorder) StopRecording() error {
+	r.Lock()
+	defer r.Unlock()
+
+	if r.pid == 0 {
+		return ErrNotRecording
+	}
+
+	log.Printf("Stopping recording to %s", r.file)
+	return syscall.Kill(r.pid, syscall.SIGINT)
+}
+
+// Snapshot captures a single JPEG image from the video device
+func (r *Recorder) Snapshot() ([]byte, error) {
+	if err := r.devices.Acquire(session.UseSnapshot); err != nil {
+		return nil, leaseError(err)
+	}
+	defer r.devices.Release(session.UseSnapshot)
+
+	videoSrc, err := r.source.VideoPipeline()
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.CreateTemp("/tmp", "gowebrtc-snapshot")
+	if err != nil {
+		return nil, err
+	}
+	file.Close()
+	defer os.Remove(file.Name())
+
+	ctx, cancel := context.WithTimeout(context.Background(), snapshotTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, os.Args[0], "snapshot", "-c", r.configFile, "-v", videoSrc, "-o", file.Name(),
+		"-t", r.settings.OverlayText(), "-m", session.PrivacyMasksArgument(r.settings))
+	cmd.Stdout = log.Writer()
+	cmd.Stderr = log.Writer()
+
+	r.monitor.Pause()
+	err = cmd.Run()
+	r.monitor.Resume()
+	if err != nil {
+		return nil, fmt.Errorf("snapshot failed: %v", err)
+	}
+
+	image, err := os.ReadFile(file.Name())
+	if err != nil {
+		return nil, err
+	}
+
+	if len(image) == 0 {
+		return nil, errors.New("snapshot failed: no image captured")
+	}
+
+	r.notifier.Notify(NotificationSnapshotTaken, map[string]interface{}{"size": len(image)})
+	return image, nil
+}
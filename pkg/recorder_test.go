@@ -0,0 +1,57 @@
+/*
+ Copyright (c) 2024 Neeraj Jakhar
+
+ This program is free software: you can redistribute it and/or modify
+ it under the terms of the GNU General Public License as published by
+ the Free Software Foundation, either version 3 of the License, or
+ (at your option) any later version.
+
+ This program is distributed in the hope that it will be useful,
+ but WITHOUT ANY WARRANTY; without even the implied warranty of
+ MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License
+ along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/homebackend/go-webrtc/pkg/config"
+	"github.com/homebackend/go-webrtc/pkg/session"
+)
+
+func TestRecorderDeviceLease(t *testing.T) {
+	configuration := &config.Configuration{}
+	devices := session.NewDeviceLease()
+	recorder := NewRecorder("", configuration, config.NewSettings(configuration), nil, NewNotifier(), nil, devices)
+
+	// A running recording holds the devices
+	if err := devices.Acquire(session.UseRecording); err != nil {
+		t.Fatal(err)
+	}
+	recorder.pid = 1
+	if _, err := recorder.Snapshot(); !errors.Is(err, ErrRecordingInProgress) {
+		t.Errorf("Snapshot while recording: %v", err)
+	}
+	if _, err := recorder.StartRecording(); !errors.Is(err, ErrRecordingInProgress) {
+		t.Errorf("Recording while recording: %v", err)
+	}
+	recorder.pid = 0
+	devices.Release(session.UseRecording)
+
+	// So does a streaming session
+	if err := devices.Acquire(session.UseStreaming); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := recorder.Snapshot(); !errors.Is(err, ErrDevicesInUse) {
+		t.Errorf("Snapshot while streaming: %v", err)
+	}
+	if _, err := recorder.StartRecording(); !errors.Is(err, ErrDevicesInUse) {
+		t.Errorf("Recording while streaming: %v", err)
+	}
+}
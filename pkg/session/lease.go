@@ -0,0 +1,96 @@
+/*
+ Copyright (c) 2024 Neeraj Jakhar
+
+ This program is free software: you can redistribute it and/or modify
+ it under the terms of the GNU General Public License as published by
+ the Free Software Foundation, either version 3 of the License, or
+ (at your option) any later version.
+
+ This program is distributed in the hope that it will be useful,
+ but WITHOUT ANY WARRANTY; without even the implied warranty of
+ MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License
+ along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package session
+
+import (
+	"errors"
+	"sync"
+)
+
+// DeviceUse tells what the capture devices are used for
+type DeviceUse int
+
+const (
+	// Streaming sessions share the devices with each other
+	UseStreaming DeviceUse = iota
+	// Recordings and snapshots use the devices exclusively
+	UseRecording
+	UseSnapshot
+)
+
+var (
+	ErrDevicesStreaming = errors.New("capture devices are in use by a streaming session")
+	ErrDevicesRecording = errors.New("capture devices are in use by a recording")
+	ErrDevicesSnapshot  = errors.New("capture devices are in use by a snapshot")
+)
+
+// DeviceLease keeps the capture processes from opening the capture devices
+// twice. Every capture process is started with a lease of the devices,
+// which is released once the process has exited.
+type DeviceLease struct {
+	mutex   sync.Mutex
+	streams int
+	// The exclusive use of the devices, if any
+	exclusive *DeviceUse
+}
+
+func NewDeviceLease() *DeviceLease {
+	return &DeviceLease{}
+}
+
+// Acquire leases the devices for the use, it fails with the error of the
+// current use if they cannot be shared with it
+func (l *DeviceLease) Acquire(use DeviceUse) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.exclusive != nil {
+		return useError(*l.exclusive)
+	} else if use == UseStreaming {
+		l.streams++
+	} else if l.streams > 0 {
+		return ErrDevicesStreaming
+	} else {
+		l.exclusive = &use
+	}
+
+	return nil
+}
+
+// Release returns a lease acquired for the use
+func (l *DeviceLease) Release(use DeviceUse) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if use == UseStreaming {
+		l.streams--
+	} else {
+		l.exclusive = nil
+	}
+}
+
+func useError(use DeviceUse) error {
+	switch use {
+	case UseRecording:
+		return ErrDevicesRecording
+	case UseSnapshot:
+		return ErrDevicesSnapshot
+	default:
+		return ErrDevicesStreaming
+	}
+}
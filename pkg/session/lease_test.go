@@ -0,0 +1,61 @@
+/*
+ Copyright (c) 2024 Neeraj Jakhar
+
+ This program is free software: you can redistribute it and/or modify
+ it under the terms of the GNU General Public License as published by
+ the Free Software Foundation, either version 3 of the License, or
+ (at your option) any later version.
+
+ This program is distributed in the hope that it will be useful,
+ but WITHOUT ANY WARRANTY; without even the implied warranty of
+ MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License
+ along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package session
+
+import "testing"
+
+func TestDeviceLease(t *testing.T) {
+	l := NewDeviceLease()
+
+	// Streams share the devices
+	for i := 0; i < 2; i++ {
+		if err := l.Acquire(UseStreaming); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := l.Acquire(UseSnapshot); err != ErrDevicesStreaming {
+		t.Errorf("snapshot not refused while streaming: %v", err)
+	}
+	l.Release(UseStreaming)
+	if err := l.Acquire(UseRecording); err != ErrDevicesStreaming {
+		t.Errorf("recording not refused while streaming: %v", err)
+	}
+	l.Release(UseStreaming)
+
+	// Recordings and snapshots do not
+	if err := l.Acquire(UseRecording); err != nil {
+		t.Fatal(err)
+	}
+	for _, use := range []DeviceUse{UseStreaming, UseRecording, UseSnapshot} {
+		if err := l.Acquire(use); err != ErrDevicesRecording {
+			t.Errorf("use %d not refused while recording: %v", use, err)
+		}
+	}
+	l.Release(UseRecording)
+
+	if err := l.Acquire(UseSnapshot); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Acquire(UseStreaming); err != ErrDevicesSnapshot {
+		t.Errorf("stream not refused while taking a snapshot: %v", err)
+	}
+	l.Release(UseSnapshot)
+	if err := l.Acquire(UseStreaming); err != nil {
+		t.Error(err)
+	}
+}
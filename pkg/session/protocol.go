@@ -0,0 +1,131 @@
+/*
+ Copyright (c) 2024 Neeraj Jakhar
+
+ This program is free software: you can redistribute it and/or modify
+ it under the terms of the GNU General Public License as published by
+ the Free Software Foundation, either version 3 of the License, or
+ (at your option) any later version.
+
+ This program is distributed in the hope that it will be useful,
+ but WITHOUT ANY WARRANTY; without even the implied warranty of
+ MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License
+ along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+// Package session runs streaming sessions. Every session is streamed by a
+// child process, which is told about the offer, remote candidates and
+// capture setting changes and reports the answer, its candidates, state
+// changes, connection stats and motion and sound levels line by line.
+package session
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+
+	"github.com/homebackend/go-webrtc/pkg/config"
+)
+
+// Prefixes of the lines exchanged with capture processes
+const (
+	ANSWER     = "Answer: "
+	CANDIDATE  = "Candidate: "
+	STATE      = "State: "
+	MOTION     = "Motion: "
+	LEVEL      = "Level: "
+	OVERLAY    = "Overlay: "
+	MASKS      = "Masks: "
+	STATS      = "Stats: "
+	VISIBILITY = "Visibility: "
+	EOF        = "::EOF::"
+)
+
+// MotionReport is printed by capture processes while motion is detected
+type MotionReport struct {
+	Score float64 `json:"score"`
+}
+
+// LevelReport is printed periodically by capture processes with the RMS
+// level of the audio in dBFS
+type LevelReport struct {
+	Level float64 `json:"level"`
+}
+
+// StatsReport is printed periodically by streaming processes about the
+// connection to the viewer
+type StatsReport struct {
+	Codecs []string `json:"codecs"`
+	// Type of the viewer's candidate in use, relay if the viewer is reached
+	// through a turn server
+	CandidateType string `json:"candidate_type"`
+	// Bits per second sent to the viewer
+	Bitrate uint64 `json:"bitrate"`
+}
+
+// VisibilityReport is printed by streaming processes when the viewer reports
+// whether it shows the stream
+type VisibilityReport struct {
+	Visible bool `json:"visible"`
+}
+
+// WriteOverlayText sends the overlay text to a capture process
+func WriteOverlayText(w io.Writer, text string) {
+	data, err := json.Marshal(text)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	if _, err := fmt.Fprintln(w, OVERLAY+string(data)); err != nil {
+		log.Println(err)
+	}
+}
+
+// WritePrivacyMasks sends the privacy masks to a capture process
+func WritePrivacyMasks(w io.Writer, masks []config.PrivacyMask) {
+	data, err := json.Marshal(masks)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	if _, err := fmt.Fprintln(w, MASKS+string(data)); err != nil {
+		log.Println(err)
+	}
+}
+
+// PrivacyMasksArgument returns the current masks for the command line of a
+// capture process
+func PrivacyMasksArgument(settings *config.Settings) string {
+	data, err := json.Marshal(settings.PrivacyMasks())
+	if err != nil {
+		log.Println(err)
+		return ""
+	}
+
+	return string(data)
+}
+
+// ForwardCaptureSettings sends overlay text and privacy mask changes to a
+// capture process until it exits. changed has to be obtained before the
+// settings passed to the process are read.
+func ForwardCaptureSettings(conf *config.Configuration, settings *config.Settings, changed <-chan struct{}, w io.Writer, exited <-chan struct{}) {
+	for {
+		select {
+		case <-changed:
+			changed = settings.CaptureSettingsChanged()
+			if conf.Overlay != nil {
+				WriteOverlayText(w, settings.OverlayText())
+			}
+			if conf.Privacy != nil {
+				WritePrivacyMasks(w, settings.PrivacyMasks())
+			}
+		case <-exited:
+			return
+		}
+	}
+}
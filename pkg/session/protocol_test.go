@@ -0,0 +1,71 @@
+/*
+ Copyright (c) 2024 Neeraj Jakhar
+
+ This program is free software: you can redistribute it and/or modify
+ it under the terms of the GNU General Public License as published by
+ the Free Software Foundation, either version 3 of the License, or
+ (at your option) any later version.
+
+ This program is distributed in the hope that it will be useful,
+ but WITHOUT ANY WARRANTY; without even the implied warranty of
+ MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License
+ along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package session
+
+import (
+	"bufio"
+	"io"
+	"testing"
+
+	"github.com/homebackend/go-webrtc/pkg/config"
+)
+
+func TestPrivacyMasksArgument(t *testing.T) {
+	conf := &config.Configuration{Privacy: &config.PrivacyConfiguration{
+		Masks: []config.PrivacyMask{{X: 0.5, Width: 0.25, Height: 0.25}},
+	}}
+
+	if argument := PrivacyMasksArgument(config.NewSettings(conf)); argument != `[{"x":0.5,"width":0.25,"height":0.25}]` {
+		t.Errorf("unexpected argument: %s", argument)
+	}
+}
+
+func TestForwardCaptureSettings(t *testing.T) {
+	conf := &config.Configuration{
+		Overlay: &config.OverlayConfiguration{},
+		Privacy: &config.PrivacyConfiguration{},
+	}
+
+	r, w := io.Pipe()
+	exited := make(chan struct{})
+	forwarded := make(chan struct{})
+	settings := config.NewSettings(conf)
+	changed := settings.CaptureSettingsChanged()
+	go func() {
+		defer close(forwarded)
+		ForwardCaptureSettings(conf, settings, changed, w, exited)
+	}()
+
+	lines := bufio.NewScanner(r)
+	settings.SetOverlayText("text")
+	for _, expected := range []string{OVERLAY + `"text"`, MASKS + "null"} {
+		if !lines.Scan() || lines.Text() != expected {
+			t.Fatalf("expected %s, got %s", expected, lines.Text())
+		}
+	}
+
+	settings.SetPrivacyMasks([]config.PrivacyMask{{Width: 1, Height: 1}})
+	for _, expected := range []string{OVERLAY + `"text"`, MASKS + `[{"width":1,"height":1}]`} {
+		if !lines.Scan() || lines.Text() != expected {
+			t.Fatalf("expected %s, got %s", expected, lines.Text())
+		}
+	}
+
+	close(exited)
+	<-forwarded
+}
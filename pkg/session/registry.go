@@ -0,0 +1,261 @@
+/*
+ Copyright (c) 2024 Neeraj Jakhar
+
+ This program is free software: you can redistribute it and/or modify
+ it under the terms of the GNU General Public License as published by
+ the Free Software Foundation, either version 3 of the License, or
+ (at your option) any later version.
+
+ This program is distributed in the hope that it will be useful,
+ but WITHOUT ANY WARRANTY; without even the implied warranty of
+ MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License
+ along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package session
+
+import (
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Viewer describes whom a session streams to. The id is chosen by the
+// signalling transport and identifies the session.
+type Viewer struct {
+	Id            string
+	User          string
+	RemoteAddress string
+	// The stream requested by the viewer
+	Stream string
+	// Whether the viewer may end running sessions, if the preemption policy
+	// allows it
+	Preempt bool
+	// Viewers with a higher priority preempt those with a lower one, if
+	// preemption is by priority
+	Priority int
+	// How long the session may last, the configured maximum duration is
+	// used if 0
+	MaxDuration time.Duration
+}
+
+// Session is a streaming session, from the offer until its streaming
+// process has exited. Sessions may wait in a queue before streaming.
+type Session struct {
+	Viewer
+	Started  time.Time
+	mutex    sync.Mutex
+	pid      int
+	stopped  bool
+	reason   string
+	stats    StatsReport
+	position int
+	// When the viewer stopped showing the stream, zero while it is shown
+	hiddenSince time.Time
+	// Sessions which have been stopped to make room for this one
+	displaced []*Session
+	// Closed once the session may stream
+	admitted chan struct{}
+	// Signalled when the position in the queue changes
+	moved chan struct{}
+	// Signalled when the viewer shows or hides the stream
+	visibility chan struct{}
+	stopping   chan struct{}
+	ended      chan struct{}
+}
+
+func newSession(viewer Viewer) *Session {
+	return &Session{
+		Viewer:     viewer,
+		Started:    time.Now(),
+		admitted:   make(chan struct{}),
+		moved:      make(chan struct{}, 1),
+		visibility: make(chan struct{}, 1),
+		stopping:   make(chan struct{}),
+		ended:      make(chan struct{}),
+	}
+}
+
+// Ended is closed once the session is over
+func (s *Session) Ended() <-chan struct{} {
+	return s.ended
+}
+
+// Stopped returns whether the session has been asked to end
+func (s *Session) Stopped() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.stopped
+}
+
+// Stats returns the latest stats reported by the streaming process
+func (s *Session) Stats() StatsReport {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.stats
+}
+
+func (s *Session) setStats(stats StatsReport) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.stats = stats
+}
+
+// QueuePosition returns the position of the session in the queue, 1 being
+// next, or 0 if it is not queued
+func (s *Session) QueuePosition() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.position
+}
+
+// setPosition updates the position in the queue and signals changes
+func (s *Session) setPosition(position int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.position == position {
+		return
+	}
+
+	s.position = position
+	select {
+	case s.moved <- struct{}{}:
+	default:
+	}
+}
+
+// HiddenSince returns when the viewer stopped showing the stream, or the zero
+// time while it is shown
+func (s *Session) HiddenSince() time.Time {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.hiddenSince
+}
+
+// setVisible records whether the viewer shows the stream and signals changes
+func (s *Session) setVisible(visible bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if visible == s.hiddenSince.IsZero() {
+		return
+	}
+
+	if visible {
+		s.hiddenSince = time.Time{}
+	} else {
+		s.hiddenSince = time.Now()
+	}
+	select {
+	case s.visibility <- struct{}{}:
+	default:
+	}
+}
+
+// stopReason returns why the session was stopped, if a reason was given
+func (s *Session) stopReason() string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.reason
+}
+
+// started records the pid of the streaming process. A process started for a
+// session which was stopped in the meantime is asked to exit right away.
+func (s *Session) started(pid int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.pid = pid
+	if s.stopped {
+		syscall.Kill(pid, syscall.SIGINT)
+	}
+}
+
+// stop asks the streaming process to exit and returns false if the session
+// was stopped before. The reason, if any, is reported as the cause of the
+// end of the session.
+func (s *Session) stop(reason string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.stopped {
+		return false
+	}
+
+	s.stopped = true
+	s.reason = reason
+	close(s.stopping)
+	// Without a process there is nothing to signal, a pid of 0 would signal
+	// the process group
+	if s.pid != 0 {
+		syscall.Kill(s.pid, syscall.SIGINT)
+	}
+	return true
+}
+
+// Registry holds the sessions of a session manager by their id. It is safe
+// for concurrent use.
+type Registry struct {
+	sync.RWMutex
+	sessions map[string]*Session
+}
+
+func NewRegistry() *Registry {
+	return &Registry{sessions: make(map[string]*Session)}
+}
+
+// Add registers the session and returns false if the id is already in use
+func (r *Registry) Add(s *Session) bool {
+	r.Lock()
+	defer r.Unlock()
+
+	if _, ok := r.sessions[s.Id]; ok {
+		return false
+	}
+
+	r.sessions[s.Id] = s
+	return true
+}
+
+// Remove unregisters the session, a newer session with the same id is kept
+func (r *Registry) Remove(s *Session) {
+	r.Lock()
+	defer r.Unlock()
+
+	if r.sessions[s.Id] == s {
+		delete(r.sessions, s.Id)
+	}
+}
+
+func (r *Registry) Get(id string) *Session {
+	r.RLock()
+	defer r.RUnlock()
+
+	return r.sessions[id]
+}
+
+// List returns the sessions, oldest first
+func (r *Registry) List() []*Session {
+	r.RLock()
+	sessions := make([]*Session, 0, len(r.sessions))
+	for _, s := range r.sessions {
+		sessions = append(sessions, s)
+	}
+	r.RUnlock()
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].Started.Before(sessions[j].Started)
+	})
+	return sessions
+}
@@ -0,0 +1,93 @@
+/*
+ Copyright (c) 2024 Neeraj Jakhar
+
+ This program is free software: you can redistribute it and/or modify
+ it under the terms of the GNU General Public License as published by
+ the Free Software Foundation, either version 3 of the License, or
+ (at your option) any later version.
+
+ This program is distributed in the hope that it will be useful,
+ but WITHOUT ANY WARRANTY; without even the implied warranty of
+ MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License
+ along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package session
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+	first := newSession(Viewer{Id: "id"})
+	if !r.Add(first) {
+		t.Fatal("session not added")
+	}
+	if r.Add(newSession(Viewer{Id: "id"})) {
+		t.Error("session with a duplicate id added")
+	}
+
+	r.Remove(first)
+	second := newSession(Viewer{Id: "id"})
+	r.Add(second)
+	// Removing the old session again keeps the new one with the same id
+	r.Remove(first)
+	if r.Get("id") != second {
+		t.Error("newer session removed")
+	}
+}
+
+func TestRegistryList(t *testing.T) {
+	r := NewRegistry()
+	now := time.Now()
+	for i := 3; i > 0; i-- {
+		s := newSession(Viewer{Id: fmt.Sprint(i)})
+		s.Started = now.Add(time.Duration(i) * time.Second)
+		r.Add(s)
+	}
+
+	sessions := r.List()
+	for i, s := range sessions {
+		if s.Id != fmt.Sprint(i+1) {
+			t.Fatalf("sessions not oldest first: %v", sessions)
+		}
+	}
+}
+
+func TestRegistryConcurrentUse(t *testing.T) {
+	r := NewRegistry()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			s := newSession(Viewer{Id: id})
+			r.Add(s)
+			r.List()
+			r.Get(id)
+			r.Remove(s)
+		}(fmt.Sprint(i))
+	}
+	wg.Wait()
+
+	if sessions := r.List(); len(sessions) != 0 {
+		t.Errorf("sessions left: %v", sessions)
+	}
+}
+
+func TestSessionStop(t *testing.T) {
+	s := newSession(Viewer{Id: "id"})
+	if !s.stop("") {
+		t.Error("session not stopped")
+	}
+	if s.stop("") || !s.Stopped() {
+		t.Error("session stopped twice")
+	}
+}
@@ -0,0 +1,44 @@
+/*
+ Copyright (c) 2024 Neeraj Jakhar
+
+ This program is free software: you can redistribute it and/or modify
+ it under the terms of the GNU General Public License as published by
+ the Free Software Foundation, either version 3 of the License, or
+ (at your option) any later version.
+
+ This program is distributed in the hope that it will be useful,
+ but WITHOUT ANY WARRANTY; without even the implied warranty of
+ MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License
+ along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package session
+
+// Source provides the GStreamer pipelines capturing video and audio. The
+// pipelines are passed to the capture processes, which encode them for
+// streaming and recording, so they have to produce raw video and audio.
+type Source interface {
+	VideoPipeline() (string, error)
+	AudioPipeline() (string, error)
+}
+
+// Monitor watches the capture devices while they are not in use. It is
+// paused while a capture process runs and handles the motion and sound
+// reports of that process.
+type Monitor interface {
+	Pause()
+	Resume()
+	// HandleReport returns whether the line printed by the capture process
+	// was a report
+	HandleReport(line string) bool
+}
+
+// nopMonitor is used if no monitor is set
+type nopMonitor struct{}
+
+func (nopMonitor) Pause()                   {}
+func (nopMonitor) Resume()                  {}
+func (nopMonitor) HandleReport(string) bool { return false }
@@ -0,0 +1,707 @@
+/*
+ Copyright (c) 2024 Neeraj Jakhar
+
+ This program is free software: you can redistribute it and/or modify
+ it under the terms of the GNU General Public License as published by
+ the Free Software Foundation, either version 3 of the License, or
+ (at your option) any later version.
+
+ This program is distributed in the hope that it will be useful,
+ but WITHOUT ANY WARRANTY; without even the implied warranty of
+ MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License
+ along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package session
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/homebackend/go-webrtc/pkg/config"
+	"github.com/homebackend/go-webrtc/pkg/signalling"
+)
+
+// Time given to a preempted session to release the capture devices
+const preemptTimeout = 5 * time.Second
+
+// Causes reported to viewers whose session reached a limit
+const (
+	durationReason = "maximum session duration reached"
+	idleReason     = "viewer was idle for too long"
+)
+
+var (
+	errStreaming        = errors.New("Service unavailable as streaming is in progress")
+	errDuplicateSession = errors.New("Session is already streaming")
+)
+
+type AnswerHandler func(string)
+
+type CandidateHandler func(string)
+
+type StateHandler func(state string, reason string)
+
+type ErrorHandler func(string)
+
+// QueueHandler is told the position of a queued viewer, 1 being next
+type QueueHandler func(position int)
+
+// KickHandler is told why a session was ended by somebody other than its
+// viewer
+type KickHandler func(reason string)
+
+// WarningHandler is told that the session will be ended for the reason in
+// the remaining time
+type WarningHandler func(reason string, remaining time.Duration)
+
+// Handlers report the progress of a session to its viewer. Answer and Error
+// are required, the others may be nil.
+type Handlers struct {
+	Answer    AnswerHandler
+	Candidate CandidateHandler
+	State     StateHandler
+	Error     ErrorHandler
+	Queue     QueueHandler
+	Kick      KickHandler
+	Warning   WarningHandler
+}
+
+func (h Handlers) candidate(candidate string) {
+	if h.Candidate != nil {
+		h.Candidate(candidate)
+	}
+}
+
+func (h Handlers) state(state string, reason string) {
+	if h.State != nil {
+		h.State(state, reason)
+	}
+}
+
+func (h Handlers) queue(position int) {
+	if h.Queue != nil {
+		h.Queue(position)
+	}
+}
+
+func (h Handlers) kick(reason string) {
+	if h.Kick != nil {
+		h.Kick(reason)
+	}
+}
+
+func (h Handlers) warning(reason string, remaining time.Duration) {
+	if h.Warning != nil {
+		h.Warning(reason, remaining)
+	}
+}
+
+// SessionManager starts streaming sessions for the offers received by a
+// signalling transport
+type SessionManager interface {
+	// Stream starts a session for the viewer with the base64 encoded offer.
+	// It returns once the candidates have been gathered or the session could
+	// not be started, state changes are reported until the session ends.
+	// Viewers waiting in the queue are told their position meanwhile.
+	// Remote candidates are read from remoteCandidates, which may be nil.
+	// Stream may be called concurrently.
+	Stream(viewer Viewer, sdp string, remoteCandidates <-chan string, handlers Handlers)
+	// Stop ends the running and queued sessions and returns whether there
+	// were any
+	Stop() bool
+	// StopSession ends the session with the given id and returns whether
+	// there was one. The reason is reported as the cause of the end.
+	StopSession(id string, reason string) bool
+	// SetVisible records whether the viewer of the session shows the stream
+	// and returns whether there is such a session. Sessions which are not
+	// shown are ended after the idle timeout.
+	SetVisible(id string, visible bool) bool
+	// Streaming returns whether a session is running
+	Streaming() bool
+	// Sessions returns the sessions which have not ended yet, oldest first
+	Sessions() []*Session
+}
+
+// Streamer is the SessionManager streaming from a single source to as many
+// viewers as configured, further viewers are queued or refused. Sessions
+// are streamed by running the execute command of gowebrtc.
+type Streamer struct {
+	configFile string
+	config     *config.Configuration
+	settings   *config.Settings
+	source     Source
+	monitor    Monitor
+	// Command returns the command of a streaming process with the given
+	// arguments. By default the running executable is run, embedders which
+	// are not gowebrtc have to run the gowebrtc executable instead.
+	Command func(args ...string) *exec.Cmd
+	// Devices is leased by the streaming processes. Whatever else captures
+	// from the source, such as recordings, has to lease it as well.
+	Devices  *DeviceLease
+	sessions *Registry
+	mutex    sync.Mutex
+	// The sessions streaming from the source, oldest first
+	active []*Session
+	// The sessions waiting for a free slot, next first
+	queue []*Session
+}
+
+// NewStreamer returns a streamer for the source. The configuration file is
+// read by the streaming processes, the monitor may be nil.
+func NewStreamer(configFile string, conf *config.Configuration, settings *config.Settings, source Source, monitor Monitor) *Streamer {
+	if monitor == nil {
+		monitor = nopMonitor{}
+	}
+
+	return &Streamer{
+		configFile: configFile,
+		config:     conf,
+		settings:   settings,
+		source:     source,
+		monitor:    monitor,
+		Command:    selfCommand,
+		Devices:    NewDeviceLease(),
+		sessions:   NewRegistry(),
+	}
+}
+
+func selfCommand(args ...string) *exec.Cmd {
+	return exec.Command(os.Args[0], args...)
+}
+
+func (s *Streamer) Streaming() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.running() > 0
+}
+
+func (s *Streamer) Stop() bool {
+	s.mutex.Lock()
+	sessions := append(append([]*Session{}, s.active...), s.queue...)
+	s.mutex.Unlock()
+
+	stopped := false
+	for _, session := range sessions {
+		if session.stop("") {
+			stopped = true
+		}
+	}
+	return stopped
+}
+
+func (s *Streamer) StopSession(id string, reason string) bool {
+	session := s.sessions.Get(id)
+	return session != nil && session.stop(reason)
+}
+
+func (s *Streamer) SetVisible(id string, visible bool) bool {
+	session := s.sessions.Get(id)
+	if session != nil {
+		session.setVisible(visible)
+	}
+	return session != nil
+}
+
+func (s *Streamer) Sessions() []*Session {
+	return s.sessions.List()
+}
+
+// running returns the number of active sessions which have not been stopped.
+// Called with the lock held.
+func (s *Streamer) running() int {
+	running := 0
+	for _, session := range s.active {
+		if !session.Stopped() {
+			running++
+		}
+	}
+	return running
+}
+
+// reserve admits the session to stream from the source, preempting a
+// running session if the policy allows it. Without a free slot the session
+// is queued, if the queue is not full.
+func (s *Streamer) reserve(session *Session) error {
+	s.mutex.Lock()
+
+	if !s.sessions.Add(session) {
+		s.mutex.Unlock()
+		return errDuplicateSession
+	}
+
+	var admitted []*Session
+	var err error
+	// Queued viewers are admitted before newer ones
+	if len(s.queue) == 0 && s.running() < s.config.MaxViewers() {
+		admitted, err = s.admit(admitted, session)
+	} else if victim, reason := s.victim(session.Viewer); victim != nil {
+		victim.stop(reason)
+		admitted, err = s.admit(admitted, session)
+	} else if len(s.queue) < s.config.MaxQueued() {
+		s.queue = append(s.queue, session)
+		session.setPosition(len(s.queue))
+	} else {
+		s.sessions.Remove(session)
+		s.mutex.Unlock()
+		return errStreaming
+	}
+	if err != nil {
+		// The devices are used by a recording or snapshot
+		s.sessions.Remove(session)
+		s.mutex.Unlock()
+		return err
+	}
+	s.mutex.Unlock()
+
+	s.release(admitted)
+	return nil
+}
+
+// victim returns the running session the viewer may preempt, if any, and
+// the reason given to its viewer. Called with the lock held.
+func (s *Streamer) victim(viewer Viewer) (*Session, string) {
+	policy := s.config.Preemption()
+	if !viewer.Preempt || policy == config.PreemptionNever {
+		return nil, ""
+	}
+
+	var victim *Session
+	for _, session := range s.active {
+		if session.Stopped() || (policy == config.PreemptionPriority && session.Priority >= viewer.Priority) {
+			continue
+		}
+		// Of the lowest priority the oldest session is preempted
+		if victim == nil || session.Priority < victim.Priority {
+			victim = session
+		}
+	}
+
+	if victim == nil {
+		return nil, ""
+	} else if policy == config.PreemptionPriority {
+		return victim, "session was taken over by a viewer with a higher priority"
+	}
+	return victim, "session was taken over by another viewer"
+}
+
+// admit leases the devices for the session, adds it to the active sessions
+// and to the sessions which may stream once released. Called with the lock
+// held.
+func (s *Streamer) admit(admitted []*Session, session *Session) ([]*Session, error) {
+	if err := s.Devices.Acquire(UseStreaming); err != nil {
+		return admitted, err
+	}
+
+	for _, active := range s.active {
+		if active.Stopped() {
+			session.displaced = append(session.displaced, active)
+		}
+	}
+	s.active = append(s.active, session)
+	session.setPosition(0)
+
+	return append(admitted, session), nil
+}
+
+// release lets the admitted sessions stream once the monitor has released
+// the capture devices, the sessions still wait for the sessions they
+// displaced to end. Pausing blocks until the monitor exits, so it is done
+// without the lock held.
+func (s *Streamer) release(admitted []*Session) {
+	for _, session := range admitted {
+		s.monitor.Pause()
+		close(session.admitted)
+	}
+}
+
+// end releases the source of the session, admits queued sessions to the
+// free slots and marks the session as ended
+func (s *Streamer) end(session *Session) {
+	s.mutex.Lock()
+	active := remove(&s.active, session)
+	remove(&s.queue, session)
+
+	var admitted []*Session
+	for len(s.queue) > 0 && s.running() < s.config.MaxViewers() {
+		next := s.queue[0]
+		s.queue = s.queue[1:]
+		// Stopped sessions leave the queue on their own
+		if next.Stopped() {
+			continue
+		}
+		var err error
+		if admitted, err = s.admit(admitted, next); err != nil {
+			next.stop(err.Error())
+		}
+	}
+	for i, queued := range s.queue {
+		queued.setPosition(i + 1)
+	}
+	s.mutex.Unlock()
+
+	// The sessions taking over pause the monitor before it is resumed, so
+	// that it does not take the capture devices in between
+	s.release(admitted)
+	if active {
+		// The session may have been stopped before it was released, its
+		// pause has to come first
+		<-session.admitted
+		s.monitor.Resume()
+		s.Devices.Release(UseStreaming)
+	}
+
+	s.sessions.Remove(session)
+	close(session.ended)
+}
+
+// remove removes the session from the list and returns whether it was in it
+func remove(sessions *[]*Session, session *Session) bool {
+	for i, s := range *sessions {
+		if s == session {
+			*sessions = append((*sessions)[:i:i], (*sessions)[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// await waits until the session may stream, telling the viewer about its
+// position in the queue meanwhile. It returns false if the session was
+// stopped before.
+func (s *Streamer) await(session *Session, handlers Handlers) bool {
+	for {
+		select {
+		case <-session.admitted:
+			return true
+		case <-session.moved:
+			if position := session.QueuePosition(); position > 0 {
+				handlers.queue(position)
+			}
+		case <-session.stopping:
+			return false
+		}
+	}
+}
+
+// limit returns when the session has to end and why, the zero time if it
+// may go on
+func (s *Streamer) limit(session *Session, started time.Time) (time.Time, string) {
+	var deadline time.Time
+	reason := ""
+
+	maxDuration := session.MaxDuration
+	if maxDuration == 0 {
+		maxDuration = s.config.MaxDuration()
+	}
+	if maxDuration > 0 {
+		deadline, reason = started.Add(maxDuration), durationReason
+	}
+
+	hidden := session.HiddenSince()
+	if timeout := s.config.IdleTimeout(); timeout > 0 && !hidden.IsZero() {
+		if idle := hidden.Add(timeout); deadline.IsZero() || idle.Before(deadline) {
+			deadline, reason = idle, idleReason
+		}
+	}
+
+	return deadline, reason
+}
+
+// supervise ends the session once it reaches its maximum duration or its
+// viewer has not shown the stream for the idle timeout. The viewer is
+// warned before.
+func (s *Streamer) supervise(session *Session, handlers Handlers) {
+	started := time.Now()
+	warning := s.config.SessionWarning()
+	// The deadline the viewer has been warned about
+	var warned time.Time
+
+	for {
+		deadline, reason := s.limit(session, started)
+
+		var timer *time.Timer
+		var timeout <-chan time.Time
+		if !deadline.IsZero() {
+			now := time.Now()
+			if !now.Before(deadline) {
+				log.Printf("Ending session %s: %s", session.Id, reason)
+				session.stop(reason)
+				return
+			}
+
+			next := deadline.Add(-warning)
+			if !now.Before(next) {
+				if !warned.Equal(deadline) {
+					handlers.warning(reason, deadline.Sub(now))
+					warned = deadline
+				}
+				next = deadline
+			}
+
+			timer = time.NewTimer(next.Sub(now))
+			timeout = timer.C
+		}
+
+		over := false
+		select {
+		case <-timeout:
+		case <-session.visibility:
+		case <-session.stopping:
+			over = true
+		case <-session.ended:
+			over = true
+		}
+
+		if timer != nil {
+			timer.Stop()
+		}
+		if over {
+			return
+		}
+	}
+}
+
+// execute runs the streaming process and returns its exit code
+func (s *Streamer) execute(session *Session, videoSrc, audioSrc, sdpFileName string, answer chan string, candidate chan string, end chan bool,
+	stateHandler StateHandler, remoteCandidates <-chan string) (int, error) {
+	settingsChanged := s.settings.CaptureSettingsChanged()
+	args := []string{"execute", "-c", s.configFile, "-v", videoSrc, "-a", audioSrc, "-s", sdpFileName, "-t", s.settings.OverlayText(), "-m", PrivacyMasksArgument(s.settings)}
+	if s.config.IceTrickling {
+		args = append(args, "-w")
+	}
+	cmd := s.Command(args...)
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return -1, err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return -1, err
+	}
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return -1, err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return -1, err
+	}
+	log.Printf("Started process with pid: %d", cmd.Process.Pid)
+	session.started(cmd.Process.Pid)
+
+	exited := make(chan struct{})
+	defer close(exited)
+
+	go ForwardCaptureSettings(s.config, s.settings, settingsChanged, stdin, exited)
+
+	go func() {
+		for {
+			select {
+			case c := <-remoteCandidates:
+				log.Printf("Remote candidate: %s", c)
+				if _, err := fmt.Fprintln(stdin, CANDIDATE+c); err != nil {
+					log.Println(err)
+				}
+			case <-exited:
+				return
+			}
+		}
+	}()
+
+	go func() {
+		scanner := bufio.NewScanner(stdout)
+		for scanner.Scan() {
+			m := scanner.Text()
+			if strings.HasPrefix(m, ANSWER) {
+				answerText := m[len(ANSWER):]
+				log.Printf("Answer found to be %s", answerText)
+				answer <- answerText
+			} else if strings.HasPrefix(m, CANDIDATE) {
+				candidateText := m[len(CANDIDATE):]
+				log.Printf("Candidate found to be %s", candidateText)
+				candidate <- candidateText
+			} else if strings.HasPrefix(m, STATE) {
+				var stateEvent signalling.StateEvent
+				if err := json.Unmarshal([]byte(m[len(STATE):]), &stateEvent); err != nil {
+					log.Printf("Invalid state from child: %v", err)
+				} else {
+					stateHandler(stateEvent.State, stateEvent.Reason)
+				}
+			} else if strings.HasPrefix(m, STATS) {
+				var stats StatsReport
+				if err := json.Unmarshal([]byte(m[len(STATS):]), &stats); err != nil {
+					log.Printf("Invalid stats from child: %v", err)
+				} else {
+					session.setStats(stats)
+				}
+			} else if strings.HasPrefix(m, VISIBILITY) {
+				var visibility VisibilityReport
+				if err := json.Unmarshal([]byte(m[len(VISIBILITY):]), &visibility); err != nil {
+					log.Printf("Invalid visibility from child: %v", err)
+				} else {
+					session.setVisible(visibility.Visible)
+				}
+			} else if strings.HasPrefix(m, EOF) {
+				end <- true
+			} else if !s.monitor.HandleReport(m) {
+				log.Println(m)
+			}
+		}
+	}()
+
+	go func() {
+		scanerr := bufio.NewScanner(stderr)
+		for scanerr.Scan() {
+			m := scanerr.Text()
+			log.Println(m)
+		}
+	}()
+
+	if err := cmd.Wait(); err != nil {
+		var exiterr *exec.ExitError
+		if errors.As(err, &exiterr) {
+			return exiterr.ExitCode(), nil
+		}
+
+		return -1, err
+	}
+
+	return 0, nil
+}
+
+func (s *Streamer) Stream(viewer Viewer, sdp string, remoteCandidates <-chan string, handlers Handlers) {
+	session := newSession(viewer)
+	if err := s.reserve(session); err != nil {
+		handlers.Error(err.Error())
+		return
+	}
+
+	started := false
+	defer func() {
+		if !started {
+			s.end(session)
+			if reason := session.stopReason(); reason != "" {
+				handlers.kick(reason)
+			}
+		}
+	}()
+
+	if !s.await(session, handlers) {
+		handlers.Error("Session was stopped before streaming started")
+		return
+	}
+
+	timeout := time.After(preemptTimeout)
+	for _, displaced := range session.displaced {
+		select {
+		case <-displaced.Ended():
+		case <-timeout:
+			log.Printf("Preempted session %s did not end in time", displaced.Id)
+		}
+	}
+
+	// Another session may have taken over while waiting
+	if session.Stopped() {
+		handlers.Error("Session was stopped before streaming started")
+		return
+	}
+
+	videoSrc, err := s.source.VideoPipeline()
+	if err != nil {
+		log.Println(err)
+		handlers.Error("Unable to create video pipeline")
+		return
+	}
+	audioSrc, err := s.source.AudioPipeline()
+	if err != nil {
+		log.Println(err)
+		handlers.Error("Unable to create audio pipeline")
+		return
+	}
+
+	log.Println(videoSrc)
+	log.Println(audioSrc)
+
+	file, err := os.CreateTemp("/tmp", "gowebrtc")
+	if err != nil {
+		log.Println(err)
+		handlers.Error("Unable to create sdp file")
+		return
+	}
+	sdpFileName := file.Name()
+	defer os.Remove(sdpFileName)
+
+	file.WriteString(sdp)
+	file.Close()
+
+	answer := make(chan string)
+	candidate := make(chan string)
+	end := make(chan bool)
+	// Buffered so that the executor goroutine does not block once the answer
+	// has been delivered and nobody is listening anymore.
+	failure := make(chan int, 1)
+
+	started = true
+	go s.supervise(session, handlers)
+	go func() {
+		log.Println("About to execute streaming process")
+		code, err := s.execute(session, videoSrc, audioSrc, sdpFileName, answer, candidate, end, handlers.state, remoteCandidates)
+		cause := sessionEndCause(code)
+		if reason := session.stopReason(); reason != "" {
+			cause = reason
+		}
+		if err != nil {
+			log.Printf("Unable to run streaming process: %v", err)
+			cause = err.Error()
+		}
+		log.Printf("Streaming process of session %s exited with code: %d", session.Id, code)
+		s.end(session)
+		if reason := session.stopReason(); reason != "" {
+			handlers.kick(reason)
+		}
+		handlers.state(signalling.StateSessionEnded, cause)
+		failure <- code
+	}()
+
+	for {
+		select {
+		case a := <-answer:
+			log.Println("Got result")
+			handlers.Answer(a)
+			log.Println("Sent response")
+		case c := <-candidate:
+			handlers.candidate(c)
+		case <-end:
+			return
+		case code := <-failure:
+			log.Println("Got error while starting streaming")
+			handlers.Error(fmt.Sprintf("Error while creating stream: %d", code))
+			return
+		}
+	}
+}
+
+func sessionEndCause(code int) string {
+	switch code {
+	case 0:
+		return "streaming process finished"
+	case -1:
+		return "streaming process was terminated"
+	default:
+		return fmt.Sprintf("streaming process exited with code: %d", code)
+	}
+}
@@ -0,0 +1,726 @@
+/*
+ Copyright (c) 2024 Neeraj Jakhar
+
+ This program is free software: you can redistribute it and/or modify
+ it under the terms of the GNU General Public License as published by
+ the Free Software Foundation, either version 3 of the License, or
+ (at your option) any later version.
+
+ This program is distributed in the hope that it will be useful,
+ but WITHOUT ANY WARRANTY; without even the implied warranty of
+ MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License
+ along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package session
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/homebackend/go-webrtc/pkg/config"
+	"github.com/homebackend/go-webrtc/pkg/signalling"
+)
+
+// The test binary acts as the streaming process if this is set
+const fakeChildEnv = "GOWEBRTC_FAKE_CHILD"
+
+// Offer which makes the fake streaming process fail before answering
+const failingOffer = "fail"
+
+const testTimeout = 10 * time.Second
+
+func TestMain(m *testing.M) {
+	if os.Getenv(fakeChildEnv) != "" {
+		fakeChild(os.Args[1:])
+		return
+	}
+
+	os.Exit(m.Run())
+}
+
+// fakeChild answers the offer like a streaming process and reports every
+// line written to its stdin as a state change, until it is interrupted
+func fakeChild(args []string) {
+	var sdpFile string
+	wait := false
+	for i, arg := range args {
+		switch arg {
+		case "-s":
+			sdpFile = args[i+1]
+		case "-w":
+			wait = true
+		}
+	}
+
+	offer, err := os.ReadFile(sdpFile)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if string(offer) == failingOffer {
+		os.Exit(3)
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT)
+
+	fmt.Println(ANSWER + "answer:" + string(offer))
+	if wait {
+		fmt.Println(CANDIDATE + "local")
+	}
+	fmt.Println(MOTION + `{"score":0.5}`)
+	fmt.Println(STATS + `{"codecs":["video/VP8"],"candidate_type":"host","bitrate":1000}`)
+	fmt.Println(VISIBILITY + `{"visible":false}`)
+	fmt.Println(EOF)
+
+	go func() {
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			state, _ := json.Marshal(signalling.StateEvent{State: signalling.StateConnected, Reason: scanner.Text()})
+			fmt.Println(STATE + string(state))
+		}
+	}()
+
+	<-signals
+}
+
+type testSource struct {
+	err error
+}
+
+func (s testSource) VideoPipeline() (string, error) {
+	return "videotestsrc", s.err
+}
+
+func (s testSource) AudioPipeline() (string, error) {
+	return "audiotestsrc", s.err
+}
+
+type testMonitor struct {
+	sync.Mutex
+	paused  int
+	reports []string
+}
+
+func (m *testMonitor) Pause() {
+	m.Lock()
+	defer m.Unlock()
+	m.paused++
+}
+
+func (m *testMonitor) Resume() {
+	m.Lock()
+	defer m.Unlock()
+	m.paused--
+}
+
+func (m *testMonitor) HandleReport(line string) bool {
+	if !strings.HasPrefix(line, MOTION) {
+		return false
+	}
+
+	m.Lock()
+	defer m.Unlock()
+	m.reports = append(m.reports, line)
+	return true
+}
+
+func (m *testMonitor) state() (int, []string) {
+	m.Lock()
+	defer m.Unlock()
+	return m.paused, append([]string(nil), m.reports...)
+}
+
+func newTestStreamer(conf *config.Configuration, source Source, monitor Monitor) *Streamer {
+	s := NewStreamer("config.yaml", conf, config.NewSettings(conf), source, monitor)
+	s.Command = func(args ...string) *exec.Cmd {
+		cmd := exec.Command(os.Args[0], args...)
+		cmd.Env = append(os.Environ(), fakeChildEnv+"=1")
+		return cmd
+	}
+
+	return s
+}
+
+// testSession collects what a streaming request reports
+type testSession struct {
+	answers    chan string
+	candidates chan string
+	states     chan signalling.StateEvent
+	errors     chan string
+	positions  chan int
+	kicks      chan string
+	warnings   chan string
+}
+
+func newTestSession() *testSession {
+	return &testSession{
+		answers:    make(chan string, 4),
+		candidates: make(chan string, 4),
+		states:     make(chan signalling.StateEvent, 16),
+		errors:     make(chan string, 4),
+		positions:  make(chan int, 16),
+		kicks:      make(chan string, 4),
+		warnings:   make(chan string, 4),
+	}
+}
+
+// stream requests a session for the offer, the offers of a test are unique
+// and double as session ids
+func (ts *testSession) stream(s *Streamer, sdp string, remoteCandidates <-chan string) {
+	ts.streamAs(s, Viewer{Id: sdp, Preempt: true}, sdp, remoteCandidates)
+}
+
+func (ts *testSession) streamAs(s *Streamer, viewer Viewer, sdp string, remoteCandidates <-chan string) {
+	s.Stream(viewer, sdp, remoteCandidates, Handlers{
+		Answer: func(answer string) {
+			ts.answers <- answer
+		},
+		Candidate: func(candidate string) {
+			ts.candidates <- candidate
+		},
+		State: func(state, reason string) {
+			ts.states <- signalling.StateEvent{State: state, Reason: reason}
+		},
+		Error: func(err string) {
+			ts.errors <- err
+		},
+		Queue: func(position int) {
+			ts.positions <- position
+		},
+		Kick: func(reason string) {
+			ts.kicks <- reason
+		},
+		Warning: func(reason string, remaining time.Duration) {
+			ts.warnings <- reason
+		},
+	})
+}
+
+func receive[T any](t *testing.T, ch <-chan T, what string) T {
+	t.Helper()
+
+	select {
+	case v := <-ch:
+		return v
+	case <-time.After(testTimeout):
+		t.Fatalf("timed out waiting for %s", what)
+		panic("unreachable")
+	}
+}
+
+// waitForState skips other state changes until the state is reported
+func waitForState(t *testing.T, states <-chan signalling.StateEvent, state string) signalling.StateEvent {
+	t.Helper()
+
+	for {
+		if event := receive(t, states, state+" state"); event.State == state {
+			return event
+		}
+	}
+}
+
+func TestStreamAnswersOffer(t *testing.T) {
+	monitor := &testMonitor{}
+	s := newTestStreamer(&config.Configuration{}, testSource{}, monitor)
+	session := newTestSession()
+
+	session.stream(s, "offer", nil)
+
+	if answer := receive(t, session.answers, "answer"); answer != "answer:offer" {
+		t.Errorf("unexpected answer: %s", answer)
+	}
+	if !s.Streaming() {
+		t.Error("not streaming after the answer")
+	}
+	if paused, reports := monitor.state(); paused != 1 || len(reports) != 1 {
+		t.Errorf("monitor not paused while streaming or report not handled: %d %v", paused, reports)
+	}
+
+	if !s.Stop() {
+		t.Fatal("stopping did not find the session")
+	}
+	event := waitForState(t, session.states, signalling.StateSessionEnded)
+	if event.Reason != "streaming process finished" {
+		t.Errorf("unexpected end of session: %s", event.Reason)
+	}
+	if paused, _ := monitor.state(); paused != 0 {
+		t.Error("monitor not resumed after streaming")
+	}
+
+	if s.Stop() {
+		t.Error("stopping found a session which ended")
+	}
+}
+
+// blockingMonitor blocks in Pause until released, like a monitor process
+// which takes a while to exit
+type blockingMonitor struct {
+	testMonitor
+	pausing chan struct{}
+	release chan struct{}
+}
+
+func (m *blockingMonitor) Pause() {
+	m.pausing <- struct{}{}
+	<-m.release
+	m.testMonitor.Pause()
+}
+
+func TestStreamPausesMonitorWithoutLock(t *testing.T) {
+	monitor := &blockingMonitor{pausing: make(chan struct{}, 1), release: make(chan struct{})}
+	s := newTestStreamer(&config.Configuration{}, testSource{}, monitor)
+	session := newTestSession()
+
+	go session.stream(s, "offer", nil)
+	receive(t, monitor.pausing, "monitor pause")
+
+	streaming := make(chan bool)
+	go func() {
+		streaming <- s.Streaming()
+	}()
+	if !receive(t, streaming, "streaming state while pausing") {
+		t.Error("session not active while the monitor pauses")
+	}
+
+	select {
+	case answer := <-session.answers:
+		t.Fatalf("streaming started before the monitor paused: %s", answer)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(monitor.release)
+	receive(t, session.answers, "answer")
+
+	s.Stop()
+	waitForState(t, session.states, signalling.StateSessionEnded)
+	if paused, _ := monitor.state(); paused != 0 {
+		t.Errorf("monitor not resumed after streaming: %d", paused)
+	}
+}
+
+func TestStreamTricklesCandidates(t *testing.T) {
+	s := newTestStreamer(&config.Configuration{IceTrickling: true}, testSource{}, nil)
+	session := newTestSession()
+	remoteCandidates := make(chan string, 1)
+
+	session.stream(s, "offer", remoteCandidates)
+	defer s.Stop()
+
+	receive(t, session.answers, "answer")
+	if candidate := receive(t, session.candidates, "candidate"); candidate != "local" {
+		t.Errorf("unexpected candidate: %s", candidate)
+	}
+
+	remoteCandidates <- "remote"
+	if event := waitForState(t, session.states, signalling.StateConnected); event.Reason != CANDIDATE+"remote" {
+		t.Errorf("remote candidate not passed to the streaming process: %s", event.Reason)
+	}
+}
+
+func TestStreamForwardsCaptureSettings(t *testing.T) {
+	conf := &config.Configuration{Overlay: &config.OverlayConfiguration{}}
+	s := newTestStreamer(conf, testSource{}, nil)
+	session := newTestSession()
+
+	session.stream(s, "offer", nil)
+	defer s.Stop()
+
+	receive(t, session.answers, "answer")
+	s.settings.SetOverlayText("changed")
+	if event := waitForState(t, session.states, signalling.StateConnected); event.Reason != OVERLAY+`"changed"` {
+		t.Errorf("overlay text not passed to the streaming process: %s", event.Reason)
+	}
+}
+
+func TestStreamRefusedWhileRecording(t *testing.T) {
+	monitor := &testMonitor{}
+	s := newTestStreamer(&config.Configuration{}, testSource{}, monitor)
+	if err := s.Devices.Acquire(UseRecording); err != nil {
+		t.Fatal(err)
+	}
+
+	session := newTestSession()
+	session.stream(s, "offer", nil)
+	if err := receive(t, session.errors, "refusal"); err != ErrDevicesRecording.Error() {
+		t.Errorf("unexpected refusal: %s", err)
+	}
+	if paused, _ := monitor.state(); paused != 0 || s.Streaming() {
+		t.Errorf("refused session holds the devices: %d", paused)
+	}
+
+	// Once the recording has finished viewers stream, and recordings are
+	// refused meanwhile
+	s.Devices.Release(UseRecording)
+	session.stream(s, "offer", nil)
+	defer s.Stop()
+	receive(t, session.answers, "answer")
+	if err := s.Devices.Acquire(UseRecording); err != ErrDevicesStreaming {
+		t.Errorf("recording not refused while streaming: %v", err)
+	}
+}
+
+func TestStreamRefusedWhileStreaming(t *testing.T) {
+	s := newTestStreamer(&config.Configuration{}, testSource{}, nil)
+	first := newTestSession()
+
+	first.stream(s, "first", nil)
+	defer s.Stop()
+	receive(t, first.answers, "first answer")
+
+	second := newTestSession()
+	second.stream(s, "second", nil)
+	receive(t, second.errors, "refusal")
+	if !s.Streaming() {
+		t.Error("refused session ended the running one")
+	}
+}
+
+func TestStreamPreemptsOnReconnect(t *testing.T) {
+	s := newTestStreamer(&config.Configuration{DisconnectOnReconnect: true}, testSource{}, nil)
+	first := newTestSession()
+
+	first.stream(s, "first", nil)
+	receive(t, first.answers, "first answer")
+
+	second := newTestSession()
+	second.stream(s, "second", nil)
+	defer s.Stop()
+
+	waitForState(t, first.states, signalling.StateSessionEnded)
+	if reason := receive(t, first.kicks, "kick"); reason != "session was taken over by another viewer" {
+		t.Errorf("unexpected reason for the kick: %s", reason)
+	}
+	if answer := receive(t, second.answers, "second answer"); answer != "answer:second" {
+		t.Errorf("unexpected answer: %s", answer)
+	}
+}
+
+func TestStreamMaxViewers(t *testing.T) {
+	monitor := &testMonitor{}
+	conf := &config.Configuration{Viewers: &config.ViewerConfiguration{MaxViewers: 2}}
+	s := newTestStreamer(conf, testSource{}, monitor)
+
+	first, second, third := newTestSession(), newTestSession(), newTestSession()
+	first.stream(s, "first", nil)
+	second.stream(s, "second", nil)
+	defer s.Stop()
+	receive(t, first.answers, "first answer")
+	receive(t, second.answers, "second answer")
+
+	third.stream(s, "third", nil)
+	if err := receive(t, third.errors, "refusal"); err != errStreaming.Error() {
+		t.Errorf("unexpected error: %s", err)
+	}
+	if paused, _ := monitor.state(); paused != 2 {
+		t.Errorf("monitor not paused once per session: %d", paused)
+	}
+}
+
+func TestStreamQueue(t *testing.T) {
+	conf := &config.Configuration{Viewers: &config.ViewerConfiguration{Queue: true, MaxQueued: 2}}
+	s := newTestStreamer(conf, testSource{}, nil)
+	defer s.Stop()
+
+	first := newTestSession()
+	first.stream(s, "first", nil)
+	receive(t, first.answers, "first answer")
+
+	// Queued sessions wait in Stream until they are admitted
+	second, third, fourth := newTestSession(), newTestSession(), newTestSession()
+	go second.stream(s, "second", nil)
+	if position := receive(t, second.positions, "second position"); position != 1 {
+		t.Errorf("unexpected position: %d", position)
+	}
+	go third.stream(s, "third", nil)
+	if position := receive(t, third.positions, "third position"); position != 2 {
+		t.Errorf("unexpected position: %d", position)
+	}
+	fourth.stream(s, "fourth", nil)
+	if err := receive(t, fourth.errors, "refusal"); err != errStreaming.Error() {
+		t.Errorf("full queue accepted a session: %s", err)
+	}
+
+	if queued := s.sessions.Get("third"); queued == nil || queued.QueuePosition() != 2 {
+		t.Fatalf("queued session not registered with its position: %v", queued)
+	}
+
+	s.StopSession("first", "")
+	if answer := receive(t, second.answers, "second answer"); answer != "answer:second" {
+		t.Errorf("unexpected answer: %s", answer)
+	}
+	if position := receive(t, third.positions, "third position"); position != 1 {
+		t.Errorf("queue did not move up: %d", position)
+	}
+
+	s.StopSession("third", "queue closed")
+	if reason := receive(t, third.kicks, "kick"); reason != "queue closed" {
+		t.Errorf("unexpected reason for the kick: %s", reason)
+	}
+	receive(t, third.errors, "error")
+}
+
+func TestStreamPreemptsByPriority(t *testing.T) {
+	conf := &config.Configuration{Viewers: &config.ViewerConfiguration{MaxViewers: 2, Preemption: config.PreemptionPriority}}
+	s := newTestStreamer(conf, testSource{}, nil)
+	defer s.Stop()
+
+	low, high := newTestSession(), newTestSession()
+	low.streamAs(s, Viewer{Id: "low", Preempt: true, Priority: 1}, "low", nil)
+	high.streamAs(s, Viewer{Id: "high", Preempt: true, Priority: 5}, "high", nil)
+	receive(t, low.answers, "low answer")
+	receive(t, high.answers, "high answer")
+
+	// Equal priorities do not preempt each other
+	equal := newTestSession()
+	equal.streamAs(s, Viewer{Id: "equal", Preempt: true, Priority: 1}, "equal", nil)
+	receive(t, equal.errors, "refusal")
+
+	higher := newTestSession()
+	higher.streamAs(s, Viewer{Id: "higher", Preempt: true, Priority: 3}, "higher", nil)
+	if reason := receive(t, low.kicks, "kick"); reason != "session was taken over by a viewer with a higher priority" {
+		t.Errorf("unexpected reason for the kick: %s", reason)
+	}
+	receive(t, higher.answers, "higher answer")
+	if s.sessions.Get("high").Stopped() {
+		t.Error("session with a higher priority preempted")
+	}
+}
+
+func TestStreamPreemptionNotPermitted(t *testing.T) {
+	s := newTestStreamer(&config.Configuration{DisconnectOnReconnect: true}, testSource{}, nil)
+	first := newTestSession()
+
+	first.stream(s, "first", nil)
+	defer s.Stop()
+	receive(t, first.answers, "first answer")
+
+	second := newTestSession()
+	s.Stream(Viewer{Id: "second"}, "second", nil, Handlers{Error: func(err string) {
+		second.errors <- err
+	}})
+	if err := receive(t, second.errors, "refusal"); err != errStreaming.Error() {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
+
+func TestStreamFailure(t *testing.T) {
+	s := newTestStreamer(&config.Configuration{}, testSource{}, nil)
+	session := newTestSession()
+
+	session.stream(s, failingOffer, nil)
+
+	if err := receive(t, session.errors, "error"); err != "Error while creating stream: 3" {
+		t.Errorf("unexpected error: %s", err)
+	}
+	if event := waitForState(t, session.states, signalling.StateSessionEnded); event.Reason != "streaming process exited with code: 3" {
+		t.Errorf("unexpected end of session: %s", event.Reason)
+	}
+	if s.Streaming() {
+		t.Error("streaming after the streaming process failed")
+	}
+}
+
+func TestStreamSourceError(t *testing.T) {
+	s := newTestStreamer(&config.Configuration{}, testSource{err: errors.New("no camera")}, nil)
+	session := newTestSession()
+
+	session.stream(s, "offer", nil)
+
+	receive(t, session.errors, "error")
+	if s.Streaming() {
+		t.Error("streaming without a source")
+	}
+}
+
+func TestStreamStopSession(t *testing.T) {
+	s := newTestStreamer(&config.Configuration{}, testSource{}, nil)
+	session := newTestSession()
+
+	session.stream(s, "offer", nil)
+	receive(t, session.answers, "answer")
+
+	sessions := s.Sessions()
+	if len(sessions) != 1 || sessions[0].Id != "offer" {
+		t.Fatalf("unexpected sessions: %v", sessions)
+	}
+	if stats := sessions[0].Stats(); stats.CandidateType != "host" || stats.Bitrate != 1000 {
+		t.Errorf("stats of the streaming process not recorded: %v", stats)
+	}
+	if sessions[0].HiddenSince().IsZero() {
+		t.Error("visibility reported by the streaming process not recorded")
+	}
+	if s.StopSession("other", "") {
+		t.Error("stopping an unknown session found one")
+	}
+	if !s.StopSession("offer", "kicked") {
+		t.Fatal("stopping did not find the session")
+	}
+
+	if event := waitForState(t, session.states, signalling.StateSessionEnded); event.Reason != "kicked" {
+		t.Errorf("reason for stopping not reported: %s", event.Reason)
+	}
+	if sessions := s.Sessions(); len(sessions) != 0 {
+		t.Errorf("ended session still registered: %v", sessions)
+	}
+}
+
+func TestStreamDuplicateSession(t *testing.T) {
+	s := newTestStreamer(&config.Configuration{DisconnectOnReconnect: true}, testSource{}, nil)
+	first := newTestSession()
+
+	first.stream(s, "offer", nil)
+	defer s.Stop()
+	receive(t, first.answers, "first answer")
+
+	second := newTestSession()
+	second.stream(s, "offer", nil)
+	if err := receive(t, second.errors, "refusal"); err != errDuplicateSession.Error() {
+		t.Errorf("unexpected error: %s", err)
+	}
+	if !s.Streaming() {
+		t.Error("duplicate session ended the running one")
+	}
+}
+
+// startConcurrently streams the offers at the same time and returns once all
+// requests have returned
+func startConcurrently(s *Streamer, offers []string) []*testSession {
+	sessions := make([]*testSession, len(offers))
+	var wg sync.WaitGroup
+	for i, offer := range offers {
+		sessions[i] = newTestSession()
+		wg.Add(1)
+		go func(session *testSession, offer string) {
+			defer wg.Done()
+			session.stream(s, offer, nil)
+		}(sessions[i], offer)
+	}
+
+	// Poke at the streamer while the sessions are starting
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for _, offer := range offers {
+			s.Streaming()
+			s.Sessions()
+			s.StopSession(offer+"-unknown", "")
+		}
+	}()
+
+	wg.Wait()
+	return sessions
+}
+
+func TestStreamConcurrentRefusal(t *testing.T) {
+	monitor := &testMonitor{}
+	s := newTestStreamer(&config.Configuration{}, testSource{}, monitor)
+
+	sessions := startConcurrently(s, []string{"a", "b", "c", "d", "e"})
+	defer s.Stop()
+
+	answered := 0
+	for _, session := range sessions {
+		select {
+		case <-session.answers:
+			answered++
+		case <-session.errors:
+		}
+	}
+	if answered != 1 {
+		t.Errorf("%d sessions answered instead of one", answered)
+	}
+	if paused, _ := monitor.state(); paused != 1 {
+		t.Errorf("refused sessions did not resume the monitor: %d", paused)
+	}
+}
+
+func TestStreamConcurrentPreemption(t *testing.T) {
+	monitor := &testMonitor{}
+	s := newTestStreamer(&config.Configuration{DisconnectOnReconnect: true}, testSource{}, monitor)
+
+	sessions := startConcurrently(s, []string{"a", "b", "c", "d", "e"})
+	for _, session := range sessions {
+		select {
+		case <-session.answers:
+		case <-session.errors:
+		}
+	}
+
+	s.Stop()
+	deadline := time.Now().Add(testTimeout)
+	for len(s.Sessions()) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("sessions did not end: %v", s.Sessions())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if s.Streaming() {
+		t.Error("streaming after all sessions ended")
+	}
+	if paused, _ := monitor.state(); paused != 0 {
+		t.Errorf("monitor not resumed after all sessions ended: %d", paused)
+	}
+}
+
+func TestStreamMaxDuration(t *testing.T) {
+	s := newTestStreamer(&config.Configuration{}, testSource{}, nil)
+	session := newTestSession()
+
+	session.streamAs(s, Viewer{Id: "offer", MaxDuration: 200 * time.Millisecond}, "offer", nil)
+	defer s.Stop()
+	receive(t, session.answers, "answer")
+
+	// The limit is shorter than the warning time, the viewer is warned at once
+	if warning := receive(t, session.warnings, "warning"); warning != durationReason {
+		t.Errorf("unexpected warning: %s", warning)
+	}
+	if reason := receive(t, session.kicks, "kick"); reason != durationReason {
+		t.Errorf("unexpected reason for the kick: %s", reason)
+	}
+	if event := waitForState(t, session.states, signalling.StateSessionEnded); event.Reason != durationReason {
+		t.Errorf("unexpected end of session: %s", event.Reason)
+	}
+}
+
+func TestStreamIdleTimeout(t *testing.T) {
+	conf := &config.Configuration{Viewers: &config.ViewerConfiguration{IdleTimeout: 1, Warning: 1}}
+	s := newTestStreamer(conf, testSource{}, nil)
+	session := newTestSession()
+
+	session.stream(s, "offer", nil)
+	defer s.Stop()
+	receive(t, session.answers, "answer")
+
+	if s.SetVisible("other", false) {
+		t.Error("visibility of an unknown session set")
+	}
+
+	// Showing the stream again resets the timeout
+	s.SetVisible("offer", true)
+	s.SetVisible("offer", false)
+	if warning := receive(t, session.warnings, "warning"); warning != idleReason {
+		t.Errorf("unexpected warning: %s", warning)
+	}
+	s.SetVisible("offer", true)
+	s.SetVisible("offer", false)
+	receive(t, session.warnings, "second warning")
+
+	if reason := receive(t, session.kicks, "kick"); reason != idleReason {
+		t.Errorf("unexpected reason for the kick: %s", reason)
+	}
+}
@@ -0,0 +1,111 @@
+/*
+ Copyright (c) 2024 Neeraj Jakhar
+
+ This program is free software: you can redistribute it and/or modify
+ it under the terms of the GNU General Public License as published by
+ the Free Software Foundation, either version 3 of the License, or
+ (at your option) any later version.
+
+ This program is distributed in the hope that it will be useful,
+ but WITHOUT ANY WARRANTY; without even the implied warranty of
+ MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License
+ along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/homebackend/go-webrtc/pkg/config"
+	"github.com/homebackend/go-webrtc/pkg/session"
+)
+
+// Cause reported to viewers whose session was ended using the api
+const kickReason = "kicked by an administrator"
+
+type SessionInfo struct {
+	Id            string    `json:"id"`
+	User          string    `json:"user"`
+	RemoteAddress string    `json:"remote_address"`
+	Stream        string    `json:"stream"`
+	Started       time.Time `json:"started"`
+	Codecs        []string  `json:"codecs"`
+	CandidateType string    `json:"candidate_type"`
+	Bitrate       uint64    `json:"bitrate"`
+	// Position of a session waiting for a free slot, 1 being next
+	QueuePosition int `json:"queue_position,omitempty"`
+}
+
+// PendingClient is a signalling client which has not been authorized yet
+type PendingClient struct {
+	Id            string    `json:"id"`
+	RemoteAddress string    `json:"remote_address"`
+	Connected     time.Time `json:"connected"`
+}
+
+type SessionList struct {
+	Sessions     []SessionInfo   `json:"sessions"`
+	Unauthorized []PendingClient `json:"unauthorized"`
+}
+
+// listSessions describes the streaming sessions and, if there is a manager,
+// its clients which have not been authorized
+func listSessions(sessions session.SessionManager, manager *Manager) SessionList {
+	list := SessionList{Sessions: []SessionInfo{}, Unauthorized: []PendingClient{}}
+	for _, s := range sessions.Sessions() {
+		stats := s.Stats()
+		list.Sessions = append(list.Sessions, SessionInfo{
+			Id:            s.Id,
+			User:          s.User,
+			RemoteAddress: s.RemoteAddress,
+			Stream:        s.Stream,
+			Started:       s.Started,
+			Codecs:        stats.Codecs,
+			CandidateType: stats.CandidateType,
+			Bitrate:       stats.Bitrate,
+			QueuePosition: s.QueuePosition(),
+		})
+	}
+
+	if manager != nil {
+		for _, c := range manager.unauthorizedClients() {
+			list.Unauthorized = append(list.Unauthorized, PendingClient{Id: c.id, RemoteAddress: c.remoteAddr, Connected: c.connected})
+		}
+	}
+
+	return list
+}
+
+// serveSessions lists the streaming sessions and the clients which have not
+// been authorized. A session is kicked by deleting it, with its id given in
+// the id query parameter.
+func serveSessions(conf *config.Configuration, sessions session.SessionManager, manager *Manager) http.HandlerFunc {
+	return withPermission(conf, config.PermissionKick, func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, http.StatusOK, listSessions(sessions, manager))
+		case http.MethodDelete:
+			id := r.URL.Query().Get("id")
+			if id == "" {
+				http.Error(w, "Session id is required", http.StatusBadRequest)
+				return
+			}
+
+			if !sessions.StopSession(id, kickReason) {
+				http.Error(w, "Unknown session", http.StatusNotFound)
+				return
+			}
+
+			log.Printf("Session %s kicked", id)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
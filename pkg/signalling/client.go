@@ -0,0 +1,80 @@
+/*
+ Copyright (c) 2024 Neeraj Jakhar
+
+ This program is free software: you can redistribute it and/or modify
+ it under the terms of the GNU General Public License as published by
+ the Free Software Foundation, either version 3 of the License, or
+ (at your option) any later version.
+
+ This program is distributed in the hope that it will be useful,
+ but WITHOUT ANY WARRANTY; without even the implied warranty of
+ MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License
+ along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package signalling
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/pion/webrtc/v3"
+)
+
+var (
+	// ErrorStreamEnded is reported when the server ends the stream
+	ErrorStreamEnded = errors.New("stream ended")
+	// ErrorClosed is reported when the client is used after it was closed
+	ErrorClosed = errors.New("client closed")
+)
+
+// Options configure a client
+type Options struct {
+	// Credentials to connect with, sent in the connect event in websocket
+	// mode and using basic authentication in http mode
+	User     string
+	Password string
+	// Header is added to the requests, e.g. an Origin checked by the server
+	Header http.Header
+	// HttpClient sends the requests of http mode, http.DefaultClient if nil
+	HttpClient *http.Client
+}
+
+// Client receives a stream from a gowebrtc server
+type Client interface {
+	// Connect sends the offer and returns the answer of the server
+	Connect(ctx context.Context, offer webrtc.SessionDescription) (webrtc.SessionDescription, error)
+	// ConnectPeer exchanges the offer of the peer connection with the
+	// answer of the server, including the candidates of both ends
+	ConnectPeer(ctx context.Context, pc *webrtc.PeerConnection) error
+	// Disconnect ends the stream
+	Disconnect(ctx context.Context) error
+	// Done is closed if the server ends the stream, after which Err
+	// returns the reason. Servers in http mode cannot end a stream.
+	Done() <-chan struct{}
+	Err() error
+}
+
+// Dial returns a client for the signalling mode of the url: websocket for
+// ws and wss urls and http for http and https urls
+func Dial(ctx context.Context, signallingUrl string, options *Options) (Client, error) {
+	u, err := url.Parse(signallingUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	switch u.Scheme {
+	case "ws", "wss":
+		return DialWebsocket(ctx, signallingUrl, options)
+	case "http", "https":
+		return NewHttpClient(signallingUrl, options), nil
+	}
+
+	return nil, fmt.Errorf("unsupported signalling url %s, use ws, wss, http or https", signallingUrl)
+}
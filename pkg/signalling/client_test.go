@@ -0,0 +1,373 @@
+/*
+ Copyright (c) 2024 Neeraj Jakhar
+
+ This program is free software: you can redistribute it and/or modify
+ it under the terms of the GNU General Public License as published by
+ the Free Software Foundation, either version 3 of the License, or
+ (at your option) any later version.
+
+ This program is distributed in the hope that it will be useful,
+ but WITHOUT ANY WARRANTY; without even the implied warranty of
+ MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License
+ along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package signalling
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/pion/webrtc/v3"
+)
+
+const (
+	testUser     = "viewer"
+	testPassword = "secret"
+	testTimeout  = 10 * time.Second
+)
+
+// newTestPeer returns a peer connection which only uses loopback candidates
+func newTestPeer(t *testing.T) *webrtc.PeerConnection {
+	t.Helper()
+
+	m := &webrtc.MediaEngine{}
+	if err := m.RegisterDefaultCodecs(); err != nil {
+		t.Fatal(err)
+	}
+
+	s := webrtc.SettingEngine{}
+	s.SetIncludeLoopbackCandidate(true)
+	s.SetNetworkTypes([]webrtc.NetworkType{webrtc.NetworkTypeUDP4})
+	s.SetInterfaceFilter(func(name string) bool { return name == "lo" })
+
+	pc, err := webrtc.NewAPI(webrtc.WithMediaEngine(m), webrtc.WithSettingEngine(s)).NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+
+	return pc
+}
+
+// waitConnected waits for a peer connection to connect
+func waitConnected(t *testing.T, pc *webrtc.PeerConnection) {
+	t.Helper()
+
+	connected := make(chan struct{})
+	var once sync.Once
+	pc.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		if state == webrtc.PeerConnectionStateConnected {
+			once.Do(func() { close(connected) })
+		}
+	})
+	if pc.ConnectionState() == webrtc.PeerConnectionStateConnected {
+		return
+	}
+
+	select {
+	case <-connected:
+	case <-time.After(testTimeout):
+		t.Fatal("Timed out waiting for connection")
+	}
+}
+
+// testServer answers connect events like the server does in trickle mode:
+// the answer is sent first and the candidates are trickled after it
+type testServer struct {
+	t          *testing.T
+	pc         *webrtc.PeerConnection
+	candidates chan webrtc.ICECandidateInit
+	ended      chan struct{}
+}
+
+func reply(conn *websocket.Conn, lock *sync.Mutex, request Event, eventType string, payload interface{}) {
+	event, _ := NewEvent(eventType, payload)
+	if request.Id != "" {
+		event = event.ReplyTo(request)
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	conn.WriteJSON(event)
+}
+
+func (s *testServer) serve(w http.ResponseWriter, r *http.Request) {
+	conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	defer close(s.ended)
+
+	var lock sync.Mutex
+	for {
+		var event Event
+		if err := conn.ReadJSON(&event); err != nil {
+			return
+		}
+
+		switch event.Type {
+		case EventConnect:
+			var connectEvent ConnectEvent
+			if err := DecodePayload(event, &connectEvent); err != nil {
+				reply(conn, &lock, event, EventError, ErrorEvent{Code: ErrorCodeInvalidPayload, Message: err.Error()})
+				continue
+			}
+			if connectEvent.User != testUser || connectEvent.Password != testPassword {
+				reply(conn, &lock, Event{}, EventDisconnect, DisconnectEvent{Message: ErrorInvalidCredentials.Error()})
+				reply(conn, &lock, event, EventError, ErrorEvent{Code: ErrorCodeInvalidCredentials, Message: ErrorInvalidCredentials.Error()})
+				return
+			}
+			reply(conn, &lock, event, EventConnectAck, ConnectAckEvent{Version: ProtocolVersion})
+
+			offer, err := DecodeDescription(connectEvent.SDP)
+			if err != nil {
+				s.t.Error(err)
+				return
+			}
+			s.pc.OnICECandidate(func(candidate *webrtc.ICECandidate) {
+				if candidate != nil {
+					reply(conn, &lock, event, EventNewCandidate, NewCandidateEvent{Candidate: candidate.ToJSON()})
+				}
+			})
+			if err := s.pc.SetRemoteDescription(offer); err != nil {
+				s.t.Error(err)
+				return
+			}
+			answer, err := s.pc.CreateAnswer(nil)
+			if err != nil {
+				s.t.Error(err)
+				return
+			}
+			encoded, _ := EncodeDescription(answer)
+			reply(conn, &lock, event, EventAnswer, AnswerEvent{Answer: encoded})
+			reply(conn, &lock, Event{}, EventState, StateEvent{State: StateConnecting})
+			if err := s.pc.SetLocalDescription(answer); err != nil {
+				s.t.Error(err)
+				return
+			}
+		case EventCandidate:
+			var candidateEvent CandidateEvent
+			if err := DecodePayload(event, &candidateEvent); err != nil {
+				s.t.Error(err)
+				continue
+			}
+			s.candidates <- candidateEvent.Candidate
+			if candidateEvent.Candidate.Candidate != "" {
+				if err := s.pc.AddICECandidate(candidateEvent.Candidate); err != nil {
+					s.t.Error(err)
+				}
+			}
+		case EventStats:
+			reply(conn, &lock, event, EventStats, StatsEvent{Motion: true})
+		case EventDisconnect:
+			reply(conn, &lock, Event{}, EventState, StateEvent{State: StateSessionEnded, Reason: "viewer-disconnected"})
+			return
+		}
+	}
+}
+
+func startTestServer(t *testing.T) (*testServer, string) {
+	t.Helper()
+
+	s := &testServer{
+		t:          t,
+		pc:         newTestPeer(t),
+		candidates: make(chan webrtc.ICECandidateInit, 64),
+		ended:      make(chan struct{}),
+	}
+	if _, err := s.pc.AddTransceiverFromKind(webrtc.RTPCodecTypeVideo); err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(server.Close)
+
+	return s, "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+func newTestClient(t *testing.T, url, password string) *WebsocketClient {
+	t.Helper()
+
+	client, err := DialWebsocket(context.Background(), url, &Options{User: testUser, Password: password})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	return client
+}
+
+func TestWebsocketClientConnectsPeer(t *testing.T) {
+	server, url := startTestServer(t)
+	client := newTestClient(t, url, testPassword)
+
+	var states []string
+	var lock sync.Mutex
+	client.OnState(func(state StateEvent) {
+		lock.Lock()
+		defer lock.Unlock()
+		states = append(states, state.State)
+	})
+
+	pc := newTestPeer(t)
+	if _, err := pc.AddTransceiverFromKind(webrtc.RTPCodecTypeVideo, webrtc.RTPTransceiverInit{Direction: webrtc.RTPTransceiverDirectionRecvonly}); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	if err := client.ConnectPeer(ctx, pc); err != nil {
+		t.Fatal(err)
+	}
+	if client.Version() != ProtocolVersion {
+		t.Errorf("Unexpected protocol version %d", client.Version())
+	}
+
+	waitConnected(t, pc)
+	waitConnected(t, server.pc)
+
+	// The candidates are trickled and end with an empty candidate
+	for ended := false; !ended; {
+		select {
+		case candidate := <-server.candidates:
+			ended = candidate.Candidate == ""
+		case <-time.After(testTimeout):
+			t.Fatal("Timed out waiting for the end of the candidates")
+		}
+	}
+
+	stats, err := client.Stats(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !stats.Motion {
+		t.Error("Stats reply not received")
+	}
+
+	if err := client.Disconnect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-server.ended:
+	case <-time.After(testTimeout):
+		t.Fatal("Server did not see the disconnect")
+	}
+	if !errors.Is(client.Err(), ErrorClosed) {
+		t.Errorf("Unexpected error after disconnect: %v", client.Err())
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	if len(states) == 0 || states[0] != StateConnecting {
+		t.Errorf("Unexpected states %v", states)
+	}
+}
+
+func TestWebsocketClientInvalidCredentials(t *testing.T) {
+	_, url := startTestServer(t)
+	client := newTestClient(t, url, "wrong")
+
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	_, err := client.Connect(ctx, webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: "v=0"})
+	if !errors.Is(err, ErrorStreamEnded) || !strings.Contains(err.Error(), ErrorInvalidCredentials.Error()) {
+		t.Fatalf("Expected invalid credentials, got %v", err)
+	}
+
+	select {
+	case <-client.Done():
+	case <-time.After(testTimeout):
+		t.Fatal("Client not done after being disconnected")
+	}
+}
+
+func TestStreamEndsOnSessionEnded(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		var lock sync.Mutex
+		reply(conn, &lock, Event{}, EventState, StateEvent{State: StateSessionEnded, Reason: "replaced"})
+		conn.ReadMessage()
+	}))
+	defer server.Close()
+
+	client := newTestClient(t, "ws"+strings.TrimPrefix(server.URL, "http"), testPassword)
+	select {
+	case <-client.Done():
+	case <-time.After(testTimeout):
+		t.Fatal("Client not done after the session ended")
+	}
+	if !errors.Is(client.Err(), ErrorStreamEnded) || !strings.Contains(client.Err().Error(), "replaced") {
+		t.Errorf("Unexpected error %v", client.Err())
+	}
+}
+
+func TestHttpClient(t *testing.T) {
+	var deleted bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, password, _ := r.BasicAuth(); user != testUser || password != testPassword {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodPost:
+			var request Request
+			if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			offer, err := DecodeDescription(request.SDP)
+			if err != nil || offer.Type != webrtc.SDPTypeOffer {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			answer, _ := EncodeDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: "answer"})
+			json.NewEncoder(w).Encode(Response{SDP: answer})
+		case http.MethodDelete:
+			deleted = true
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	client, err := Dial(ctx, server.URL, &Options{User: testUser, Password: testPassword})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	answer, err := client.Connect(ctx, webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: "offer"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if answer.Type != webrtc.SDPTypeAnswer || answer.SDP != "answer" {
+		t.Errorf("Unexpected answer %v", answer)
+	}
+
+	if err := client.Disconnect(ctx); err != nil || !deleted {
+		t.Errorf("Stream not deleted: %v", err)
+	}
+
+	unauthorized := NewHttpClient(server.URL, nil)
+	if _, err := unauthorized.Connect(ctx, webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: "offer"}); err == nil {
+		t.Error("Connected without credentials")
+	}
+}
@@ -0,0 +1,257 @@
+/*
+ Copyright (c) 2024 Neeraj Jakhar
+
+ This program is free software: you can redistribute it and/or modify
+ it under the terms of the GNU General Public License as published by
+ the Free Software Foundation, either version 3 of the License, or
+ (at your option) any later version.
+
+ This program is distributed in the hope that it will be useful,
+ but WITHOUT ANY WARRANTY; without even the implied warranty of
+ MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License
+ along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+// Package signalling implements the signalling protocol of gowebrtc. It
+// contains the events exchanged in websocket, sse and MQTT signalling modes,
+// the messages of http signalling mode and clients for the websocket and
+// http modes.
+package signalling
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/pion/webrtc/v3"
+)
+
+// ProtocolVersion is the latest signalling protocol version supported by the server
+const ProtocolVersion = 1
+
+const (
+	ErrorCodeMalformedMessage   = "malformed_message"
+	ErrorCodeInvalidPayload     = "invalid_payload"
+	ErrorCodeUnsupportedEvent   = "unsupported_event"
+	ErrorCodeUnauthorized       = "unauthorized"
+	ErrorCodeInvalidCredentials = "invalid_credentials"
+	ErrorCodeForbidden          = "forbidden"
+	ErrorCodeUnknownSession     = "unknown_session"
+	ErrorCodeTooManyCandidates  = "too_many_candidates"
+	ErrorCodeInternal           = "internal_error"
+)
+
+// ProtocolError is an error which is reported back to the client as an error event
+type ProtocolError struct {
+	Code    string
+	Message string
+}
+
+func (e *ProtocolError) Error() string {
+	return e.Message
+}
+
+var (
+	ErrorInvalidCredentials = &ProtocolError{Code: ErrorCodeInvalidCredentials, Message: "invalid credentials"}
+	ErrorUnauthorized       = &ProtocolError{Code: ErrorCodeUnauthorized, Message: "unauthorized"}
+	ErrorForbidden          = &ProtocolError{Code: ErrorCodeForbidden, Message: "forbidden"}
+)
+
+var validate = validator.New()
+
+type Event struct {
+	Id      string          `json:"id,omitempty" validate:"omitempty,max=64"`
+	Type    string          `json:"type" validate:"required"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+const (
+	EventConnect      = "connect"
+	EventConnectAck   = "connect_ack"
+	EventSession      = "session"
+	EventAnswer       = "answer"
+	EventCandidate    = "candidate"
+	EventNewCandidate = "new_candidate"
+	EventDisconnect   = "disconnect"
+	EventState        = "state"
+	EventError        = "error"
+	EventMotion       = "motion"
+	EventSound        = "sound"
+	EventStats        = "stats"
+	EventQueue        = "queue"
+	EventKick         = "kick"
+	EventWarning      = "warning"
+	EventVisibility   = "visibility"
+)
+
+const (
+	StateConnecting    = "connecting"
+	StateConnected     = "connected"
+	StateDisconnected  = "disconnected"
+	StateFailed        = "failed"
+	StatePipelineError = "pipeline-error"
+	StateSessionEnded  = "session-ended"
+	// The source of the stream failed and is restarted, a placeholder is
+	// streamed meanwhile
+	StateDeviceLost      = "device-lost"
+	StateDeviceRecovered = "device-recovered"
+)
+
+type ConnectEvent struct {
+	Version  int    `json:"version" validate:"omitempty,gte=1"`
+	SDP      string `json:"sdp" validate:"required,base64"`
+	User     string `json:"user"`
+	Password string `json:"password"`
+}
+
+type ConnectAckEvent struct {
+	Version int `json:"version"`
+}
+
+type SessionEvent struct {
+	Session string `json:"session" validate:"required"`
+}
+
+type AnswerEvent struct {
+	Answer string `json:"answer" validate:"required"`
+}
+
+// DisconnectEvent may be sent without payload by clients predating the
+// message
+type DisconnectEvent struct {
+	Message string `json:"message"`
+}
+
+type CandidateEvent struct {
+	Candidate webrtc.ICECandidateInit `json:"candidate" validate:"required"`
+}
+
+type NewCandidateEvent struct {
+	Candidate webrtc.ICECandidateInit `json:"candidate" validate:"required"`
+}
+
+type StateEvent struct {
+	State  string `json:"state" validate:"required"`
+	Reason string `json:"reason,omitempty"`
+}
+
+type MotionEvent struct {
+	Motion bool `json:"motion"`
+}
+
+type SoundEvent struct {
+	Sound bool `json:"sound"`
+}
+
+// StatsEvent is the reply to a stats request, which has no payload. The
+// audio level is only set while some process is capturing audio.
+type StatsEvent struct {
+	Motion     bool     `json:"motion"`
+	Sound      bool     `json:"sound"`
+	AudioLevel *float64 `json:"audio_level"`
+}
+
+// QueueEvent tells a viewer waiting for a free slot its position, 1 being
+// next
+type QueueEvent struct {
+	Position int `json:"position" validate:"gte=1"`
+}
+
+// KickEvent tells a viewer why its session was ended by somebody else
+type KickEvent struct {
+	Reason string `json:"reason"`
+}
+
+// WarningEvent tells a viewer that its session will be ended for the reason
+// in the given number of seconds
+type WarningEvent struct {
+	Reason  string `json:"reason"`
+	Seconds int    `json:"seconds"`
+}
+
+// VisibilityEvent is sent by viewers when they show or hide the stream, e.g.
+// when the browser tab is hidden
+type VisibilityEvent struct {
+	Visible bool `json:"visible"`
+}
+
+type ErrorEvent struct {
+	Code    string `json:"code" validate:"required"`
+	Message string `json:"message"`
+}
+
+// Err converts the event back into the protocol error it reports
+func (e ErrorEvent) Err() error {
+	return &ProtocolError{Code: e.Code, Message: e.Message}
+}
+
+// Request starts a stream in http signalling mode
+type Request struct {
+	SDP string `json:"sdp" binding:"required,base64"`
+}
+
+// Response is the answer to a Request
+type Response struct {
+	SDP string `json:"sdp"`
+}
+
+// NewEvent returns an event with the payload
+func NewEvent(eventType string, payload interface{}) (Event, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return Event{}, err
+	}
+
+	return Event{Type: eventType, Payload: data}, nil
+}
+
+// ReplyTo marks the event as the reply to the given request
+func (e Event) ReplyTo(request Event) Event {
+	e.Id = request.Id
+	return e
+}
+
+// DecodePayload unmarshals the event payload and validates the result
+func DecodePayload(event Event, payload interface{}) error {
+	if len(event.Payload) == 0 {
+		return &ProtocolError{Code: ErrorCodeInvalidPayload, Message: fmt.Sprintf("missing payload for %s event", event.Type)}
+	}
+
+	if err := json.Unmarshal(event.Payload, payload); err != nil {
+		return &ProtocolError{Code: ErrorCodeInvalidPayload, Message: fmt.Sprintf("invalid %s payload: %v", event.Type, err)}
+	}
+
+	if err := validate.Struct(payload); err != nil {
+		return &ProtocolError{Code: ErrorCodeInvalidPayload, Message: fmt.Sprintf("invalid %s payload: %v", event.Type, err)}
+	}
+
+	return nil
+}
+
+// EncodeDescription encodes a session description the way the server
+// expects offers and sends answers, as base64 encoded JSON
+func EncodeDescription(description webrtc.SessionDescription) (string, error) {
+	data, err := json.Marshal(description)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(data), nil
+}
+
+// DecodeDescription decodes a session description encoded by EncodeDescription
+func DecodeDescription(encoded string) (webrtc.SessionDescription, error) {
+	var description webrtc.SessionDescription
+
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return description, err
+	}
+
+	err = json.Unmarshal(data, &description)
+	return description, err
+}
@@ -0,0 +1,150 @@
+/*
+ Copyright (c) 2024 Neeraj Jakhar
+
+ This program is free software: you can redistribute it and/or modify
+ it under the terms of the GNU General Public License as published by
+ the Free Software Foundation, either version 3 of the License, or
+ (at your option) any later version.
+
+ This program is distributed in the hope that it will be useful,
+ but WITHOUT ANY WARRANTY; without even the implied warranty of
+ MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License
+ along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package signalling
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// HttpClient receives streams from a server in http signalling mode, which
+// exchanges the offer and answer in a single request without trickling
+// candidates
+type HttpClient struct {
+	url     string
+	options Options
+}
+
+func NewHttpClient(url string, options *Options) *HttpClient {
+	c := &HttpClient{url: url}
+	if options != nil {
+		c.options = *options
+	}
+	if c.options.HttpClient == nil {
+		c.options.HttpClient = http.DefaultClient
+	}
+
+	return c
+}
+
+func (c *HttpClient) request(ctx context.Context, method string, body io.Reader) (*http.Response, error) {
+	request, err := http.NewRequestWithContext(ctx, method, c.url, body)
+	if err != nil {
+		return nil, err
+	}
+
+	for name, values := range c.options.Header {
+		request.Header[name] = values
+	}
+	request.Header.Set("Content-Type", "application/json")
+	if c.options.User != "" {
+		request.SetBasicAuth(c.options.User, c.options.Password)
+	}
+
+	return c.options.HttpClient.Do(request)
+}
+
+// Connect requests a stream. The server answers once the stream has been
+// started, the offer should therefore contain all local candidates.
+func (c *HttpClient) Connect(ctx context.Context, offer webrtc.SessionDescription) (webrtc.SessionDescription, error) {
+	sdp, err := EncodeDescription(offer)
+	if err != nil {
+		return webrtc.SessionDescription{}, err
+	}
+
+	body, err := json.Marshal(Request{SDP: sdp})
+	if err != nil {
+		return webrtc.SessionDescription{}, err
+	}
+
+	response, err := c.request(ctx, http.MethodPost, bytes.NewReader(body))
+	if err != nil {
+		return webrtc.SessionDescription{}, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		var message map[string]string
+		json.NewDecoder(response.Body).Decode(&message)
+		return webrtc.SessionDescription{}, fmt.Errorf("server returned %s: %s", response.Status, message["message"])
+	}
+
+	var answer Response
+	if err := json.NewDecoder(response.Body).Decode(&answer); err != nil {
+		return webrtc.SessionDescription{}, err
+	}
+
+	return DecodeDescription(answer.SDP)
+}
+
+// ConnectPeer waits for the peer connection to gather its candidates and
+// sends them with the offer
+func (c *HttpClient) ConnectPeer(ctx context.Context, pc *webrtc.PeerConnection) error {
+	offer, err := pc.CreateOffer(nil)
+	if err != nil {
+		return err
+	}
+
+	gathered := webrtc.GatheringCompletePromise(pc)
+	if err := pc.SetLocalDescription(offer); err != nil {
+		return err
+	}
+
+	select {
+	case <-gathered:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	answer, err := c.Connect(ctx, *pc.LocalDescription())
+	if err != nil {
+		return err
+	}
+
+	return pc.SetRemoteDescription(answer)
+}
+
+// Disconnect stops the stream of the server
+func (c *HttpClient) Disconnect(ctx context.Context) error {
+	response, err := c.request(ctx, http.MethodDelete, nil)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+
+	if response.StatusCode != http.StatusNoContent && response.StatusCode != http.StatusOK {
+		return fmt.Errorf("server returned %s", response.Status)
+	}
+
+	return nil
+}
+
+// Done is never closed, as the server cannot end a stream in http mode
+func (c *HttpClient) Done() <-chan struct{} {
+	return nil
+}
+
+func (c *HttpClient) Err() error {
+	return nil
+}
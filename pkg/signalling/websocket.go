@@ -0,0 +1,415 @@
+/*
+ Copyright (c) 2024 Neeraj Jakhar
+
+ This program is free software: you can redistribute it and/or modify
+ it under the terms of the GNU General Public License as published by
+ the Free Software Foundation, either version 3 of the License, or
+ (at your option) any later version.
+
+ This program is distributed in the hope that it will be useful,
+ but WITHOUT ANY WARRANTY; without even the implied warranty of
+ MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License
+ along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package signalling
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/gorilla/websocket"
+	"github.com/pion/webrtc/v3"
+)
+
+// Replies buffered for a request until they are read
+const pendingReplies = 8
+
+// WebsocketClient receives streams from a server in websocket signalling
+// mode. Requests are tagged with ids and matched with their replies, other
+// events are passed to the handlers.
+type WebsocketClient struct {
+	sync.Mutex
+	conn        *websocket.Conn
+	options     Options
+	writeLock   sync.Mutex
+	nextId      int
+	replies     map[string]chan Event
+	version     int
+	onCandidate func(webrtc.ICECandidateInit)
+	onState     func(StateEvent)
+	onEvent     func(Event)
+	done        chan struct{}
+	once        sync.Once
+	err         error
+}
+
+// DialWebsocket opens a websocket connection to the server
+func DialWebsocket(ctx context.Context, url string, options *Options) (*WebsocketClient, error) {
+	c := &WebsocketClient{
+		replies: make(map[string]chan Event),
+		done:    make(chan struct{}),
+	}
+	if options != nil {
+		c.options = *options
+	}
+
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, url, c.options.Header)
+	if err != nil {
+		return nil, err
+	}
+	c.conn = conn
+
+	go c.read()
+	return c, nil
+}
+
+// OnCandidate sets the handler of the candidates trickled by the server
+func (c *WebsocketClient) OnCandidate(handler func(webrtc.ICECandidateInit)) {
+	c.Lock()
+	defer c.Unlock()
+	c.onCandidate = handler
+}
+
+// OnState sets the handler of the state changes of the stream
+func (c *WebsocketClient) OnState(handler func(StateEvent)) {
+	c.Lock()
+	defer c.Unlock()
+	c.onState = handler
+}
+
+// OnEvent sets the handler of the events which are neither replies,
+// candidates nor state changes, e.g. motion and sound events
+func (c *WebsocketClient) OnEvent(handler func(Event)) {
+	c.Lock()
+	defer c.Unlock()
+	c.onEvent = handler
+}
+
+// Version returns the protocol version acknowledged by the server
+func (c *WebsocketClient) Version() int {
+	c.Lock()
+	defer c.Unlock()
+	return c.version
+}
+
+func (c *WebsocketClient) end(err error) {
+	c.once.Do(func() {
+		c.err = err
+		close(c.done)
+	})
+}
+
+func (c *WebsocketClient) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err returns why the connection ended, nil while it has not
+func (c *WebsocketClient) Err() error {
+	select {
+	case <-c.done:
+		return c.err
+	default:
+		return nil
+	}
+}
+
+// Send sends an event to the server
+func (c *WebsocketClient) Send(event Event) error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	return c.conn.WriteJSON(event)
+}
+
+// request sends an event with a new id and returns the channel receiving
+// its replies. The channel has to be released with forget.
+func (c *WebsocketClient) request(eventType string, payload interface{}) (string, chan Event, error) {
+	event, err := NewEvent(eventType, payload)
+	if err != nil {
+		return "", nil, err
+	}
+
+	c.Lock()
+	c.nextId++
+	event.Id = strconv.Itoa(c.nextId)
+	replies := make(chan Event, pendingReplies)
+	c.replies[event.Id] = replies
+	c.Unlock()
+
+	if err := c.Send(event); err != nil {
+		c.forget(event.Id)
+		return "", nil, err
+	}
+
+	return event.Id, replies, nil
+}
+
+func (c *WebsocketClient) forget(id string) {
+	c.Lock()
+	defer c.Unlock()
+	delete(c.replies, id)
+}
+
+// reply waits for the next reply to a request
+func (c *WebsocketClient) reply(ctx context.Context, replies chan Event) (Event, error) {
+	select {
+	case event := <-replies:
+		if event.Type == EventError {
+			var errorEvent ErrorEvent
+			if err := DecodePayload(event, &errorEvent); err != nil {
+				return event, err
+			}
+			return event, errorEvent.Err()
+		}
+		return event, nil
+	case <-c.done:
+		return Event{}, c.err
+	case <-ctx.Done():
+		return Event{}, ctx.Err()
+	}
+}
+
+// dispatch passes an event received from the server to the request it
+// replies to or to the handlers
+func (c *WebsocketClient) dispatch(event Event) {
+	c.Lock()
+	replies := c.replies[event.Id]
+	onCandidate, onState, onEvent := c.onCandidate, c.onState, c.onEvent
+	c.Unlock()
+
+	switch event.Type {
+	case EventNewCandidate:
+		var candidateEvent NewCandidateEvent
+		if err := DecodePayload(event, &candidateEvent); err == nil && onCandidate != nil {
+			onCandidate(candidateEvent.Candidate)
+		}
+		return
+	case EventState:
+		var stateEvent StateEvent
+		if err := DecodePayload(event, &stateEvent); err != nil {
+			return
+		}
+		if onState != nil {
+			onState(stateEvent)
+		}
+		switch stateEvent.State {
+		case StateFailed, StatePipelineError, StateSessionEnded:
+			c.end(fmt.Errorf("%w: %s %s", ErrorStreamEnded, stateEvent.State, stateEvent.Reason))
+		}
+		return
+	case EventDisconnect:
+		var disconnectEvent DisconnectEvent
+		DecodePayload(event, &disconnectEvent)
+		c.end(fmt.Errorf("%w: disconnected by server: %s", ErrorStreamEnded, disconnectEvent.Message))
+	}
+
+	if replies != nil {
+		select {
+		case replies <- event:
+		default:
+		}
+	} else if onEvent != nil {
+		onEvent(event)
+	}
+}
+
+func (c *WebsocketClient) read() {
+	for {
+		var event Event
+		if err := c.conn.ReadJSON(&event); err != nil {
+			c.end(fmt.Errorf("%w: %v", ErrorStreamEnded, err))
+			return
+		}
+
+		c.dispatch(event)
+	}
+}
+
+// connect sends the connect event and waits for the answer. sent is called
+// once the server has the connect event, before the answer arrives.
+func (c *WebsocketClient) connect(ctx context.Context, offer webrtc.SessionDescription, sent func()) (webrtc.SessionDescription, error) {
+	sdp, err := EncodeDescription(offer)
+	if err != nil {
+		return webrtc.SessionDescription{}, err
+	}
+
+	connectEvent := ConnectEvent{Version: ProtocolVersion, SDP: sdp, User: c.options.User, Password: c.options.Password}
+	id, replies, err := c.request(EventConnect, connectEvent)
+	if err != nil {
+		return webrtc.SessionDescription{}, err
+	}
+	defer c.forget(id)
+
+	if sent != nil {
+		sent()
+	}
+
+	for {
+		event, err := c.reply(ctx, replies)
+		if err != nil {
+			return webrtc.SessionDescription{}, err
+		}
+
+		switch event.Type {
+		case EventConnectAck:
+			var ackEvent ConnectAckEvent
+			if err := DecodePayload(event, &ackEvent); err != nil {
+				return webrtc.SessionDescription{}, err
+			}
+			c.Lock()
+			c.version = ackEvent.Version
+			c.Unlock()
+		case EventAnswer:
+			var answerEvent AnswerEvent
+			if err := DecodePayload(event, &answerEvent); err != nil {
+				return webrtc.SessionDescription{}, err
+			}
+			return DecodeDescription(answerEvent.Answer)
+		}
+	}
+}
+
+// Connect authenticates with the credentials of the options, sends the offer
+// and returns the answer. Candidates gathered later have to be sent with
+// SendCandidate, the candidates of the server are passed to the OnCandidate
+// handler.
+func (c *WebsocketClient) Connect(ctx context.Context, offer webrtc.SessionDescription) (webrtc.SessionDescription, error) {
+	return c.connect(ctx, offer, nil)
+}
+
+// ConnectPeer connects the peer connection, trickling the candidates of both
+// ends. It replaces the OnCandidate handler of the client and the
+// OnICECandidate handler of the peer connection.
+func (c *WebsocketClient) ConnectPeer(ctx context.Context, pc *webrtc.PeerConnection) error {
+	var lock sync.Mutex
+	var connected, answered bool
+	var local, remote []webrtc.ICECandidateInit
+
+	// Candidates are held back until the server knows the offer, and the
+	// candidates of the server until the peer connection knows the answer
+	pc.OnICECandidate(func(candidate *webrtc.ICECandidate) {
+		// A nil candidate marks the end of the candidates
+		var init webrtc.ICECandidateInit
+		if candidate != nil {
+			init = candidate.ToJSON()
+		}
+
+		lock.Lock()
+		defer lock.Unlock()
+		if connected {
+			c.SendCandidate(init)
+		} else {
+			local = append(local, init)
+		}
+	})
+
+	c.OnCandidate(func(candidate webrtc.ICECandidateInit) {
+		lock.Lock()
+		defer lock.Unlock()
+		if answered {
+			pc.AddICECandidate(candidate)
+		} else {
+			remote = append(remote, candidate)
+		}
+	})
+
+	offer, err := pc.CreateOffer(nil)
+	if err != nil {
+		return err
+	}
+	if err := pc.SetLocalDescription(offer); err != nil {
+		return err
+	}
+
+	answer, err := c.connect(ctx, offer, func() {
+		lock.Lock()
+		defer lock.Unlock()
+		connected = true
+		for _, candidate := range local {
+			c.SendCandidate(candidate)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := pc.SetRemoteDescription(answer); err != nil {
+		return err
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	answered = true
+	for _, candidate := range remote {
+		if err := pc.AddICECandidate(candidate); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// SendCandidate trickles a local candidate to the server. An empty
+// candidate marks the end of the candidates.
+func (c *WebsocketClient) SendCandidate(candidate webrtc.ICECandidateInit) error {
+	event, err := NewEvent(EventCandidate, CandidateEvent{Candidate: candidate})
+	if err != nil {
+		return err
+	}
+
+	return c.Send(event)
+}
+
+// SendVisibility tells the server whether the stream is shown, sessions which
+// are not shown are ended after the idle timeout
+func (c *WebsocketClient) SendVisibility(visible bool) error {
+	event, err := NewEvent(EventVisibility, VisibilityEvent{Visible: visible})
+	if err != nil {
+		return err
+	}
+
+	return c.Send(event)
+}
+
+// Stats requests the motion and sound state of the server
+func (c *WebsocketClient) Stats(ctx context.Context) (StatsEvent, error) {
+	var statsEvent StatsEvent
+
+	id, replies, err := c.request(EventStats, struct{}{})
+	if err != nil {
+		return statsEvent, err
+	}
+	defer c.forget(id)
+
+	event, err := c.reply(ctx, replies)
+	if err != nil {
+		return statsEvent, err
+	}
+
+	err = json.Unmarshal(event.Payload, &statsEvent)
+	return statsEvent, err
+}
+
+// Disconnect ends the stream and closes the connection
+func (c *WebsocketClient) Disconnect(ctx context.Context) error {
+	event, err := NewEvent(EventDisconnect, DisconnectEvent{Message: "client disconnected"})
+	if err != nil {
+		return err
+	}
+
+	err = c.Send(event)
+	c.Close()
+	return err
+}
+
+// Close closes the connection without ending the stream first
+func (c *WebsocketClient) Close() error {
+	c.end(ErrorClosed)
+	return c.conn.Close()
+}
@@ -0,0 +1,110 @@
+/*
+ Copyright (c) 2024 Neeraj Jakhar
+
+ This program is free software: you can redistribute it and/or modify
+ it under the terms of the GNU General Public License as published by
+ the Free Software Foundation, either version 3 of the License, or
+ (at your option) any later version.
+
+ This program is distributed in the hope that it will be useful,
+ but WITHOUT ANY WARRANTY; without even the implied warranty of
+ MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License
+ along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/homebackend/go-webrtc/pkg/session"
+)
+
+const (
+	DefaultSoundThreshold = -30
+	DefaultSoundHold      = 10
+)
+
+// Audio levels older than this are not reported anymore
+const levelStaleAfter = 2 * time.Second
+
+func (m *Monitor) soundThreshold() float64 {
+	if m.config.Sound.Threshold == nil {
+		return DefaultSoundThreshold
+	}
+
+	return *m.config.Sound.Threshold
+}
+
+func (m *Monitor) soundHold() time.Duration {
+	if m.config.Sound.Hold == 0 {
+		return DefaultSoundHold * time.Second
+	}
+
+	return time.Duration(m.config.Sound.Hold) * time.Second
+}
+
+// handleLevelReport starts sound once the level stayed above the threshold
+// for the configured duration. Sound then lasts until the level has been
+// below the threshold for the hold time.
+func (m *Monitor) handleLevelReport(report string) {
+	var levelReport session.LevelReport
+	if err := json.Unmarshal([]byte(report), &levelReport); err != nil {
+		log.Printf("Invalid level report from child: %v", err)
+		return
+	}
+
+	if m.config.Sound == nil {
+		return
+	}
+
+	now := time.Now()
+
+	m.Lock()
+	m.level = levelReport.Level
+	m.lastLevel = now
+
+	if levelReport.Level < m.soundThreshold() {
+		m.loudSince = time.Time{}
+		m.Unlock()
+		return
+	}
+
+	if m.loudSince.IsZero() {
+		m.loudSince = now
+	}
+	if now.Sub(m.loudSince) < time.Duration(m.config.Sound.Duration*float64(time.Second)) {
+		m.Unlock()
+		return
+	}
+
+	m.lastLoud = now
+	started := !m.sound
+	m.sound = true
+	m.Unlock()
+
+	if started {
+		time.AfterFunc(m.soundHold(), m.checkSoundEnded)
+		m.notifier.Notify(NotificationSoundStarted, map[string]interface{}{"level": levelReport.Level})
+	}
+}
+
+func (m *Monitor) checkSoundEnded() {
+	m.Lock()
+	remaining := m.soundHold() - time.Since(m.lastLoud)
+	if remaining > 0 {
+		m.Unlock()
+		time.AfterFunc(remaining, m.checkSoundEnded)
+		return
+	}
+
+	m.sound = false
+	m.Unlock()
+
+	m.notifier.Notify(NotificationSoundStopped, nil)
+}
@@ -0,0 +1,55 @@
+/*
+ Copyright (c) 2024 Neeraj Jakhar
+
+ This program is free software: you can redistribute it and/or modify
+ it under the terms of the GNU General Public License as published by
+ the Free Software Foundation, either version 3 of the License, or
+ (at your option) any later version.
+
+ This program is distributed in the hope that it will be useful,
+ but WITHOUT ANY WARRANTY; without even the implied warranty of
+ MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License
+ along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"testing"
+
+	"github.com/homebackend/go-webrtc/pkg/config"
+	"gopkg.in/yaml.v3"
+)
+
+func TestSoundThreshold(t *testing.T) {
+	for _, test := range []struct {
+		config string
+		sound  bool
+	}{
+		// -20 dBFS is loud with the default threshold of -30 dBFS
+		{config: "hold: 10", sound: true},
+		{config: "threshold: -25", sound: true},
+		// 0 dBFS is a threshold of its own, not the default
+		{config: "threshold: 0", sound: false},
+	} {
+		var sound config.SoundConfiguration
+		if err := yaml.Unmarshal([]byte(test.config), &sound); err != nil {
+			t.Fatal(err)
+		}
+
+		notifier := NewNotifier()
+		started := false
+		notifier.Subscribe(func(notification Notification) {
+			started = started || notification.Type == NotificationSoundStarted
+		})
+		monitor := NewMonitor("", &config.Configuration{Sound: &sound}, nil, notifier)
+
+		monitor.handleLevelReport(`{"level": -20}`)
+		if started != test.sound {
+			t.Errorf("Sound started with %s: %t", test.config, started)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+/*
+ Copyright (c) 2024 Neeraj Jakhar
+
+ This program is free software: you can redistribute it and/or modify
+ it under the terms of the GNU General Public License as published by
+ the Free Software Foundation, either version 3 of the License, or
+ (at your option) any later version.
+
+ This program is distributed in the hope that it will be useful,
+ but WITHOUT ANY WARRANTY; without even the implied warranty of
+ MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License
+ along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+// Package turn runs the internal TURN server and provides the ICE servers
+// which point the streaming processes at it.
+package turn
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"syscall"
+
+	"github.com/homebackend/go-webrtc/pkg/config"
+	pionturn "github.com/pion/turn/v2"
+	"github.com/pion/webrtc/v3"
+	"golang.org/x/sys/unix"
+)
+
+// Check validates the configuration of the internal TURN server
+func Check(conf *config.TurnConfiguration) error {
+	if conf == nil {
+		return errors.New("turn server is enabled but configuration not provided")
+	}
+
+	if len(conf.Users) == 0 {
+		return errors.New("at least one user needs to be provided for server")
+	}
+
+	return nil
+}
+
+// Start starts the TURN server with a UDP listener per configured thread.
+// The server runs until it is closed.
+func Start(conf *config.TurnConfiguration) (*pionturn.Server, error) {
+	if err := Check(conf); err != nil {
+		return nil, err
+	}
+
+	addr, err := net.ResolveUDPAddr("udp", "0.0.0.0:"+strconv.Itoa(conf.UdpPort))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse server address: %w", err)
+	}
+
+	usersMap := map[string][]byte{}
+	for _, userPassword := range conf.Users {
+		usersMap[userPassword.User] = pionturn.GenerateAuthKey(userPassword.User, conf.Realm, userPassword.Password)
+	}
+
+	listenerConfig := &net.ListenConfig{
+		Control: func(network, address string, conn syscall.RawConn) error {
+			var operr error
+			if err := conn.Control(func(fd uintptr) {
+				operr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+			}); err != nil {
+				return err
+			}
+
+			return operr
+		},
+	}
+
+	relayAddressGenerator := &pionturn.RelayAddressGeneratorStatic{
+		RelayAddress: net.ParseIP(conf.PublicIp),
+		Address:      "0.0.0.0",
+	}
+
+	packetConnConfigs := make([]pionturn.PacketConnConfig, conf.Threads)
+	for i := 0; i < conf.Threads; i++ {
+		log.Printf("Network: %s, address: %s\n", addr.Network(), addr.String())
+		conn, listErr := listenerConfig.ListenPacket(context.Background(), addr.Network(), addr.String())
+		if listErr != nil {
+			for _, c := range packetConnConfigs[:i] {
+				c.PacketConn.Close()
+			}
+			return nil, fmt.Errorf("failed to allocate UDP listener at %s:%s: %w", addr.Network(), addr.String(), listErr)
+		}
+
+		packetConnConfigs[i] = pionturn.PacketConnConfig{
+			PacketConn:            conn,
+			RelayAddressGenerator: relayAddressGenerator,
+		}
+
+		log.Printf("Server %d listening on %s\n", i, conn.LocalAddr().String())
+	}
+
+	s, err := pionturn.NewServer(pionturn.ServerConfig{
+		Realm: conf.Realm,
+		AuthHandler: func(username string, realm string, srcAddr net.Addr) ([]byte, bool) { // nolint: revive
+			if key, ok := usersMap[username]; ok {
+				return key, true
+			}
+			return nil, false
+		},
+		PacketConnConfigs: packetConnConfigs,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create TURN server: %w", err)
+	}
+
+	return s, nil
+}
+
+// IceServers returns the STUN and TURN servers of the internal TURN server
+// for every configured user, as used by the streaming processes on the same
+// host
+func IceServers(conf *config.TurnConfiguration) []webrtc.ICEServer {
+	iceServers := make([]webrtc.ICEServer, 0, 2*len(conf.Users))
+	for _, user := range conf.Users {
+		iceServers = append(iceServers,
+			webrtc.ICEServer{
+				URLs:       []string{fmt.Sprintf("stun:%s:%d", "127.0.0.1", conf.UdpPort)},
+				Username:   user.User,
+				Credential: user.Password,
+			},
+			webrtc.ICEServer{
+				URLs:       []string{fmt.Sprintf("turn:%s:%d", "127.0.0.1", conf.UdpPort)},
+				Username:   user.User,
+				Credential: user.Password,
+			})
+	}
+
+	return iceServers
+}
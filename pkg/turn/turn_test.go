@@ -0,0 +1,125 @@
+/*
+ Copyright (c) 2024 Neeraj Jakhar
+
+ This program is free software: you can redistribute it and/or modify
+ it under the terms of the GNU General Public License as published by
+ the Free Software Foundation, either version 3 of the License, or
+ (at your option) any later version.
+
+ This program is distributed in the hope that it will be useful,
+ but WITHOUT ANY WARRANTY; without even the implied warranty of
+ MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License
+ along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package turn
+
+import (
+	"net"
+	"testing"
+
+	"github.com/homebackend/go-webrtc/pkg/config"
+	pionturn "github.com/pion/turn/v2"
+)
+
+func testConfiguration() *config.TurnConfiguration {
+	return &config.TurnConfiguration{
+		TurnType: config.TurnInternal,
+		PublicIp: "127.0.0.1",
+		Users:    []config.UserCredentials{{User: "user", Password: "secret"}},
+		Realm:    "test",
+		Threads:  1,
+	}
+}
+
+func TestCheck(t *testing.T) {
+	if err := Check(nil); err == nil {
+		t.Error("missing configuration accepted")
+	}
+
+	conf := testConfiguration()
+	conf.Users = nil
+	if err := Check(conf); err == nil {
+		t.Error("configuration without users accepted")
+	}
+
+	if err := Check(testConfiguration()); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestIceServers(t *testing.T) {
+	conf := testConfiguration()
+	conf.UdpPort = 3478
+	conf.Users = append(conf.Users, config.UserCredentials{User: "other", Password: "password"})
+
+	iceServers := IceServers(conf)
+	if len(iceServers) != 4 {
+		t.Fatalf("expected a STUN and TURN server per user, got %v", iceServers)
+	}
+
+	if iceServers[0].URLs[0] != "stun:127.0.0.1:3478" || iceServers[1].URLs[0] != "turn:127.0.0.1:3478" {
+		t.Errorf("unexpected urls: %v %v", iceServers[0].URLs, iceServers[1].URLs)
+	}
+	if iceServers[3].Username != "other" || iceServers[3].Credential != "password" {
+		t.Errorf("unexpected credentials: %v", iceServers[3])
+	}
+}
+
+func TestServerAllocatesRelay(t *testing.T) {
+	conf := testConfiguration()
+	conf.UdpPort = freePort(t)
+
+	server, err := Start(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	addr := (&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: conf.UdpPort}).String()
+	client, err := pionturn.NewClient(&pionturn.ClientConfig{
+		STUNServerAddr: addr,
+		TURNServerAddr: addr,
+		Conn:           conn,
+		Username:       "user",
+		Password:       "secret",
+		Realm:          conf.Realm,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	if err := client.Listen(); err != nil {
+		t.Fatal(err)
+	}
+
+	relay, err := client.Allocate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer relay.Close()
+
+	if ip := relay.LocalAddr().(*net.UDPAddr).IP; !ip.Equal(net.ParseIP(conf.PublicIp)) {
+		t.Errorf("relay address %s is not the public ip", ip)
+	}
+}
+
+func freePort(t *testing.T) int {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	return conn.LocalAddr().(*net.UDPAddr).Port
+}
@@ -0,0 +1,479 @@
+/*
+ Copyright (c) 2024 Neeraj Jakhar
+
+ This program is free software: you can redistribute it and/or modify
+ it under the terms of the GNU General Public License as published by
+ the Free Software Foundation, either version 3 of the License, or
+ (at your option) any later version.
+
+ This program is distributed in the hope that it will be useful,
+ but WITHOUT ANY WARRANTY; without even the implied warranty of
+ MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License
+ along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/go-gst/go-gst/gst"
+	"github.com/go-gst/go-gst/gst/app"
+	"github.com/homebackend/go-webrtc/pkg/media"
+	"github.com/homebackend/go-webrtc/pkg/signalling"
+	"github.com/pion/interceptor"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media/ivfwriter"
+	"github.com/pion/webrtc/v3/pkg/media/oggwriter"
+)
+
+const (
+	DefaultViewTimeout   = 30
+	DefaultViewIceServer = "stun:stun.l.google.com:19302"
+	viewStatsInterval    = 5 * time.Second
+	viewEosTimeout       = 5 * time.Second
+)
+
+// ViewOptions describe how the view command receives a stream and what it
+// does with it
+type ViewOptions struct {
+	Url        string
+	User       string
+	Password   string
+	Origin     string
+	IceServers []string
+	Duration   time.Duration
+	Timeout    time.Duration
+	Outputs    []string
+	VideoSink  string
+	AudioSink  string
+}
+
+// rtpWriter writes the packets of a track to a file
+type rtpWriter interface {
+	WriteRTP(packet *rtp.Packet) error
+	Close() error
+}
+
+// viewPipeline returns a pipeline which is fed the RTP packets of the tracks
+// through appsrc elements named videosrc and audiosrc. It muxes them into a
+// webm file and plays them with the sinks. An empty description is returned
+// if there is nothing to do.
+func viewPipeline(webm, videoSink, audioSink string) string {
+	branches := []struct {
+		kind    string
+		depay   string
+		decoder string
+		sink    string
+	}{
+		{"video", "rtpvp8depay", "vp8dec ! videoconvert", videoSink},
+		{"audio", "rtpopusdepay", "opusdec ! audioconvert ! audioresample", audioSink},
+	}
+
+	var pipeline []string
+	for _, branch := range branches {
+		var consumers []string
+		if webm != "" {
+			consumers = append(consumers, "queue ! mux.")
+		}
+		if branch.sink != "" {
+			consumers = append(consumers, fmt.Sprintf("queue ! %s ! %s", branch.decoder, branch.sink))
+		}
+		if len(consumers) == 0 {
+			continue
+		}
+
+		pipeline = append(pipeline, fmt.Sprintf("appsrc name=%ssrc format=time is-live=true do-timestamp=true ! rtpjitterbuffer ! %s ! tee name=%stee", branch.kind, branch.depay, branch.kind))
+		for _, consumer := range consumers {
+			pipeline = append(pipeline, fmt.Sprintf("%stee. ! %s", branch.kind, consumer))
+		}
+	}
+
+	if webm != "" {
+		pipeline = append(pipeline, fmt.Sprintf("webmmux name=mux ! filesink location=%s", media.Quote(webm)))
+	}
+
+	return strings.Join(pipeline, " ")
+}
+
+// rtpCaps returns the caps of the RTP packets of a track
+func rtpCaps(track *webrtc.TrackRemote) string {
+	codec := track.Codec()
+	_, encoding, _ := strings.Cut(codec.MimeType, "/")
+
+	return fmt.Sprintf("application/x-rtp, media=%s, encoding-name=%s, clock-rate=%d, payload=%d",
+		track.Kind(), strings.ToUpper(encoding), codec.ClockRate, track.PayloadType())
+}
+
+// trackStats counts the packets received on a track
+type trackStats struct {
+	lock    sync.Mutex
+	packets uint64
+	bytes   uint64
+	lost    uint64
+	lastSeq uint16
+	started bool
+	first   chan struct{}
+}
+
+func (s *trackStats) add(packet *rtp.Packet, size int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.packets++
+	s.bytes += uint64(size)
+
+	if !s.started {
+		s.started = true
+		close(s.first)
+	} else if gap := packet.SequenceNumber - s.lastSeq; gap >= 0x8000 {
+		// Late packets do not move the sequence back
+		return
+	} else if gap > 1 {
+		s.lost += uint64(gap - 1)
+	}
+	s.lastSeq = packet.SequenceNumber
+}
+
+func (s *trackStats) String() string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return fmt.Sprintf("%d packets, %d bytes, %d lost", s.packets, s.bytes, s.lost)
+}
+
+// viewer receives a stream and hands the packets to the outputs
+type viewer struct {
+	options  *ViewOptions
+	pc       *webrtc.PeerConnection
+	writers  map[webrtc.RTPCodecType][]rtpWriter
+	pipeline *gst.Pipeline
+	sources  map[webrtc.RTPCodecType]*app.Source
+	stats    map[webrtc.RTPCodecType]*trackStats
+	state    chan webrtc.PeerConnectionState
+	start    time.Time
+	// Tracks delivered once the viewer is closed are not read, so that
+	// closing can wait for the readers
+	readers     sync.WaitGroup
+	readersLock sync.Mutex
+	closed      bool
+}
+
+func (v *viewer) setupOutputs() error {
+	var webm string
+	for _, output := range v.options.Outputs {
+		switch strings.ToLower(filepath.Ext(output)) {
+		case ".ivf":
+			writer, err := ivfwriter.New(output)
+			if err != nil {
+				return err
+			}
+			v.writers[webrtc.RTPCodecTypeVideo] = append(v.writers[webrtc.RTPCodecTypeVideo], writer)
+		case ".ogg":
+			writer, err := oggwriter.New(output, 48000, 2)
+			if err != nil {
+				return err
+			}
+			v.writers[webrtc.RTPCodecTypeAudio] = append(v.writers[webrtc.RTPCodecTypeAudio], writer)
+		case ".webm":
+			if webm != "" {
+				return errors.New("only one webm output is supported")
+			}
+			webm = output
+		default:
+			return fmt.Errorf("unsupported output %s, use .ivf, .ogg or .webm", output)
+		}
+	}
+
+	description := viewPipeline(webm, v.options.VideoSink, v.options.AudioSink)
+	if description == "" {
+		return nil
+	}
+
+	gst.Init(nil)
+	log.Printf("Creating pipeline: %s\n", description)
+	pipeline, err := gst.NewPipelineFromString(description)
+	if err != nil {
+		return err
+	}
+	v.pipeline = pipeline
+
+	for kind, name := range map[webrtc.RTPCodecType]string{webrtc.RTPCodecTypeVideo: "videosrc", webrtc.RTPCodecTypeAudio: "audiosrc"} {
+		if element, err := pipeline.GetElementByName(name); err == nil {
+			v.sources[kind] = app.SrcFromElement(element)
+		}
+	}
+
+	return pipeline.SetState(gst.StatePlaying)
+}
+
+func (v *viewer) setupPeerConnection() error {
+	m := &webrtc.MediaEngine{}
+	if err := m.RegisterDefaultCodecs(); err != nil {
+		return err
+	}
+
+	i := &interceptor.Registry{}
+	if err := webrtc.RegisterDefaultInterceptors(m, i); err != nil {
+		return err
+	}
+
+	api := webrtc.NewAPI(webrtc.WithMediaEngine(m), webrtc.WithInterceptorRegistry(i))
+	pc, err := api.NewPeerConnection(webrtc.Configuration{
+		ICEServers: []webrtc.ICEServer{{URLs: v.options.IceServers}},
+	})
+	if err != nil {
+		return err
+	}
+	v.pc = pc
+
+	pc.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		log.Printf("Connection state: %s\n", state)
+		select {
+		case v.state <- state:
+		default:
+		}
+	})
+	pc.OnTrack(v.readTrack)
+
+	for _, kind := range []webrtc.RTPCodecType{webrtc.RTPCodecTypeAudio, webrtc.RTPCodecTypeVideo} {
+		if _, err := pc.AddTransceiverFromKind(kind, webrtc.RTPTransceiverInit{Direction: webrtc.RTPTransceiverDirectionRecvonly}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (v *viewer) readTrack(track *webrtc.TrackRemote, _ *webrtc.RTPReceiver) {
+	kind := track.Kind()
+	stats, ok := v.stats[kind]
+	if !ok {
+		return
+	}
+
+	v.readersLock.Lock()
+	if v.closed {
+		v.readersLock.Unlock()
+		return
+	}
+	v.readers.Add(1)
+	v.readersLock.Unlock()
+	defer v.readers.Done()
+
+	log.Printf("Receiving %s track: %s\n", kind, track.Codec().MimeType)
+
+	source := v.sources[kind]
+	if source != nil {
+		source.SetCaps(gst.NewCapsFromString(rtpCaps(track)))
+	}
+
+	buffer := make([]byte, 1500)
+	for {
+		n, _, err := track.Read(buffer)
+		if err != nil {
+			return
+		}
+
+		var packet rtp.Packet
+		if err := packet.Unmarshal(buffer[:n]); err != nil {
+			log.Println(err)
+			continue
+		}
+		stats.add(&packet, n)
+
+		for _, writer := range v.writers[kind] {
+			if err := writer.WriteRTP(&packet); err != nil {
+				log.Println(err)
+			}
+		}
+
+		if source != nil {
+			source.PushBuffer(gst.NewBufferFromBytes(bytes.Clone(buffer[:n])))
+		}
+	}
+}
+
+// failedState returns whether the connection cannot recover from a state.
+// Disconnected connections may still recover.
+func failedState(state webrtc.PeerConnectionState) bool {
+	return state == webrtc.PeerConnectionStateFailed || state == webrtc.PeerConnectionStateClosed
+}
+
+// waitForMedia waits for the first packet of each track
+func (v *viewer) waitForMedia(client signalling.Client) error {
+	timeout := time.After(v.options.Timeout)
+	for _, kind := range []webrtc.RTPCodecType{webrtc.RTPCodecTypeVideo, webrtc.RTPCodecTypeAudio} {
+		for received := false; !received; {
+			select {
+			case <-v.stats[kind].first:
+				received = true
+			case state := <-v.state:
+				if failedState(state) {
+					return fmt.Errorf("connection %s", state)
+				}
+			case <-client.Done():
+				return client.Err()
+			case <-timeout:
+				return fmt.Errorf("no %s received within %s", kind, v.options.Timeout)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (v *viewer) printStats() {
+	elapsed := time.Since(v.start).Round(time.Second)
+	for _, kind := range []webrtc.RTPCodecType{webrtc.RTPCodecTypeVideo, webrtc.RTPCodecTypeAudio} {
+		fmt.Printf("%s %s: %s\n", elapsed, kind, v.stats[kind])
+	}
+}
+
+// receive prints statistics until the duration has passed or the viewer is
+// interrupted. An error is returned if the stream ends before.
+func (v *viewer) receive(client signalling.Client) error {
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(interrupt)
+
+	var done <-chan time.Time
+	if v.options.Duration > 0 {
+		done = time.After(v.options.Duration)
+	}
+
+	ticker := time.NewTicker(viewStatsInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			v.printStats()
+		case state := <-v.state:
+			if failedState(state) {
+				return fmt.Errorf("connection %s", state)
+			}
+		case <-client.Done():
+			return client.Err()
+		case <-interrupt:
+			return nil
+		case <-done:
+			return nil
+		}
+	}
+}
+
+// close finishes the outputs, waiting for the pipeline to write its file
+func (v *viewer) close() {
+	v.readersLock.Lock()
+	v.closed = true
+	v.readersLock.Unlock()
+
+	if v.pc != nil {
+		v.pc.Close()
+	}
+	v.readers.Wait()
+
+	for _, writers := range v.writers {
+		for _, writer := range writers {
+			if err := writer.Close(); err != nil {
+				log.Println(err)
+			}
+		}
+	}
+
+	if v.pipeline != nil {
+		for _, source := range v.sources {
+			source.EndStream()
+		}
+
+		msg := v.pipeline.GetBus().TimedPopFiltered(gst.ClockTime(viewEosTimeout), gst.MessageEOS|gst.MessageError)
+		if msg != nil && msg.Type() == gst.MessageError {
+			log.Println(msg.ParseError())
+		}
+		v.pipeline.SetState(gst.StateNull)
+	}
+}
+
+// View receives a stream from a server and returns an error if it could not
+// be received for the whole duration
+func View(options *ViewOptions) error {
+	v := &viewer{
+		options: options,
+		writers: map[webrtc.RTPCodecType][]rtpWriter{},
+		sources: map[webrtc.RTPCodecType]*app.Source{},
+		stats: map[webrtc.RTPCodecType]*trackStats{
+			webrtc.RTPCodecTypeVideo: {first: make(chan struct{})},
+			webrtc.RTPCodecTypeAudio: {first: make(chan struct{})},
+		},
+		state: make(chan webrtc.PeerConnectionState, 8),
+	}
+	defer v.close()
+
+	if err := v.setupOutputs(); err != nil {
+		return err
+	}
+	if err := v.setupPeerConnection(); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), options.Timeout)
+	defer cancel()
+
+	header := http.Header{}
+	if options.Origin != "" {
+		header.Set("Origin", options.Origin)
+	}
+
+	v.start = time.Now()
+	client, err := signalling.Dial(ctx, options.Url, &signalling.Options{
+		User:       options.User,
+		Password:   options.Password,
+		Header:     header,
+		HttpClient: &http.Client{Timeout: options.Timeout},
+	})
+	if err != nil {
+		return err
+	}
+	defer client.Disconnect(context.Background())
+
+	if err := client.ConnectPeer(ctx, v.pc); err != nil {
+		return err
+	}
+	if err := v.waitForMedia(client); err != nil {
+		return err
+	}
+	log.Printf("Receiving media after %s\n", time.Since(v.start).Round(time.Millisecond))
+
+	err = v.receive(client)
+	v.printStats()
+	return err
+}
+
+// RunView runs the view command and exits with a non-zero code on failure
+func RunView(options *ViewOptions) {
+	if len(options.IceServers) == 0 {
+		options.IceServers = []string{DefaultViewIceServer}
+	}
+
+	if err := View(options); err != nil {
+		fmt.Fprintf(os.Stderr, "View failed: %v\n", err)
+		os.Exit(1)
+	}
+}
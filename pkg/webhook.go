@@ -0,0 +1,162 @@
+/*
+ Copyright (c) 2024 Neeraj Jakhar
+
+ This program is free software: you can redistribute it and/or modify
+ it under the terms of the GNU General Public License as published by
+ the Free Software Foundation, either version 3 of the License, or
+ (at your option) any later version.
+
+ This program is distributed in the hope that it will be useful,
+ but WITHOUT ANY WARRANTY; without even the implied warranty of
+ MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License
+ along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/homebackend/go-webrtc/pkg/config"
+)
+
+const (
+	webhookQueueSize      = 64
+	webhookMaxAttempts    = 5
+	webhookInitialBackoff = 2 * time.Second
+	webhookTimeout        = 10 * time.Second
+)
+
+const (
+	WebhookEventHeader     = "X-Gowebrtc-Event"
+	WebhookSignatureHeader = "X-Gowebrtc-Signature"
+	WebhookTimestampHeader = "X-Gowebrtc-Timestamp"
+)
+
+// Webhook posts the notifications it is interested in to its url. Each
+// webhook has its own bounded queue so that a slow or failing endpoint does
+// not hold up the others.
+type Webhook struct {
+	config *config.WebhookConfiguration
+	events map[string]bool
+	queue  chan Notification
+	client *http.Client
+	// Delay before the first retry, doubled for each further one
+	backoff time.Duration
+}
+
+// SetupWebhooks starts delivery of notifications to the configured webhooks
+func SetupWebhooks(config *config.Configuration, notifier *Notifier) {
+	for i := range config.Webhooks {
+		webhook := NewWebhook(&config.Webhooks[i])
+		notifier.Subscribe(webhook.enqueue)
+		go webhook.deliver()
+	}
+}
+
+func NewWebhook(config *config.WebhookConfiguration) *Webhook {
+	events := make(map[string]bool)
+	for _, event := range config.Events {
+		events[event] = true
+	}
+
+	return &Webhook{
+		config:  config,
+		events:  events,
+		queue:   make(chan Notification, webhookQueueSize),
+		client:  &http.Client{Timeout: webhookTimeout},
+		backoff: webhookInitialBackoff,
+	}
+}
+
+func (w *Webhook) enqueue(notification Notification) {
+	// No event filter means all events
+	if len(w.events) > 0 && !w.events[notification.Type] {
+		return
+	}
+
+	select {
+	case w.queue <- notification:
+	default:
+		log.Printf("Webhook queue for %s is full, dropping %s notification", w.config.Url, notification.Type)
+	}
+}
+
+func (w *Webhook) deliver() {
+	for notification := range w.queue {
+		body, err := json.Marshal(notification)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+
+		backoff := w.backoff
+		for attempt := 1; ; attempt++ {
+			retry, err := w.post(notification.Type, body)
+			if err == nil {
+				break
+			}
+
+			log.Printf("Webhook %s failed for %s notification (attempt %d): %v", w.config.Url, notification.Type, attempt, err)
+			if !retry || attempt == webhookMaxAttempts {
+				break
+			}
+
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+}
+
+// post sends the body and reports whether a failure is worth retrying
+func (w *Webhook) post(eventType string, body []byte) (bool, error) {
+	request, err := http.NewRequest(http.MethodPost, w.config.Url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(WebhookEventHeader, eventType)
+	if w.config.Secret != "" {
+		// The timestamp is signed along with the body so that receivers
+		// can reject replayed deliveries
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		request.Header.Set(WebhookTimestampHeader, timestamp)
+		request.Header.Set(WebhookSignatureHeader, "sha256="+sign(w.config.Secret, timestamp, body))
+	}
+
+	response, err := w.client.Do(request)
+	if err != nil {
+		return true, err
+	}
+	response.Body.Close()
+
+	if response.StatusCode >= 200 && response.StatusCode < 300 {
+		return false, nil
+	}
+
+	// Client errors other than rate limiting will not go away by retrying
+	retry := response.StatusCode >= 500 || response.StatusCode == http.StatusTooManyRequests
+	return retry, fmt.Errorf("unexpected status: %s", response.Status)
+}
+
+// sign returns the hex encoded HMAC-SHA256 of the timestamp, a dot and the
+// body
+func sign(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
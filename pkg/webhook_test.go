@@ -0,0 +1,168 @@
+/*
+ Copyright (c) 2024 Neeraj Jakhar
+
+ This program is free software: you can redistribute it and/or modify
+ it under the terms of the GNU General Public License as published by
+ the Free Software Foundation, either version 3 of the License, or
+ (at your option) any later version.
+
+ This program is distributed in the hope that it will be useful,
+ but WITHOUT ANY WARRANTY; without even the implied warranty of
+ MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License
+ along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/homebackend/go-webrtc/pkg/config"
+)
+
+type webhookRequest struct {
+	header http.Header
+	body   []byte
+	time   time.Time
+}
+
+// startWebhookServer records the requests it receives and answers them
+// with the statuses returned by status
+func startWebhookServer(t *testing.T, status func(request *http.Request) int) (*httptest.Server, chan webhookRequest) {
+	requests := make(chan webhookRequest, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+		}
+		requests <- webhookRequest{header: r.Header, body: body, time: time.Now()}
+		w.WriteHeader(status(r))
+	}))
+	t.Cleanup(server.Close)
+
+	return server, requests
+}
+
+func receiveRequest(t *testing.T, requests chan webhookRequest) webhookRequest {
+	t.Helper()
+
+	select {
+	case request := <-requests:
+		return request
+	case <-time.After(eventTimeout):
+		t.Fatal("Webhook was not called")
+		return webhookRequest{}
+	}
+}
+
+func TestWebhookSignature(t *testing.T) {
+	server, requests := startWebhookServer(t, func(*http.Request) int { return http.StatusNoContent })
+	webhook := NewWebhook(&config.WebhookConfiguration{Url: server.URL, Secret: "secret"})
+	go webhook.deliver()
+
+	webhook.enqueue(Notification{Type: NotificationMotionStarted, Time: time.Now()})
+	request := receiveRequest(t, requests)
+
+	if eventType := request.header.Get(WebhookEventHeader); eventType != NotificationMotionStarted {
+		t.Errorf("Unexpected event header: %s", eventType)
+	}
+
+	timestamp := request.header.Get(WebhookTimestampHeader)
+	if seconds, err := strconv.ParseInt(timestamp, 10, 64); err != nil || time.Since(time.Unix(seconds, 0)) > time.Minute {
+		t.Errorf("Unexpected timestamp: %s", timestamp)
+	}
+
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(request.body)
+	if signature := request.header.Get(WebhookSignatureHeader); signature != "sha256="+hex.EncodeToString(mac.Sum(nil)) {
+		t.Errorf("Unexpected signature: %s", signature)
+	}
+}
+
+func TestWebhookUnsigned(t *testing.T) {
+	server, requests := startWebhookServer(t, func(*http.Request) int { return http.StatusNoContent })
+	webhook := NewWebhook(&config.WebhookConfiguration{Url: server.URL})
+	go webhook.deliver()
+
+	webhook.enqueue(Notification{Type: NotificationMotionStarted, Time: time.Now()})
+	request := receiveRequest(t, requests)
+	if request.header.Get(WebhookSignatureHeader) != "" || request.header.Get(WebhookTimestampHeader) != "" {
+		t.Errorf("Delivery without secret is signed: %v", request.header)
+	}
+}
+
+func TestWebhookRetries(t *testing.T) {
+	attempts := 0
+	server, requests := startWebhookServer(t, func(r *http.Request) int {
+		// Client errors are not retried, server errors are
+		if r.Header.Get(WebhookEventHeader) == NotificationMotionStarted {
+			return http.StatusBadRequest
+		}
+
+		attempts++
+		if attempts < 3 {
+			return http.StatusServiceUnavailable
+		}
+		return http.StatusNoContent
+	})
+	webhook := NewWebhook(&config.WebhookConfiguration{Url: server.URL})
+	webhook.backoff = 50 * time.Millisecond
+	go webhook.deliver()
+
+	webhook.enqueue(Notification{Type: NotificationMotionStarted, Time: time.Now()})
+	webhook.enqueue(Notification{Type: NotificationMotionStopped, Time: time.Now()})
+
+	if request := receiveRequest(t, requests); request.header.Get(WebhookEventHeader) != NotificationMotionStarted {
+		t.Fatalf("Unexpected first delivery: %s", request.header.Get(WebhookEventHeader))
+	}
+
+	// The backoff doubles with every attempt
+	var previous time.Time
+	for i, backoff := range []time.Duration{0, webhook.backoff, 2 * webhook.backoff} {
+		request := receiveRequest(t, requests)
+		if eventType := request.header.Get(WebhookEventHeader); eventType != NotificationMotionStopped {
+			t.Fatalf("Unexpected delivery %d: %s", i, eventType)
+		}
+		if i > 0 && request.time.Sub(previous) < backoff {
+			t.Errorf("Attempt %d after %v, expected a backoff of %v", i+1, request.time.Sub(previous), backoff)
+		}
+		previous = request.time
+	}
+
+	select {
+	case request := <-requests:
+		t.Errorf("Unexpected delivery after success: %s", request.header.Get(WebhookEventHeader))
+	case <-time.After(4 * webhook.backoff):
+	}
+}
+
+func TestWebhookQueueOverflow(t *testing.T) {
+	webhook := NewWebhook(&config.WebhookConfiguration{Url: "http://localhost", Events: []string{NotificationMotionStarted}})
+
+	// Nothing is delivered, so the queue fills up
+	for i := 0; i < webhookQueueSize+5; i++ {
+		webhook.enqueue(Notification{Type: NotificationMotionStarted, Time: time.Now()})
+	}
+	if len(webhook.queue) != webhookQueueSize {
+		t.Errorf("Queue holds %d notifications, expected %d", len(webhook.queue), webhookQueueSize)
+	}
+
+	// Notifications not subscribed to are not queued at all
+	<-webhook.queue
+	webhook.enqueue(Notification{Type: NotificationSoundStarted, Time: time.Now()})
+	if len(webhook.queue) != webhookQueueSize-1 {
+		t.Errorf("Notification not subscribed to was queued")
+	}
+}
@@ -0,0 +1,176 @@
+<?php
+
+const SERVER = '<ip>';
+const HTTP_PORT = '<port>';
+const SSE_USERNAME = '<username>';
+const SSE_PASSWORD = '<password>';
+const SSE_URL = 'http://' . SERVER . ':' . HTTP_PORT . '/stream';
+
+?>
+<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>Video Stream</title>
+    <style>
+        body {
+            display: flex;
+            justify-content: center;
+            align-items: center;
+            height: 100vh;
+            margin: 0;
+            background-color: #000;
+        }
+        .video-container {
+            width: 640px;
+            height: 480px;
+            background-color: #000;
+        }
+
+        .video-container video {
+            width: 100%;
+            height: 100%;
+            object-fit: contain;
+        }
+    </style>
+</head>
+<body>
+<script>
+
+const sseUrl = '<?php echo SSE_URL; ?>';
+
+class Event {
+  constructor(type, payload) {
+    this.type = type;
+    this.payload = payload;
+  }
+}
+
+class ConnectEvent {
+  constructor(sdp, user, password) {
+    this.version = 1;
+    this.sdp = sdp;
+    this.user = user;
+    this.password = password;
+  }
+}
+
+// Events are posted one after another so that candidates never overtake the connect event
+let postQueue = Promise.resolve();
+
+function postEvent(session, event) {
+  postQueue = postQueue.then(() => sendEvent(session, event)).catch(e => console.log(e));
+  return postQueue;
+}
+
+function sendEvent(session, event) {
+  return fetch(sseUrl + '?session=' + encodeURIComponent(session), {
+    method: 'POST',
+    body: JSON.stringify(event),
+    headers: {
+      'Content-Type': 'application/json'
+    }
+  }).then(response => {
+    if (!response.ok) {
+      return response.json().then(error => console.log('Error: ' + error.payload.code + ': ' + error.payload.message));
+    }
+  });
+}
+
+function routeEvent(event, pc, remoteStream, session, source) {
+  console.log("Event type is: " + event.type)
+  switch (event.type) {
+    case 'session':
+      session.id = event.payload.session;
+      pc.createOffer().then(d => pc.setLocalDescription(d)).then(() => {
+        let sdp = btoa(JSON.stringify(pc.localDescription));
+        postEvent(session.id, new Event('connect', new ConnectEvent(sdp, '<?php echo SSE_USERNAME;?>', '<?php echo SSE_PASSWORD;?>')));
+      });
+      break;
+    case 'connect_ack':
+      console.log('Connected using protocol version: ' + event.payload.version);
+      break;
+    case 'answer':
+      pc.setRemoteDescription(new RTCSessionDescription(JSON.parse(atob(event.payload.answer))));
+      let p = document.getElementById('video-player')
+      p.srcObject = remoteStream;
+      p.onclick = function() {
+        p.play();
+        p.onclick = null;
+      }
+      break;
+    case 'new_candidate':
+      pc.addIceCandidate(event.payload.candidate).catch((e) => {
+        console.log(`Failure during addIceCandidate(): ${e.name}`);
+      });
+      break;
+    case 'state':
+      console.log('Session state: ' + event.payload.state + (event.payload.reason ? ' (' + event.payload.reason + ')' : ''));
+      break;
+    case 'error':
+      console.log('Error: ' + event.payload.code + ': ' + event.payload.message);
+      break;
+    case 'disconnect':
+      console.log('Closing connection');
+      source.close();
+      break;
+    default:
+      console.log("unsupported message type: " + event.type);
+      break;
+  }
+}
+
+window.startVideo = function () {
+  let remoteStream = new MediaStream()
+  let session = {id: null};
+
+  let pc = new RTCPeerConnection({
+    iceServers: [
+      {"urls":['stun:stun.l.google.com:19302']},
+    ]
+  });
+
+  pc.ontrack = function(event) {
+    event.streams[0].getTracks().forEach((track) => {
+      console.log('Track added');
+      remoteStream.addTrack(track)
+    })
+  }
+
+  pc.oniceconnectionstatechange = e => console.log(pc.iceConnectionState)
+  pc.onicecandidate = (event) => {
+    if (session.id !== null) {
+      // A null candidate signals the end of candidates
+      let candidate = event.candidate ? event.candidate.toJSON() : {candidate: ''};
+      postEvent(session.id, new Event('candidate', {candidate: candidate}));
+    }
+  }
+
+  // Offer to receive 1 audio, and 1 video track
+  pc.addTransceiver('video', {
+    'direction': 'sendrecv'
+  })
+  pc.addTransceiver('audio', {
+    'direction': 'sendrecv'
+  })
+
+  let source = new EventSource(sseUrl);
+  source.onmessage = function (evt) {
+    routeEvent(JSON.parse(evt.data), pc, remoteStream, session, source);
+  }
+  source.onerror = function (evt) {
+    console.log('Event stream error');
+  }
+}
+
+window.onload = function () {
+  window.startVideo();
+}
+    </script>
+
+    <div class="video-container">
+      <video preload="metadata" id="video-player"></video>
+    </div>
+</body>
+</html>
@@ -128,6 +128,7 @@ class Event {
 
 class ConnectEvent {
   constructor(sdp, user, password) {
+    this.version = 1;
     this.sdp = sdp;
     this.user = user;
     this.password = password;
@@ -145,6 +146,13 @@ class NewCandidateEvent {
   }
 }
 
+class StateEvent {
+  constructor(state, reason) {
+    this.state = state;
+    this.reason = reason;
+  }
+}
+
 function routeEvent(event, pc, remoteStream, conn) {
   if (event.type === undefined) {
     alert("no 'type' field in event");
@@ -163,7 +171,7 @@ function routeEvent(event, pc, remoteStream, conn) {
       break;
     case 'new_candidate':
       const candidateEvent = Object.assign(new NewCandidateEvent, event.payload);
-      pc.addIceCandidate(candidateEvent).catch((e) => {
+      pc.addIceCandidate(candidateEvent.candidate).catch((e) => {
         console.log(`Failure during addIceCandidate(): ${e.name}`);
       });
       break;
@@ -171,6 +179,25 @@ function routeEvent(event, pc, remoteStream, conn) {
       console.log('Closing connection');
       conn.close()
       break;
+    case 'connect_ack':
+      console.log('Connected using protocol version: ' + event.payload.version);
+      break;
+    case 'error':
+      console.log('Error: ' + event.payload.code + ': ' + event.payload.message);
+      break;
+    case 'state':
+      const stateEvent = Object.assign(new StateEvent, event.payload);
+      console.log('Session state: ' + stateEvent.state + (stateEvent.reason ? ' (' + stateEvent.reason + ')' : ''));
+      break;
+    case 'queue':
+      console.log('Waiting for a free slot, position: ' + event.payload.position);
+      break;
+    case 'kick':
+      console.log('Session ended: ' + event.payload.reason);
+      break;
+    case 'warning':
+      console.log('Session ends in ' + event.payload.seconds + ' seconds: ' + event.payload.reason);
+      break;
     default:
       alert("unsupported message type");
       break;
@@ -189,6 +216,13 @@ function connectWebsocket(pc, sdp, remoteStream) {
       conn.send(JSON.stringify(event))
     }
 
+    // Sessions of hidden tabs are ended after the idle timeout
+    document.addEventListener('visibilitychange', function () {
+      if (conn.readyState === WebSocket.OPEN) {
+        conn.send(JSON.stringify(new Event('visibility', {visible: document.visibilityState === 'visible'})));
+      }
+    });
+
     conn.onclose = function (evt) {
       console.log('Connected to Websocket: false');
     }